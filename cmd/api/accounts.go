@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Users are warned by email once their account expires within this window.
+const expiryWarningWindow = 7 * 24 * time.Hour
+
+// writeAccountStatus reloads the user after a status change and sends it back.
+func (app *application) writeAccountStatus(w http.ResponseWriter, r *http.Request, id int64) {
+	user, err := app.models.UserInfos.GetByID(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user.AdminView()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+
+	v := validator.New()
+	v.Check(input.Reason != "", "reason", "must be provided")
+	v.Check(len(input.Reason) <= 1000, "reason", "must not be more than 1000 bytes long")
+	v.Check(int64(admin.ID) != id, "id", "you can't suspend your own account")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.UserInfos.Suspend(id, int64(admin.ID), input.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeAccountStatus(w, r, id)
+}
+
+// reactivateUserHandler lifts a suspension. The expiry date is replaced by the one
+// in the request, so an expired account can only be reactivated with a new date in
+// the future or with none at all.
+func (app *application) reactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.ExpiresAt != nil {
+		v.Check(input.ExpiresAt.After(time.Now()), "expiresAt", "must be in the future")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.UserInfos.Reactivate(id, int64(app.contextGetUser(r).ID), input.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeAccountStatus(w, r, id)
+}
+
+// setUserExpiryHandler sets or, with "expiresAt": null, clears the date after which
+// the account can no longer sign in.
+func (app *application) setUserExpiryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+
+	v := validator.New()
+	if input.ExpiresAt != nil {
+		v.Check(input.ExpiresAt.After(time.Now()), "expiresAt", "must be in the future")
+	}
+	v.Check(int64(admin.ID) != id, "id", "you can't set an expiry on your own account")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.UserInfos.SetExpiry(id, int64(admin.ID), input.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeAccountStatus(w, r, id)
+}
+
+func (app *application) listAccountEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	events, err := app.models.UserInfos.GetAccountEvents(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"account_events": events}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// warnExpiringAccounts emails every user whose account expires within
+// expiryWarningWindow. Each user is only warned once per expiry date.
+func (app *application) warnExpiringAccounts() error {
+	users, err := app.models.UserInfos.ClaimExpiringAccounts(time.Now().Add(expiryWarningWindow))
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		app.notify(user, notification{
+			category: data.CategoryAccount,
+			subject:  "Your account is about to expire",
+			body:     "Dear " + user.Name + ",\n\nYour account expires on " + user.ExpiresAt.Format("2 January 2006 at 15:04 MST") + ". Please contact an administrator if you still need access after that.",
+		})
+	}
+
+	app.logger.PrintInfo("sent account expiry warnings", map[string]string{"count": strconv.Itoa(len(users))})
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/avatar"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/storage"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func avatarKey(userID int64, size string) string {
+	return fmt.Sprintf("avatars/%d/%s", userID, size)
+}
+
+// avatarVersion identifies the content of a thumbnail. It is both the ETag and the
+// v parameter of the links handed out on upload, so a new upload gets new links.
+func avatarVersion(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (app *application) uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// Leave some room for the multipart framing around the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.storage.maxAvatarBytes+4096)
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "http: request body too large"):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("avatar must not be larger than %d bytes", app.config.storage.maxAvatarBytes))
+		default:
+			app.badRequestResponse(w, r, errors.New("body must be multipart/form-data with an \"avatar\" file"))
+		}
+		return
+	}
+	defer file.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(file, app.config.storage.maxAvatarBytes+1))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(raw) > 0, "avatar", "must not be empty")
+	v.Check(int64(len(raw)) <= app.config.storage.maxAvatarBytes, "avatar", fmt.Sprintf("must not be larger than %d bytes", app.config.storage.maxAvatarBytes))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	thumbnails, err := avatar.Process(raw)
+	if err != nil {
+		switch {
+		case errors.Is(err, avatar.ErrUnsupportedType), errors.Is(err, avatar.ErrTooLarge):
+			v.AddError("avatar", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, thumbnail := range thumbnails {
+		err = app.storage.Put(avatarKey(int64(user.ID), thumbnail.Size), bytes.NewReader(thumbnail.Data))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	record := &data.Avatar{UserID: int64(user.ID), ContentType: thumbnails[0].ContentType}
+	err = app.models.Avatars.Upsert(record)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	links := make(map[string]string)
+	for _, thumbnail := range thumbnails {
+		links[thumbnail.Size] = fmt.Sprintf("/v1/users/%d/avatar?size=%s&v=%s", user.ID, thumbnail.Size, avatarVersion(thumbnail.Data))
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"avatar": links}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	size := app.readString(r.URL.Query(), "size", "medium")
+	if _, ok := avatar.Sizes[size]; !ok {
+		v := validator.New()
+		v.AddError("size", "must be one of small, medium or large")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	record, err := app.models.Avatars.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	f, err := app.storage.Get(avatarKey(id, size))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// A link carrying the current version always shows this picture and can be
+	// cached without asking again. The plain URL shows whatever was uploaded last,
+	// so caches have to revalidate it, which the ETag keeps cheap. The lifetime is
+	// capped at a day so that an erased avatar doesn't linger in caches.
+	// ServeContent answers If-None-Match and If-Modified-Since.
+	version := avatarVersion(content)
+	w.Header().Set("Content-Type", record.ContentType)
+	if r.URL.Query().Get("v") == version {
+		w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", etag(version))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", record.UpdatedAt, bytes.NewReader(content))
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/ical"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// calendarFeedTTL is how long a calendar feed URL works before a new one has to be
+// created.
+const calendarFeedTTL = 365 * 24 * time.Hour
+
+// buildCalendar turns the offerings, their weekly sessions and exams into a
+// calendar. Each session becomes an event repeating every week of its offering,
+// and each exam an event in its room. Offerings whose exam isn't booked yet get
+// an all-day exam event on their last day. UIDs are derived from the session,
+// exam and offering ids and sequences from their versions, so clients replace
+// events when they change.
+func (app *application) buildCalendar(name string, offerings []*data.Offering, sessions []*data.Session, exams []*data.ExamSession) *ical.Calendar {
+	host := "greenlight"
+	if u, err := url.Parse(app.config.baseURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+
+	calendar := &ical.Calendar{
+		ProdID: "-//Greenlight//Module Calendar " + version + "//EN",
+		Name:   name,
+	}
+
+	byID := make(map[int64]*data.Offering, len(offerings))
+	for _, offering := range offerings {
+		byID[offering.ID] = offering
+	}
+
+	for _, session := range sessions {
+		offering, ok := byID[session.OfferingID]
+		if !ok {
+			continue
+		}
+
+		weekday := time.Weekday(0)
+		for i, day := range data.Weekdays {
+			if day == session.Day {
+				weekday = time.Weekday((i + 1) % 7)
+			}
+		}
+
+		first := offering.StartsOn.Time
+		for first.Weekday() != weekday {
+			first = first.AddDate(0, 0, 1)
+		}
+		if first.After(offering.EndsOn.Time) {
+			continue
+		}
+
+		startsAt, _ := time.Parse("15:04", session.StartsAt)
+		endsAt, _ := time.Parse("15:04", session.EndsAt)
+		sinceMidnight := func(t time.Time) time.Duration {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		}
+
+		lastModified := session.UpdatedAt
+		if offering.UpdatedAt.After(lastModified) {
+			lastModified = offering.UpdatedAt
+		}
+
+		calendar.Events = append(calendar.Events, ical.Event{
+			UID: fmt.Sprintf("session-%d@%s", session.ID, host),
+			// Either the session or its offering's dates may change.
+			Sequence:     session.Version + offering.Version,
+			LastModified: lastModified,
+			Summary:      session.ModuleName,
+			Description:  fmt.Sprintf("Weekly session of %s, %s to %s.", session.ModuleName, offering.StartsOn, offering.EndsOn),
+			Location:     session.Room,
+			Start:        first.Add(sinceMidnight(startsAt)),
+			End:          first.Add(sinceMidnight(endsAt)),
+			RRule:        ical.Weekly(weekday, offering.EndsOn.Add(24*time.Hour-time.Second)),
+		})
+	}
+
+	booked := make(map[int64]bool, len(exams))
+	for _, exam := range exams {
+		booked[exam.OfferingID] = true
+
+		calendar.Events = append(calendar.Events, ical.Event{
+			UID:          fmt.Sprintf("exam-session-%d@%s", exam.ID, host),
+			Sequence:     exam.Version,
+			LastModified: exam.UpdatedAt,
+			Summary:      fmt.Sprintf("%s: %s exam", exam.ModuleName, exam.ExamType),
+			Description:  fmt.Sprintf("The %s exam of %s, %d minutes.", exam.ExamType, exam.ModuleName, exam.DurationMinutes),
+			Location:     exam.RoomName,
+			Start:        exam.StartsAt,
+			End:          exam.EndsAt,
+			UTC:          true,
+		})
+	}
+
+	for _, offering := range offerings {
+		if offering.ExamType == "" || booked[offering.ID] {
+			continue
+		}
+
+		calendar.Events = append(calendar.Events, ical.Event{
+			UID:          fmt.Sprintf("exam-%d@%s", offering.ID, host),
+			Sequence:     offering.Version,
+			LastModified: offering.UpdatedAt,
+			Summary:      fmt.Sprintf("%s: %s exam", offering.ModuleName, offering.ExamType),
+			Description:  fmt.Sprintf("The %s exam of %s is held at the end of the module.", offering.ExamType, offering.ModuleName),
+			Start:        offering.EndsOn.Time,
+			End:          offering.EndsOn.AddDate(0, 0, 1),
+			AllDay:       true,
+		})
+	}
+
+	return calendar
+}
+
+// writeCalendar sends the calendar of the offerings the filter picks, with their
+// sessions and exams. With an AttendeeID it includes the exams they invigilate.
+func (app *application) writeCalendar(w http.ResponseWriter, r *http.Request, name, filename string, f data.TimetableFilter) {
+	offerings, err := app.models.Offerings.GetForTimetable(f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions, err := app.models.Sessions.GetTimetable(f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	offeringIDs := make([]int64, 0, len(offerings))
+	for _, offering := range offerings {
+		offeringIDs = append(offeringIDs, offering.ID)
+	}
+
+	exams := []*data.ExamSession{}
+	if len(offeringIDs) > 0 {
+		exams, err = app.models.ExamSessions.GetAll(data.ExamFilter{OfferingIDs: offeringIDs, PublishedOnly: f.PublishedOnly})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if f.AttendeeID != 0 {
+		invigilated, err := app.models.ExamSessions.GetAll(data.ExamFilter{InvigilatorID: f.AttendeeID})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		seen := make(map[int64]bool, len(exams))
+		for _, exam := range exams {
+			seen[exam.ID] = true
+		}
+		for _, exam := range invigilated {
+			if !seen[exam.ID] {
+				exams = append(exams, exam)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+	w.Write(app.buildCalendar(name, offerings, sessions, exams).Encode())
+}
+
+// moduleCalendarHandler exports the sessions and exams of a module's offerings as
+// an iCalendar file. It takes ?term= like the module's timetable. Only admins get
+// the calendar of modules that aren't published.
+func (app *application) moduleCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	admin := app.contextGetUser(r).Role == data.Admin
+
+	var module *data.ModuleInfo
+	if admin {
+		module, err = app.models.ModuleInfos.Get(id)
+	} else {
+		module, err = app.models.ModuleInfos.GetEffective(id, time.Now())
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	termID := int64(app.readInt(r.URL.Query(), "term", 0, v))
+	v.Check(termID >= 0, "term", "must be a positive term id")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	f := data.TimetableFilter{ModuleID: id, TermID: termID, PublishedOnly: !admin}
+	app.writeCalendar(w, r, module.ModuleName, fmt.Sprintf("module-%d.ics", id), f)
+}
+
+// createCalendarFeedHandler creates the secret URL of a user's calendar feed,
+// which works without an Authorization header so calendar clients can subscribe
+// to it. Creating a new URL revokes the previous one. The id may be "me".
+func (app *application) createCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	_, err = app.models.UserInfos.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeCalendar, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(id, calendarFeedTTL, data.ScopeCalendar)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	feed := envelope{
+		"url":    app.config.baseURL + "/v1/calendar-feeds/" + token.Plaintext + ".ics",
+		"expiry": token.Expiry,
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"calendar_feed": feed}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeCalendarFeedHandler revokes the user's calendar feed URL.
+func (app *application) revokeCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeCalendar, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "calendar feed successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// calendarFeedHandler serves a user's calendar feed: the sessions and exams of the
+// offerings they teach or are enrolled in. The token in the URL stands in for the
+// user's credentials. Unknown, expired and revoked tokens, and tokens of users who
+// may no longer log in, all get a 404.
+func (app *application) calendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(httprouter.ParamsFromContext(r.Context()).ByName("token"), ".ics")
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.UserInfos.GetForToken(data.ScopeCalendar, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated || user.IsSuspended() || user.IsExpired() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	f := data.TimetableFilter{AttendeeID: int64(user.ID), PublishedOnly: user.Role != data.Admin}
+	app.writeCalendar(w, r, "Greenlight timetable", "timetable.ics", f)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
 	"net/http"
 )
 
@@ -63,3 +64,144 @@ func (app *application) getDepInfoHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 }
+
+func (app *application) listDepInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string
+		ModuleID int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.ModuleID = app.readInt(qs, "module", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "department_name", "staff_quantity", "-id", "-department_name", "-staff_quantity"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+
+	v.Check(input.ModuleID >= 0, "module", "must be a positive module id")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	depInfos, metadata, err := app.models.DepInfos.GetAll(input.Name, int64(input.ModuleID), input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"department_infos": depInfos, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// patchDepInfoHandler applies a JSON merge patch to a department: only the members
+// present in the body are changed and validated.
+func (app *application) patchDepInfoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	depInfo, err := app.models.DepInfos.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		DepartmentName     *string `json:"departmentName"`
+		DepartmentDirector *string `json:"departmentDirector"`
+		StaffQuantity      *int    `json:"staffQuantity"`
+		ModuleID           *int    `json:"moduleId"`
+		Version            *int    `json:"version"`
+	}
+
+	err = app.readMergePatch(w, r, &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedPatch):
+			app.unsupportedPatchResponse(w, r)
+		case errors.Is(err, errPatchTooLarge):
+			app.patchTooLargeResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != depInfo.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	if input.DepartmentName != nil {
+		v.Check(*input.DepartmentName != "", "departmentName", "must not be empty")
+		depInfo.DepartmentName = *input.DepartmentName
+	}
+	if input.DepartmentDirector != nil {
+		depInfo.DepartmentDirector = *input.DepartmentDirector
+	}
+	if input.StaffQuantity != nil {
+		v.Check(*input.StaffQuantity >= 0, "staffQuantity", "must not be negative")
+		depInfo.StaffQuantity = *input.StaffQuantity
+	}
+	if input.ModuleID != nil {
+		v.Check(*input.ModuleID > 0, "moduleId", "must be a positive module id")
+		depInfo.ModuleID = *input.ModuleID
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.DepInfos.Update(depInfo)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidReference):
+			v.AddError("moduleId", "must be an existing module id")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(depInfo.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"department_info": depInfo}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// readOfferingForEnrollment loads the offering named by the :id parameter. Only
+// admins can see offerings of modules that aren't in effect; anyone else gets a
+// 404 for them. false is returned whenever a response has been written.
+func (app *application) readOfferingForEnrollment(w http.ResponseWriter, r *http.Request) (*data.Offering, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	offering, err := app.models.Offerings.Get(id)
+	if err == nil && app.contextGetUser(r).Role != data.Admin {
+		_, err = app.models.ModuleInfos.GetEffective(offering.ModuleID, time.Now())
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return offering, true
+}
+
+// enrollHandler enrolls the authenticated user in the offering, or puts them on
+// its waitlist when it is full, and emails them about it.
+func (app *application) enrollHandler(w http.ResponseWriter, r *http.Request) {
+	offering, ok := app.readOfferingForEnrollment(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	enrollment, err := app.models.Enrollments.Enroll(offering.ID, int64(user.ID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAlreadyEnrolled):
+			app.errorResponse(w, r, http.StatusConflict, "you are already enrolled or on the waitlist")
+		case errors.Is(err, data.ErrEnrollmentClosed):
+			app.errorResponse(w, r, http.StatusConflict, fmt.Sprintf("enrollment closed at %s", offering.EnrollmentCloses().Format(time.RFC3339)))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.notifyEnrollment(user, enrollment, false)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/module-offerings/%d/enrollment", offering.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"enrollment": enrollment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getEnrollmentHandler shows the authenticated user their enrollment in the
+// offering, with their position while they are waitlisted.
+func (app *application) getEnrollmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	enrollment, err := app.models.Enrollments.Get(id, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"enrollment": enrollment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// dropEnrollmentHandler takes a user out of the offering or off its waitlist:
+// the authenticated user under /enrollment, anyone under /enrollments/:user_id
+// for admins. Freed seats go to the waitlist, whose promoted users are emailed.
+func (app *application) dropEnrollmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID := int64(app.contextGetUser(r).ID)
+	if param := httprouter.ParamsFromContext(r.Context()).ByName("user_id"); param != "" {
+		userID, err = strconv.ParseInt(param, 10, 64)
+		if err != nil || userID < 1 {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	promoted, err := app.models.Enrollments.Drop(id, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.notifyPromotions(promoted)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "enrollment successfully dropped"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOfferingEnrollmentsHandler returns the roster and waitlist of an offering
+// to admins and the offering's teacher.
+func (app *application) listOfferingEnrollmentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	offering, err := app.models.Offerings.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+	teacher := offering.TeacherID != nil && *offering.TeacherID == int64(user.ID)
+	if !teacher && user.Role != data.Admin {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	status := app.readString(r.URL.Query(), "status", "")
+	if status != "" {
+		v.Check(validator.PermittedValue(status, data.EnrollmentStatuses...), "status", "must be an enrollment status")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	enrollments, err := app.models.Enrollments.GetForOffering(offering.ID, status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"enrollments": enrollments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listUserEnrollmentsHandler returns a user's enrollments to themselves and to
+// admins. The id may be "me".
+func (app *application) listUserEnrollmentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	status := app.readString(r.URL.Query(), "status", "")
+	if status != "" {
+		v.Check(validator.PermittedValue(status, data.EnrollmentStatuses...), "status", "must be an enrollment status")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	enrollments, err := app.models.Enrollments.GetForUser(id, status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"enrollments": enrollments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// notifyEnrollment tells the user they were enrolled in the offering or, if they
+// have to wait for a seat, where they are on the waitlist. promoted is set when
+// they got the seat they were waiting for.
+func (app *application) notifyEnrollment(user *data.UserInfo, enrollment *data.Enrollment, promoted bool) {
+	n := notification{
+		category: data.CategoryEnrollment,
+		subject:  "Enrolled: " + enrollment.ModuleName,
+		body:     fmt.Sprintf("Dear %s,\n\nYou are enrolled in the module %q.", user.Name, enrollment.ModuleName),
+	}
+
+	switch {
+	case promoted:
+		n.body = fmt.Sprintf("Dear %s,\n\nA seat has become free in the module %q and you have been enrolled from the waitlist.", user.Name, enrollment.ModuleName)
+	case enrollment.Status == data.EnrollmentWaitlisted:
+		n.subject = "Waitlisted: " + enrollment.ModuleName
+		n.body = fmt.Sprintf("Dear %s,\n\nThe module %q is full, so you have been put on its waitlist at position %d. We will email you if a seat becomes free.", user.Name, enrollment.ModuleName, *enrollment.Position)
+	}
+
+	app.notify(user, n)
+}
+
+// notifyPromotions emails the users who were enrolled from a waitlist.
+func (app *application) notifyPromotions(promoted []*data.Enrollment) {
+	if len(promoted) == 0 {
+		return
+	}
+
+	app.background(func() {
+		for _, enrollment := range promoted {
+			user, err := app.models.UserInfos.GetByID(enrollment.UserID)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"enrollment_id": strconv.FormatInt(enrollment.ID, 10)})
+				continue
+			}
+			app.notifyEnrollment(user, enrollment, true)
+		}
+	})
+}
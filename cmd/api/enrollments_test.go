@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// insertTestOffering adds a module, a term and an offering of the module in the
+// term with the given capacity and enrollment deadline.
+func insertTestOffering(t *testing.T, app *application, capacity int, deadline *time.Time) *data.Offering {
+	t.Helper()
+
+	module := &data.ModuleInfo{ModuleName: "Databases", ContactHours: 30, ExamType: "written"}
+	err := app.models.ModuleInfos.Insert(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := data.NewDate(time.Now().AddDate(0, 1, 0))
+	end := data.NewDate(start.AddDate(0, 4, 0))
+
+	term := &data.Term{Name: "Fall", AcademicYear: "2026-2027", StartsOn: start, EndsOn: end}
+	err = app.models.Terms.Insert(term)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offering := &data.Offering{
+		ModuleID:           int64(module.ID),
+		TermID:             term.ID,
+		Capacity:           capacity,
+		StartsOn:           start,
+		EndsOn:             end,
+		EnrollmentDeadline: deadline,
+	}
+	err = app.models.Offerings.Insert(offering)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offering
+}
+
+// insertTestStudents adds n registered users and returns their IDs.
+func insertTestStudents(t *testing.T, app *application, n int) []int64 {
+	t.Helper()
+
+	ids := make([]int64, n)
+	for i := range ids {
+		user, _ := insertTestUser(t, app, fmt.Sprintf("student%d@example.com", i), data.Registered, "student-pa55word")
+		ids[i] = int64(user.ID)
+	}
+	return ids
+}
+
+// waitlist returns the waitlisted users of the offering in order, checking that
+// their positions count up from 1.
+func waitlist(t *testing.T, app *application, offeringID int64) []int64 {
+	t.Helper()
+
+	enrollments, err := app.models.Enrollments.GetForOffering(offeringID, data.EnrollmentWaitlisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int64{}
+	for i, e := range enrollments {
+		if e.Position == nil || *e.Position != i+1 {
+			t.Errorf("user %d is at position %v of the waitlist, want %d", e.UserID, e.Position, i+1)
+		}
+		ids = append(ids, e.UserID)
+	}
+	return ids
+}
+
+// TestEnrollConcurrently enrolls more users than there are seats at the same time
+// and checks that the offering isn't overbooked and the rest are waitlisted.
+func TestEnrollConcurrently(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+
+	const capacity = 3
+	offering := insertTestOffering(t, app, capacity, nil)
+	students := insertTestStudents(t, app, 8)
+
+	start := make(chan struct{})
+	errs := make([]error, len(students))
+	var wg sync.WaitGroup
+	for i, id := range students {
+		wg.Add(1)
+		go func(i int, id int64) {
+			defer wg.Done()
+			<-start
+			_, errs[i] = app.models.Enrollments.Enroll(offering.ID, id)
+		}(i, id)
+	}
+	close(start)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("enroll user %d: %v", students[i], err)
+		}
+	}
+
+	enrolled, err := app.models.Enrollments.GetForOffering(offering.ID, data.EnrollmentEnrolled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enrolled) != capacity {
+		t.Errorf("got %d users enrolled, want %d", len(enrolled), capacity)
+	}
+	if got := waitlist(t, app, offering.ID); len(got) != len(students)-capacity {
+		t.Errorf("got %d users waitlisted, want %d", len(got), len(students)-capacity)
+	}
+}
+
+// TestEnrollmentWaitlist checks that the waitlist is first come, first served,
+// and that it moves up when somebody drops out and when seats are added.
+func TestEnrollmentWaitlist(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+
+	offering := insertTestOffering(t, app, 1, nil)
+	students := insertTestStudents(t, app, 4)
+
+	for i, id := range students {
+		enrollment, err := app.models.Enrollments.Enroll(offering.ID, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := data.EnrollmentWaitlisted
+		if i == 0 {
+			want = data.EnrollmentEnrolled
+		}
+		if enrollment.Status != want {
+			t.Fatalf("user %d: got status %q, want %q", i, enrollment.Status, want)
+		}
+		if i > 0 && (enrollment.Position == nil || *enrollment.Position != i) {
+			t.Errorf("user %d: got position %v, want %d", i, enrollment.Position, i)
+		}
+	}
+
+	_, err := app.models.Enrollments.Enroll(offering.ID, students[1])
+	if !errors.Is(err, data.ErrAlreadyEnrolled) {
+		t.Errorf("enrolling twice: got error %v, want %v", err, data.ErrAlreadyEnrolled)
+	}
+
+	// Dropping out gives the seat to the first user waiting.
+	promoted, err := app.models.Enrollments.Drop(offering.ID, students[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(promoted) != 1 || promoted[0].UserID != students[1] || promoted[0].Status != data.EnrollmentEnrolled {
+		t.Errorf("drop: got promoted %v, want user %d enrolled", promoted, students[1])
+	}
+	if got := waitlist(t, app, offering.ID); fmt.Sprint(got) != fmt.Sprint(students[2:]) {
+		t.Errorf("after drop: got waitlist %v, want %v", got, students[2:])
+	}
+
+	// Raising the capacity gives the new seats to the users waiting longest.
+	offering, err = app.models.Offerings.Get(offering.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offering.Capacity = 2
+	err = app.models.Offerings.Update(offering)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	promoted, err = app.models.Enrollments.Promote(offering.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(promoted) != 1 || promoted[0].UserID != students[2] {
+		t.Errorf("capacity raise: got promoted %v, want user %d", promoted, students[2])
+	}
+	if got := waitlist(t, app, offering.ID); fmt.Sprint(got) != fmt.Sprint(students[3:]) {
+		t.Errorf("after capacity raise: got waitlist %v, want %v", got, students[3:])
+	}
+}
+
+// TestEnrollAfterDeadline checks that enrollment closes at the deadline.
+func TestEnrollAfterDeadline(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+
+	deadline := time.Now().Add(-time.Hour)
+	offering := insertTestOffering(t, app, 10, &deadline)
+	students := insertTestStudents(t, app, 1)
+
+	_, err := app.models.Enrollments.Enroll(offering.ID, students[0])
+	if !errors.Is(err, data.ErrEnrollmentClosed) {
+		t.Errorf("got error %v, want %v", err, data.ErrEnrollmentClosed)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
 	"net/http"
 )
 
@@ -68,6 +69,31 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// preconditionRequiredResponse asks the client to send If-Match or a version with
+// its update, as RFC 6585 describes for 428.
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "updates must carry an If-Match header or a version field, use If-Match: * to overwrite any version"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
+// unsupportedPatchResponse tells the client which patch format PATCH requests
+// accept, as RFC 5789 suggests.
+func (app *application) unsupportedPatchResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", acceptedPatchTypes)
+	message := "PATCH requests must be sent as application/merge-patch+json or application/json"
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) patchTooLargeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("PATCH requests must not be larger than %d bytes", maxPatchBytes)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+func (app *application) unrenderablePDFResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the document has characters the PDF fonts can't show; ask for it as JSON instead"
+	app.errorResponse(w, r, http.StatusNotAcceptable, message)
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -88,3 +114,51 @@ func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request
 	message := "forbidden 404 response"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) accountSuspendedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) accountExpiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has expired"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// sessionConflictResponse lists the sessions a booking clashes with, so the client
+// can show what is already in the room or the teacher's timetable.
+func (app *application) sessionConflictResponse(w http.ResponseWriter, r *http.Request, conflicts []*data.Session) {
+	env := envelope{
+		"error":     "the room or the teacher is already booked at this time",
+		"conflicts": conflicts,
+	}
+	err := app.writeJSON(w, http.StatusConflict, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+// examConflictResponse lists the exams a booking clashes with in its room, for
+// its invigilators or for its candidates.
+func (app *application) examConflictResponse(w http.ResponseWriter, r *http.Request, conflicts []*data.ExamSession) {
+	env := envelope{
+		"error":     "the room, an invigilator or some candidates already have an exam at this time",
+		"conflicts": conflicts,
+	}
+	err := app.writeJSON(w, http.StatusConflict, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
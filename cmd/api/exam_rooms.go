@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+)
+
+func (app *application) createExamRoomHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string `json:"name"`
+		Capacity int    `json:"capacity"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	room := &data.ExamRoom{
+		Name:     input.Name,
+		Capacity: input.Capacity,
+	}
+
+	v := validator.New()
+	if data.ValidateExamRoom(v, room); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ExamRooms.Insert(room)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateExamRoom):
+			v.AddError("name", "an exam room with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/exam-rooms/%d", room.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"exam_room": room}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listExamRoomsHandler lists the exam rooms, smallest first, optionally only those
+// seating ?min_capacity= candidates.
+func (app *application) listExamRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	minCapacity := app.readInt(r.URL.Query(), "min_capacity", 0, v)
+	v.Check(minCapacity >= 0, "min_capacity", "must not be negative")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rooms, err := app.models.ExamRooms.GetAll(minCapacity)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exam_rooms": rooms}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateExamRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	room, err := app.models.ExamRooms.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name     *string `json:"name"`
+		Capacity *int    `json:"capacity"`
+		Version  *int    `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != room.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Name != nil {
+		room.Name = *input.Name
+	}
+	if input.Capacity != nil {
+		room.Capacity = *input.Capacity
+	}
+
+	v := validator.New()
+	if data.ValidateExamRoom(v, room); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ExamRooms.Update(room)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateExamRoom):
+			v.AddError("name", "an exam room with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(room.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exam_room": room}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteExamRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.ExamRooms.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrExamRoomInUse):
+			app.errorResponse(w, r, http.StatusConflict, "exams are still booked in the room")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "exam room successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
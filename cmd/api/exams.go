@@ -0,0 +1,421 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+// checkInvigilators adds a validation error unless every id is an existing user.
+// It returns false if it wrote an error response.
+func (app *application) checkInvigilators(w http.ResponseWriter, r *http.Request, v *validator.Validator, ids []int64) bool {
+	for _, id := range ids {
+		_, err := app.models.UserInfos.GetByID(id)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invigilatorIds", fmt.Sprintf("must contain existing user ids, %d is not one", id))
+			return true
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+	}
+	return true
+}
+
+// saveExam validates the exam, its room and invigilators and saves it with save,
+// writing the response for any error. It returns false if it wrote one.
+func (app *application) saveExam(w http.ResponseWriter, r *http.Request, exam *data.ExamSession, save func(*data.ExamSession) error) bool {
+	v := validator.New()
+	if data.ValidateExamSession(v, exam); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	_, err := app.models.ExamRooms.Get(exam.RoomID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("roomId", "must be an existing exam room id")
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !app.checkInvigilators(w, r, v, exam.InvigilatorIDs) {
+		return false
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	err = save(exam)
+	if err != nil {
+		var conflictErr *data.ExamConflictError
+		switch {
+		case errors.As(err, &conflictErr):
+			app.examConflictResponse(w, r, conflictErr.Conflicts)
+		case errors.Is(err, data.ErrExamRoomTooSmall):
+			v.AddError("roomId", fmt.Sprintf("must seat the %d enrolled candidates", exam.Candidates))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateExam):
+			app.errorResponse(w, r, http.StatusConflict, "the offering already has an exam session")
+		case errors.Is(err, data.ErrInvalidReference):
+			v.AddError("invigilatorIds", "must contain existing user ids")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+	return true
+}
+
+// createExamHandler books the exam of an offering. It is invigilated by the
+// offering's teacher unless invigilatorIds says otherwise. Exams that would put
+// a room, an invigilator or a candidate in two exams at once are rejected with
+// 409 and the exams they clash with.
+func (app *application) createExamHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	offering, err := app.models.Offerings.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		StartsAt        time.Time `json:"startsAt"`
+		DurationMinutes int       `json:"durationMinutes"`
+		RoomID          int64     `json:"roomId"`
+		InvigilatorIDs  []int64   `json:"invigilatorIds"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	exam := &data.ExamSession{
+		OfferingID:      offering.ID,
+		StartsAt:        input.StartsAt,
+		DurationMinutes: input.DurationMinutes,
+		RoomID:          input.RoomID,
+		InvigilatorIDs:  input.InvigilatorIDs,
+	}
+	if exam.InvigilatorIDs == nil && offering.TeacherID != nil {
+		exam.InvigilatorIDs = []int64{*offering.TeacherID}
+	}
+
+	if !app.saveExam(w, r, exam, app.models.ExamSessions.Insert) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/exam-sessions/%d", exam.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"exam_session": exam}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getOfferingExamHandler returns the exam of an offering. Like enrollment, it is
+// hidden from all but admins while the offering's module isn't in effect.
+func (app *application) getOfferingExamHandler(w http.ResponseWriter, r *http.Request) {
+	offering, ok := app.readOfferingForEnrollment(w, r)
+	if !ok {
+		return
+	}
+
+	exam, err := app.models.ExamSessions.GetForOffering(offering.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(exam.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exam_session": exam}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getExamHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	exam, err := app.models.ExamSessions.Get(id)
+	if err == nil && app.contextGetUser(r).Role != data.Admin {
+		_, err = app.models.ModuleInfos.GetEffective(exam.ModuleID, time.Now())
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(exam.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exam_session": exam}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listExamsHandler lists exams in the order they are sat, optionally of a term
+// (?term=), in a room (?room=) or invigilated by a user (?invigilator=, which may
+// be "me"). Only admins and the invigilator whose duties they are see the exams
+// of modules that aren't published.
+func (app *application) listExamsHandler(w http.ResponseWriter, r *http.Request) {
+	var f data.ExamFilter
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	user := app.contextGetUser(r)
+
+	f.TermID = int64(app.readInt(qs, "term", 0, v))
+	v.Check(f.TermID >= 0, "term", "must be a positive term id")
+
+	f.RoomID = int64(app.readInt(qs, "room", 0, v))
+	v.Check(f.RoomID >= 0, "room", "must be a positive exam room id")
+
+	if app.readString(qs, "invigilator", "") == "me" {
+		f.InvigilatorID = int64(user.ID)
+	} else {
+		f.InvigilatorID = int64(app.readInt(qs, "invigilator", 0, v))
+		v.Check(f.InvigilatorID >= 0, "invigilator", "must be a positive user id or me")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	f.PublishedOnly = user.Role != data.Admin && f.InvigilatorID != int64(user.ID)
+
+	exams, err := app.models.ExamSessions.GetAll(f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exam_sessions": exams}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateExamHandler moves an exam to the time, room or invigilators present in
+// the body.
+func (app *application) updateExamHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	exam, err := app.models.ExamSessions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		StartsAt        *time.Time `json:"startsAt"`
+		DurationMinutes *int       `json:"durationMinutes"`
+		RoomID          *int64     `json:"roomId"`
+		InvigilatorIDs  []int64    `json:"invigilatorIds"`
+		Version         *int       `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != exam.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.StartsAt != nil {
+		exam.StartsAt = *input.StartsAt
+	}
+	if input.DurationMinutes != nil {
+		exam.DurationMinutes = *input.DurationMinutes
+	}
+	if input.RoomID != nil {
+		exam.RoomID = *input.RoomID
+	}
+	if input.InvigilatorIDs != nil {
+		exam.InvigilatorIDs = input.InvigilatorIDs
+	}
+
+	if !app.saveExam(w, r, exam, app.models.ExamSessions.Update) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(exam.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"exam_session": exam}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteExamHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.ExamSessions.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "exam session successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// generateExamTimetableHandler builds the handlers that place the exams of a
+// term's offerings in the given slots, rooms and invigilators. The preview
+// (dryRun) only shows where the exams would go; otherwise they are booked. Both
+// report the exams that couldn't be placed and why, and the exams of the term
+// whose candidates have outgrown their room.
+func (app *application) generateExamTimetableHandler(dryRun bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input data.ExamGeneration
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.DurationMinutes == 0 {
+			input.DurationMinutes = 120
+		}
+		if input.CandidatesPerInvigilator == 0 {
+			input.CandidatesPerInvigilator = 30
+		}
+
+		v := validator.New()
+		if data.ValidateExamGeneration(v, &input); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		_, err = app.models.Terms.Get(input.TermID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("termId", "must be an existing term id")
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		rooms, err := app.models.ExamRooms.GetAll(0)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		known := make(map[int64]bool, len(rooms))
+		for _, room := range rooms {
+			known[room.ID] = true
+		}
+		for _, id := range input.RoomIDs {
+			if !known[id] {
+				v.AddError("roomIds", fmt.Sprintf("must contain existing exam room ids, %d is not one", id))
+			}
+		}
+
+		if !app.checkInvigilators(w, r, v, input.InvigilatorIDs) {
+			return
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		timetable, err := app.models.ExamSessions.Generate(&input, dryRun)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrInvalidReference):
+				v.AddError("invigilatorIds", "must contain existing user ids")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		status := http.StatusOK
+		if !dryRun && len(timetable.ExamSessions) > 0 {
+			status = http.StatusCreated
+		}
+
+		err = app.writeJSON(w, status, envelope{"exam_timetable": timetable}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+)
+
+// writeGradeScaleError writes the response for an error returned when saving a
+// grade scale.
+func (app *application) writeGradeScaleError(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, data.ErrDuplicateGradeScale):
+		v.AddError("name", "a grade scale with this name already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrDuplicateDefaultScale):
+		v.AddError("isDefault", "another grade scale is already the default")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createGradeScaleHandler adds a grade scale. Its bands map final percentages to
+// letters and points; one of them must start at 0.
+func (app *application) createGradeScaleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string           `json:"name"`
+		IsDefault bool             `json:"isDefault"`
+		Bands     []data.GradeBand `json:"bands"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	scale := &data.GradeScale{
+		Name:      input.Name,
+		IsDefault: input.IsDefault,
+		Bands:     input.Bands,
+	}
+
+	v := validator.New()
+	if data.ValidateGradeScale(v, scale); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.GradeScales.Insert(scale)
+	if err != nil {
+		app.writeGradeScaleError(w, r, v, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/grade-scales/%d", scale.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"grade_scale": scale}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getGradeScaleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	scale, err := app.models.GradeScales.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(scale.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"grade_scale": scale}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listGradeScalesHandler(w http.ResponseWriter, r *http.Request) {
+	scales, err := app.models.GradeScales.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"grade_scales": scales}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateGradeScaleHandler changes the fields present in the body. bands replaces
+// all of the scale's bands. Final grades follow the new bands.
+func (app *application) updateGradeScaleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	scale, err := app.models.GradeScales.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name      *string          `json:"name"`
+		IsDefault *bool            `json:"isDefault"`
+		Bands     []data.GradeBand `json:"bands"`
+		Version   *int             `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != scale.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Name != nil {
+		scale.Name = *input.Name
+	}
+	if input.IsDefault != nil {
+		scale.IsDefault = *input.IsDefault
+	}
+	if input.Bands != nil {
+		scale.Bands = input.Bands
+	}
+
+	v := validator.New()
+	if data.ValidateGradeScale(v, scale); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.GradeScales.Update(scale)
+	if err != nil {
+		app.writeGradeScaleError(w, r, v, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(scale.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"grade_scale": scale}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteGradeScaleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.GradeScales.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "grade scale successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
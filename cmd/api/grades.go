@@ -0,0 +1,524 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScoresCSVBytes bounds the size of a CSV score upload.
+const maxScoresCSVBytes = 1 << 20
+
+// writeComponentError writes the response for an error returned when saving an
+// assessment component.
+func (app *application) writeComponentError(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, data.ErrDuplicateComponent):
+		v.AddError("name", "the module already has a component with this name")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrInvalidReference):
+		v.AddError("moduleId", "must refer to an existing module")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createComponentHandler adds an assessment component to a module.
+func (app *application) createComponentHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ModuleID int64   `json:"moduleId"`
+		Name     string  `json:"name"`
+		Weight   float64 `json:"weight"`
+		MaxScore float64 `json:"maxScore"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	_, err = app.models.ModuleInfos.Get(input.ModuleID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("moduleId", "must refer to an existing module")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	component := &data.AssessmentComponent{
+		ModuleID: input.ModuleID,
+		Name:     input.Name,
+		Weight:   input.Weight,
+		MaxScore: input.MaxScore,
+	}
+
+	if data.ValidateAssessmentComponent(v, component); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Assessments.Insert(component)
+	if err != nil {
+		app.writeComponentError(w, r, v, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/assessment-components/%d", component.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"assessment_component": component}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listComponentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.ModuleInfos.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	components, err := app.models.Assessments.GetForModule(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"assessment_components": components}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateComponentHandler changes the fields present in the body. Final grades are
+// computed when they are read, so they follow the new weight and maximum.
+func (app *application) updateComponentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	component, err := app.models.Assessments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name     *string  `json:"name"`
+		Weight   *float64 `json:"weight"`
+		MaxScore *float64 `json:"maxScore"`
+		Version  *int     `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != component.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Name != nil {
+		component.Name = *input.Name
+	}
+	if input.Weight != nil {
+		component.Weight = *input.Weight
+	}
+	if input.MaxScore != nil {
+		component.MaxScore = *input.MaxScore
+	}
+
+	v := validator.New()
+	if data.ValidateAssessmentComponent(v, component); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Assessments.Update(component)
+	if err != nil {
+		app.writeComponentError(w, r, v, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(component.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"assessment_component": component}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteComponentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Assessments.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrComponentInUse):
+			app.errorResponse(w, r, http.StatusConflict, "the component has scores and can't be deleted")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "assessment component successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOfferingForGrading reads the offering in the path and checks that the
+// authenticated user is its teacher or an admin. It writes the response and
+// returns nil when they may not grade it.
+func (app *application) readOfferingForGrading(w http.ResponseWriter, r *http.Request) *data.Offering {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	offering, err := app.models.Offerings.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	user := app.contextGetUser(r)
+	teacher := offering.TeacherID != nil && *offering.TeacherID == int64(user.ID)
+	if !teacher && user.Role != data.Admin {
+		app.forbiddenResponse(w, r)
+		return nil
+	}
+	return offering
+}
+
+// readScoresCSV reads a score upload whose header is user_id followed by the names
+// of the module's components, matched case-insensitively, with one row of scores
+// per student. Empty cells are left as they are. The returned keys name the line
+// each entry came from, for error messages.
+func readScoresCSV(body io.Reader, components []*data.AssessmentComponent, v *validator.Validator) ([]data.ScoreEntry, []string, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, errors.New("body must not be empty")
+		}
+		return nil, nil, err
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(header[0], "\ufeff")), "user_id") {
+		v.AddError("header", "must start with a user_id column")
+		return nil, nil, nil
+	}
+
+	columns := make([]int64, len(header))
+	for i, name := range header[1:] {
+		name = strings.TrimSpace(name)
+		for _, c := range components {
+			if strings.EqualFold(c.Name, name) {
+				columns[i+1] = c.ID
+			}
+		}
+		v.Check(columns[i+1] != 0, "header", fmt.Sprintf("names no component of the module: %q", name))
+	}
+	v.Check(validator.Unique(columns[1:]), "header", "must not name a component twice")
+	if !v.Valid() {
+		return nil, nil, nil
+	}
+
+	var entries []data.ScoreEntry
+	var keys []string
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		key := fmt.Sprintf("line %d", line)
+
+		userID, err := strconv.ParseInt(strings.TrimSpace(record[0]), 10, 64)
+		if err != nil || userID < 1 {
+			v.AddError(key, "must start with a user id")
+			continue
+		}
+
+		for i, cell := range record[1:] {
+			cell = strings.TrimSpace(cell)
+			if cell == "" {
+				continue
+			}
+			score, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				v.AddError(key, fmt.Sprintf("has a score that is not a number: %q", cell))
+				continue
+			}
+			entries = append(entries, data.ScoreEntry{UserID: userID, ComponentID: columns[i+1], Score: score})
+			keys = append(keys, key)
+		}
+	}
+
+	return entries, keys, nil
+}
+
+// enterScoresHandler enters scores for the students of an offering. Its teacher
+// and admins send them as JSON, or as CSV with a text/csv Content-Type. Once the
+// offering's grades are locked only admins can change them, and must give a
+// reason, which is kept with each change.
+func (app *application) enterScoresHandler(w http.ResponseWriter, r *http.Request) {
+	offering := app.readOfferingForGrading(w, r)
+	if offering == nil {
+		return
+	}
+
+	components, err := app.models.Assessments.GetForModule(offering.ModuleID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	var entries []data.ScoreEntry
+	var keys []string
+	var reason string
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxScoresCSVBytes)
+
+		entries, keys, err = readScoresCSV(r.Body, components, v)
+		if err != nil {
+			switch {
+			case strings.Contains(err.Error(), "http: request body too large"):
+				app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("body must not be larger than %d bytes", maxScoresCSVBytes))
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+		reason = app.readString(r.URL.Query(), "reason", "")
+	} else {
+		var input struct {
+			Scores []data.ScoreEntry `json:"scores"`
+			Reason string            `json:"reason"`
+		}
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		entries = input.Scores
+		for i := range entries {
+			keys = append(keys, fmt.Sprintf("scores[%d]", i))
+		}
+		reason = input.Reason
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	enrollments, err := app.models.Enrollments.GetForOffering(offering.ID, data.EnrollmentEnrolled)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	enrolled := make(map[int64]bool, len(enrollments))
+	for _, e := range enrollments {
+		enrolled[e.UserID] = true
+	}
+	byID := make(map[int64]*data.AssessmentComponent, len(components))
+	for _, c := range components {
+		byID[c.ID] = c
+	}
+
+	v.Check(len(entries) > 0, "scores", "must contain at least one score")
+	v.Check(len(entries) <= 10000, "scores", "must not contain more than 10000 scores")
+
+	type scoreKey struct{ userID, componentID int64 }
+	seen := make(map[scoreKey]bool, len(entries))
+
+	for i, entry := range entries {
+		key := keys[i]
+		component, ok := byID[entry.ComponentID]
+		if !ok {
+			v.AddError(key, "must name a component of the module")
+			continue
+		}
+		v.Check(enrolled[entry.UserID], key, "must name a student enrolled in the offering")
+		v.Check(entry.Score >= 0 && entry.Score <= component.MaxScore, key, fmt.Sprintf("must have a score between 0 and %g", component.MaxScore))
+		v.Check(!seen[scoreKey{entry.UserID, entry.ComponentID}], key, "must not repeat a score for the same student and component")
+		seen[scoreKey{entry.UserID, entry.ComponentID}] = true
+	}
+
+	user := app.contextGetUser(r)
+	admin := user.Role == data.Admin
+
+	reason = strings.TrimSpace(reason)
+	v.Check(len(reason) <= 1000, "reason", "must not be more than 1000 bytes long")
+
+	if offering.GradesLocked(time.Now()) {
+		if !admin {
+			app.errorResponse(w, r, http.StatusConflict, "the offering's grades are locked; only an admin can change them")
+			return
+		}
+		v.Check(reason != "", "reason", "must be provided to change locked grades")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Only admins can override locked grades.
+	if !admin {
+		reason = ""
+	}
+
+	err = app.models.Grades.SaveScores(offering.ID, entries, int64(user.ID), reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrGradesLocked):
+			app.errorResponse(w, r, http.StatusConflict, "the offering's grades are locked; an admin must give a reason to change them")
+		case errors.Is(err, data.ErrInvalidReference):
+			v.AddError("scores", "must name existing students and components")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reports, err := app.models.Grades.GetReports(offering.ID, 0)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"grades": reports}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOfferingGradesHandler lists the grades of an offering's students to its
+// teacher and admins.
+func (app *application) listOfferingGradesHandler(w http.ResponseWriter, r *http.Request) {
+	offering := app.readOfferingForGrading(w, r)
+	if offering == nil {
+		return
+	}
+
+	reports, err := app.models.Grades.GetReports(offering.ID, 0)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"grades": reports}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listUserGradesHandler lists a user's grades in the offerings they are enrolled
+// in, newest first.
+func (app *application) listUserGradesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	reports, err := app.models.Grades.GetReports(0, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"grades": reports}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+)
+
+func (app *application) createGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string `json:"name"`
+		AcademicYear string `json:"academicYear"`
+		Description  string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	group := &data.Group{
+		Name:         input.Name,
+		AcademicYear: input.AcademicYear,
+		Description:  input.Description,
+	}
+
+	v := validator.New()
+	if data.ValidateGroup(v, group); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Groups.Insert(group)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGroup):
+			v.AddError("name", "a group with this name already exists for the academic year")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/groups/%d", group.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"group": group}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	group, err := app.models.Groups.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(group.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"group": group}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string
+		AcademicYear string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.AcademicYear = app.readString(qs, "academic_year", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "name")
+	input.Filters.SortSafelist = []string{"id", "name", "academic_year", "-id", "-name", "-academic_year"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	groups, metadata, err := app.models.Groups.GetAll(input.Name, input.AcademicYear, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"groups": groups, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	group, err := app.models.Groups.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name         *string `json:"name"`
+		AcademicYear *string `json:"academicYear"`
+		Description  *string `json:"description"`
+		Version      *int    `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != group.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Name != nil {
+		group.Name = *input.Name
+	}
+	if input.AcademicYear != nil {
+		group.AcademicYear = *input.AcademicYear
+	}
+	if input.Description != nil {
+		group.Description = *input.Description
+	}
+
+	v := validator.New()
+	if data.ValidateGroup(v, group); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Groups.Update(group)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGroup):
+			v.AddError("name", "a group with this name already exists for the academic year")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(group.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"group": group}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Groups.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "group successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// groupBulkHandler builds the handlers that add or remove a list of ids (users or
+// modules) to or from a group. They all share the same request and response shape.
+func (app *application) groupBulkHandler(key string, apply func(groupID int64, ids []int64) (int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		_, err = app.models.Groups.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		var input map[string][]int64
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		v := validator.New()
+		if data.ValidateIDs(v, key, input[key]); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		affected, err := apply(id, input[key])
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrInvalidReference):
+				v.AddError(key, "must only contain existing ids")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"affected": affected}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) listGroupModulesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	modules, err := app.models.Groups.GetModules(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"module_infos": modules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sendGroupAnnouncementHandler sends a department news notification to every member
+// of the group. Delivery happens in the background, so the response only confirms
+// how many were queued.
+func (app *application) sendGroupAnnouncementHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	group, err := app.models.Groups.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Subject string `json:"subject"`
+		Message string `json:"message"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Subject != "", "subject", "must be provided")
+	v.Check(len(input.Subject) <= 200, "subject", "must not be more than 200 bytes long")
+	v.Check(input.Message != "", "message", "must be provided")
+	v.Check(len(input.Message) <= 10000, "message", "must not be more than 10000 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	members, err := app.models.UserInfos.GetGroupMembers(group.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, member := range members {
+		app.notify(member, notification{
+			category: data.CategoryDepartmentNews,
+			subject:  "[" + group.Name + "] " + input.Subject,
+			body:     "Dear " + member.Name + ",\n\n" + input.Message,
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"recipients": len(members)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
 	"github.com/shynggys9219/greenlight/internal/validator"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -29,6 +37,17 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readIDQuery reads the id of the record from the query string, for routes such as
+// PUT /v1/users/edit whose path can't take an ":id" segment without clashing with
+// the static paths next to it.
+func (app *application) readIDQuery(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid id parameter")
+	}
+	return id, nil
+}
+
 // in my version of go there is no type as 'any', and instead of it I used interface{},
 // cuz Marshal actually accepts it as a parameter and map is implementing interface.
 // on your side data interface{} must be data any if you are using go version 1.18 or newer
@@ -119,3 +138,221 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	return i
 }
+
+// readHours reads hours in any form data.ParseHours accepts, such as "45", "45h"
+// or "PT45H", from the query string.
+func (app *application) readHours(qs url.Values, key string, defaultValue data.Hours, v *validator.Validator) data.Hours {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	h, err := data.ParseHours(s)
+	if err != nil {
+		v.AddError(key, "must be a number of hours")
+		return defaultValue
+	}
+
+	return h
+}
+
+// readTime reads an RFC 3339 timestamp, or a date meaning midnight UTC, from the
+// query string.
+func (app *application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", s)
+	}
+	if err != nil {
+		v.AddError(key, "must be a date or an RFC 3339 timestamp")
+		return defaultValue
+	}
+
+	return t
+}
+
+// background runs fn in a new goroutine, recovering and logging any panic so that
+// work such as sending emails can't bring down the server.
+func (app *application) background(fn func()) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// every runs job in the background straight away and then once per interval for
+// the lifetime of the process. Errors and panics are logged and don't stop later
+// runs.
+func (app *application) every(interval time.Duration, name string, job func() error) {
+	run := func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), map[string]string{"job": name})
+			}
+		}()
+
+		err := job()
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": name})
+		}
+	}
+
+	go func() {
+		run()
+		for range time.Tick(interval) {
+			run()
+		}
+	}()
+}
+
+// sign returns a URL-safe HMAC-SHA256 signature of payload, used for values that
+// travel through clients and must come back unmodified.
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// readUserIDParam reads the ":id" parameter of the /v1/users/:id/... routes, where
+// "me" stands for the authenticated user.
+func (app *application) readUserIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	if params.ByName("id") == "me" {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			return 0, errors.New("invalid id parameter")
+		}
+		return int64(user.ID), nil
+	}
+	return app.readIDParam(r)
+}
+
+// readVersionParam reads the ":version" URL parameter, a positive record version.
+func (app *application) readVersionParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	version, err := strconv.Atoi(params.ByName("version"))
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+	return version, nil
+}
+
+// etag formats a record version as the strong ETag the record is served with.
+func etag(version interface{}) string {
+	return fmt.Sprintf(`"%v"`, version)
+}
+
+// readExpectedVersion returns the version of the record the client based its edit
+// on. It is taken from the If-Match header, which holds the ETag the record was
+// served with, or else from the version field of the body. ok is false when the
+// client sent neither, or If-Match: *.
+func (app *application) readExpectedVersion(r *http.Request, bodyVersion *int) (version int, ok bool, err error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+
+	if header == "" || header == "*" {
+		if bodyVersion == nil {
+			return 0, false, nil
+		}
+		return *bodyVersion, true, nil
+	}
+
+	version, err = strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return 0, false, errors.New("If-Match header must contain a single ETag of the record")
+	}
+
+	if bodyVersion != nil && *bodyVersion != version {
+		return 0, false, errors.New("version in the body does not match the If-Match header")
+	}
+	return version, true, nil
+}
+
+// errPreconditionRequired is returned by requireExpectedVersion when the client
+// didn't say which version its update is based on.
+var errPreconditionRequired = errors.New("precondition required")
+
+// requireExpectedVersion is readExpectedVersion for updates that replace or patch a
+// record, which must not silently overwrite changes the client hasn't seen. A
+// request carrying neither If-Match nor a version field is rejected with
+// errPreconditionRequired. If-Match: * is an explicit request to update whatever
+// the current version is, and is let through with ok false.
+func (app *application) requireExpectedVersion(r *http.Request, bodyVersion *int) (version int, ok bool, err error) {
+	version, ok, err = app.readExpectedVersion(r, bodyVersion)
+	if err == nil && !ok && strings.TrimSpace(r.Header.Get("If-Match")) != "*" {
+		return 0, false, errPreconditionRequired
+	}
+	return version, ok, err
+}
+
+// errUnsupportedPatch is returned by readMergePatch for bodies of a media type
+// other than a JSON merge patch.
+var errUnsupportedPatch = errors.New("unsupported patch media type")
+
+// errPatchTooLarge is returned by readMergePatch for bodies over maxPatchBytes.
+var errPatchTooLarge = errors.New("patch too large")
+
+// acceptedPatchTypes lists the media types PATCH requests may be sent as, for the
+// Accept-Patch header.
+const acceptedPatchTypes = "application/merge-patch+json, application/json"
+
+// maxPatchBytes caps the size of a merge patch body.
+const maxPatchBytes = 1_048_576
+
+// readMergePatch reads an RFC 7396 JSON merge patch into dst, whose fields should be
+// pointers so that members missing from the patch stay nil and leave the record
+// alone. The body must be sent as application/merge-patch+json or application/json
+// and be at most maxPatchBytes long. A null member would remove the field, which
+// none of the patchable records allow, so it is rejected rather than silently
+// ignored.
+func (app *application) readMergePatch(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/merge-patch+json" && mediaType != "application/json") {
+		return errUnsupportedPatch
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPatchBytes)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		if strings.Contains(err.Error(), "http: request body too large") {
+			return errPatchTooLarge
+		}
+		return err
+	}
+
+	var members map[string]json.RawMessage
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	err = app.readJSON(w, r, &members)
+	if err != nil {
+		return err
+	}
+	if members == nil {
+		return errors.New("body must contain a JSON object")
+	}
+
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if string(bytes.TrimSpace(members[name])) == "null" {
+			return fmt.Errorf("body sets %q to null, but the field can't be removed", name)
+		}
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return app.readJSON(w, r, dst)
+}
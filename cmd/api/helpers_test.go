@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadMergePatch(t *testing.T) {
+	app := &application{}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     error
+		wantName    string
+	}{
+		{name: "merge patch", contentType: "application/merge-patch+json", body: `{"name": "Alice"}`, wantName: "Alice"},
+		{name: "json", contentType: "application/json; charset=utf-8", body: `{"name": "Alice"}`, wantName: "Alice"},
+		{name: "missing content type", body: `{"name": "Alice"}`, wantErr: errUnsupportedPatch},
+		{name: "json patch", contentType: "application/json-patch+json", body: `[]`, wantErr: errUnsupportedPatch},
+		{name: "too large", contentType: "application/merge-patch+json", body: `{"name": "` + strings.Repeat("a", maxPatchBytes) + `"}`, wantErr: errPatchTooLarge},
+		{name: "null member", contentType: "application/merge-patch+json", body: `{"name": null}`, wantErr: errors.New(`body sets "name" to null, but the field can't be removed`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			var input struct {
+				Name *string `json:"name"`
+			}
+			err := app.readMergePatch(httptest.NewRecorder(), r, &input)
+
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("got error %v", err)
+			case tt.wantErr != nil && (err == nil || (!errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error())):
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			case tt.wantErr == nil && (input.Name == nil || *input.Name != tt.wantName):
+				t.Fatalf("got name %v, want %q", input.Name, tt.wantName)
+			}
+		})
+	}
+}
@@ -94,6 +94,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Retrieve the value of the Authorization header from the request. This will
 		// return the empty string "" if there is no such header found.
 		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
 		// If there is no Authorization header found, use the contextSetUser() helper
 		// that we just made to add the AnonymousUser to the request context. Then we
 		// call the next handler in the chain and return without executing any of the
@@ -133,6 +138,16 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		// Tokens issued before an account was suspended or expired stay in the
+		// database, so the account status has to be checked on every request.
+		switch {
+		case user.IsSuspended():
+			app.accountSuspendedResponse(w, r)
+			return
+		case user.IsExpired():
+			app.accountExpiredResponse(w, r)
+			return
+		}
 		// Call the contextSetUser() helper to add the user information to the request
 		// context.
 		r = app.contextSetUser(r, user)
@@ -141,21 +156,38 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) requireActivatedUser(next http.Handler) http.Handler {
+func (app *application) requireAuthenticatedUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireActivatedUser checks that the user is both authenticated and activated.
+// Self-registered users stay inactive until they redeem their activation token.
+func (app *application) requireActivatedUser(next http.Handler) http.Handler {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
 		if !user.Activated {
-			app.unauthorizedResponse(w, r)
+			app.inactiveAccountResponse(w, r)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
+
+	return app.requireAuthenticatedUser(fn)
 }
 
 func (app *application) requireAdminRole(next func(w http.ResponseWriter, r *http.Request)) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
 		if user.Role != data.Admin {
@@ -163,13 +195,32 @@ func (app *application) requireAdminRole(next func(w http.ResponseWriter, r *htt
 			return
 		}
 
-		err := app.serve()
-		if err != nil {
-			return
-		}
+		next(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// acceptPatch advertises the PATCH formats of a resource on its GET responses, as
+// RFC 5789 suggests.
+func (app *application) acceptPatch(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Patch", acceptedPatchTypes)
+		next.ServeHTTP(w, r)
 	})
 }
 
+// globalOptions answers the OPTIONS requests httprouter handles itself. The router
+// has already set Allow; paths that take PATCH also get Accept-Patch.
+func (app *application) globalOptions(w http.ResponseWriter, r *http.Request) {
+	for _, method := range strings.Split(w.Header().Get("Allow"), ", ") {
+		if method == http.MethodPatch {
+			w.Header().Set("Accept-Patch", acceptedPatchTypes)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (app *application) requireRegisteredUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -182,3 +233,56 @@ func (app *application) requireRegisteredUser(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// registrationRateLimit applies the stricter per-IP limits configured for the
+// public self-registration endpoints, on top of the global rateLimit middleware.
+func (app *application) registrationRateLimit(next http.Handler) http.Handler {
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*client)
+	)
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			mu.Lock()
+
+			for ip, client := range clients {
+				if time.Since(client.lastSeen) > 3*time.Minute {
+					delete(clients, ip)
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		mu.Lock()
+
+		if _, found := clients[ip]; !found {
+			clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.registration.limiter.rps), app.config.registration.limiter.burst)}
+		}
+		clients[ip].lastSeen = time.Now()
+
+		if !clients[ip].limiter.Allow() {
+			mu.Unlock()
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
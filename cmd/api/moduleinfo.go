@@ -4,25 +4,60 @@ import (
 	"errors"
 	"fmt"
 	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
 	"net/http"
+	"strconv"
 	"time"
 )
 
+// contactHours picks the contact hours a client sent under either name, preferring
+// contactHours over the older moduleDuration.
+func contactHours(hours, moduleDuration *data.Hours) data.Hours {
+	switch {
+	case hours != nil:
+		return *hours
+	case moduleDuration != nil:
+		return *moduleDuration
+	default:
+		return 0
+	}
+}
+
+// createModuleInfo creates a draft written by the authenticated user. It stays
+// invisible to registered users until it has been reviewed and published.
 func (app *application) createModuleInfo(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		ModuleName     string        `json:"moduleName"`
-		ModuleDuration time.Duration `json:"moduleDuration"`
-		ExamType       string        `json:"examType"`
+		ModuleName     string      `json:"moduleName"`
+		ContactHours   *data.Hours `json:"contactHours"`
+		SelfStudyHours data.Hours  `json:"selfStudyHours"`
+		ECTSCredits    float64     `json:"ectsCredits"`
+		ExamType       string      `json:"examType"`
+
+		// ModuleDuration is the old name of contactHours, still accepted from
+		// clients that send it.
+		ModuleDuration *data.Hours `json:"moduleDuration"`
 	}
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
+
+	authorID := int64(app.contextGetUser(r).ID)
 	moduleInfo := &data.ModuleInfo{
 		ModuleName:     input.ModuleName,
-		ModuleDuration: input.ModuleDuration,
+		ContactHours:   contactHours(input.ContactHours, input.ModuleDuration),
+		SelfStudyHours: input.SelfStudyHours,
+		ECTSCredits:    input.ECTSCredits,
 		ExamType:       input.ExamType,
+		AuthorID:       &authorID,
+	}
+
+	v := validator.New()
+	if data.ValidateModuleInfo(v, moduleInfo); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
 	err = app.models.ModuleInfos.Insert(moduleInfo)
@@ -42,41 +77,144 @@ func (app *application) createModuleInfo(w http.ResponseWriter, r *http.Request)
 
 }
 
+// getModuleInfo shows the author and reviewers of a module its latest version and
+// everyone else the version in effect. With ?as_of= everyone gets the version in
+// effect at that time, which may be a scheduled one.
 func (app *application) getModuleInfo(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
-	moduleInfos, err := app.models.ModuleInfos.Get(id)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	asOf := app.readTime(qs, "as_of", time.Time{}, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var moduleInfos *data.ModuleInfo
+	if asOf.IsZero() {
+		moduleInfos, err = app.models.ModuleInfos.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		author, reviewer, err := app.moduleAccess(app.contextGetUser(r), moduleInfos)
+		if err != nil {
 			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !author && !reviewer {
+			asOf = time.Now()
 		}
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"module_infos": moduleInfos}, nil)
+	if !asOf.IsZero() {
+		moduleInfos, err = app.models.ModuleInfos.GetEffective(id, asOf)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(moduleInfos.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"module_infos": moduleInfos}, headers)
 	if err != nil {
 		return
 	}
 }
 
-func (app *application) getLastFiftyModuleInfo(w http.ResponseWriter, r *http.Request) {
-	moduleInfos := app.models.ModuleInfos.GetLatestFifty()
+// listModuleInfoHandler lists the published modules. Admins see the latest version
+// of every module instead and can filter them by workflow status.
+func (app *application) listModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string
+		ExamType     string
+		Status       string
+		MinHours     data.Hours
+		MaxHours     data.Hours
+		DepartmentID int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.ExamType = app.readString(qs, "exam_type", "")
+	input.Status = app.readString(qs, "status", "")
+	input.MinHours = app.readHours(qs, "min_contact_hours", 0, v)
+	input.MaxHours = app.readHours(qs, "max_contact_hours", 0, v)
+	input.DepartmentID = app.readInt(qs, "department", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// Newest first, as the list used to be before it was paginated.
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{
+		"id", "module_name", "contact_hours", "ects_credits", "exam_type", "created_at", "updated_at",
+		"-id", "-module_name", "-contact_hours", "-ects_credits", "-exam_type", "-created_at", "-updated_at",
+	}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
 
-	err := app.writeJSON(w, http.StatusOK, envelope{"module_infos": moduleInfos}, nil)
+	v.Check(input.MinHours >= 0, "min_contact_hours", "must not be negative")
+	v.Check(input.MaxHours >= 0, "max_contact_hours", "must not be negative")
+	if input.MinHours > 0 && input.MaxHours > 0 {
+		v.Check(input.MinHours <= input.MaxHours, "max_contact_hours", "must not be less than min_contact_hours")
+	}
+	v.Check(input.DepartmentID >= 0, "department", "must be a positive department id")
+
+	admin := app.contextGetUser(r).Role == data.Admin
+	if input.Status != "" {
+		v.Check(validator.PermittedValue(input.Status, data.ModuleStatuses...), "status", "must be a module status")
+		v.Check(admin || input.Status == data.ModulePublished, "status", "only admins can list modules that aren't published")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	moduleInfos, metadata, err := app.models.ModuleInfos.GetAll(input.Name, input.ExamType, input.Status, input.MinHours, input.MaxHours, int64(input.DepartmentID), !admin, input.Filters)
 	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"module_infos": moduleInfos, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
+// editModuleInfo replaces the content of a module with a new draft version. The
+// edit is rejected with 409 if the module changed since the client read it, going
+// by If-Match or the version field, and if it changes between being read here and
+// written. Without either it is rejected with 428.
 func (app *application) editModuleInfo(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
 	moduleInfo, err := app.models.ModuleInfos.Get(id)
@@ -90,29 +228,69 @@ func (app *application) editModuleInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !app.checkModuleEditable(w, r, moduleInfo) {
+		return
+	}
+
 	var input struct {
-		ModuleName     string        `json:"moduleName"`
-		ModuleDuration time.Duration `json:"moduleDuration"`
-		ExamType       string        `json:"examType"`
+		ModuleName     string      `json:"moduleName"`
+		ContactHours   *data.Hours `json:"contactHours"`
+		SelfStudyHours data.Hours  `json:"selfStudyHours"`
+		ECTSCredits    float64     `json:"ectsCredits"`
+		ExamType       string      `json:"examType"`
+		Version        *int        `json:"version"`
+
+		ModuleDuration *data.Hours `json:"moduleDuration"`
 	}
 
 	err = app.readJSON(w, r, &input)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && strconv.Itoa(expected) != moduleInfo.Version {
+		app.editConflictResponse(w, r)
 		return
 	}
 
 	moduleInfo.ModuleName = input.ModuleName
-	moduleInfo.ModuleDuration = input.ModuleDuration
+	moduleInfo.ContactHours = contactHours(input.ContactHours, input.ModuleDuration)
+	moduleInfo.SelfStudyHours = input.SelfStudyHours
+	moduleInfo.ECTSCredits = input.ECTSCredits
 	moduleInfo.ExamType = input.ExamType
 
+	v := validator.New()
+	if data.ValidateModuleInfo(v, moduleInfo); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	err = app.models.ModuleInfos.Update(moduleInfo)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"module_info": moduleInfo}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", etag(moduleInfo.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"module_info": moduleInfo}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -139,3 +317,109 @@ func (app *application) deleteModuleInfo(w http.ResponseWriter, r *http.Request)
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// patchModuleInfoHandler applies a JSON merge patch to a module: only the members
+// present in the body are changed, and the result is validated like a full update.
+// Like editModuleInfo it creates a new draft version.
+func (app *application) patchModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	moduleInfo, err := app.models.ModuleInfos.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkModuleEditable(w, r, moduleInfo) {
+		return
+	}
+
+	var input struct {
+		ModuleName     *string     `json:"moduleName"`
+		ContactHours   *data.Hours `json:"contactHours"`
+		SelfStudyHours *data.Hours `json:"selfStudyHours"`
+		ECTSCredits    *float64    `json:"ectsCredits"`
+		ExamType       *string     `json:"examType"`
+		Version        *int        `json:"version"`
+
+		ModuleDuration *data.Hours `json:"moduleDuration"`
+	}
+
+	err = app.readMergePatch(w, r, &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedPatch):
+			app.unsupportedPatchResponse(w, r)
+		case errors.Is(err, errPatchTooLarge):
+			app.patchTooLargeResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && strconv.Itoa(expected) != moduleInfo.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.ModuleName != nil {
+		moduleInfo.ModuleName = *input.ModuleName
+	}
+	if input.ContactHours != nil || input.ModuleDuration != nil {
+		moduleInfo.ContactHours = contactHours(input.ContactHours, input.ModuleDuration)
+	}
+	if input.SelfStudyHours != nil {
+		moduleInfo.SelfStudyHours = *input.SelfStudyHours
+	}
+	if input.ECTSCredits != nil {
+		moduleInfo.ECTSCredits = *input.ECTSCredits
+	}
+	if input.ExamType != nil {
+		moduleInfo.ExamType = *input.ExamType
+	}
+
+	v := validator.New()
+	if data.ValidateModuleInfo(v, moduleInfo); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ModuleInfos.Update(moduleInfo)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(moduleInfo.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"module_info": moduleInfo}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
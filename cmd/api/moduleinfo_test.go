@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// TestModuleInfoValidation checks that creating, replacing and patching a module
+// all refuse an empty or over-long name or exam type.
+func TestModuleInfoValidation(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+	ts := newTestServer(t, app.routes())
+
+	admin, adminToken := insertTestUser(t, app, "admin@example.com", data.Admin, "admin-pa55word")
+
+	authorID := int64(admin.ID)
+	module := &data.ModuleInfo{ModuleName: "Databases", ContactHours: 30, ExamType: "written", AuthorID: &authorID}
+	err := app.models.ModuleInfos.Insert(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := fmt.Sprintf("/v1/module-infos/%d", module.ID)
+	anyVersion := map[string]string{"If-Match": "*"}
+	mergePatch := map[string]string{"If-Match": "*", "Content-Type": "application/merge-patch+json"}
+
+	for _, field := range []string{"moduleName", "examType"} {
+		for _, value := range []string{"", strings.Repeat("x", 256)} {
+			full := map[string]interface{}{"moduleName": "Databases", "contactHours": 30, "examType": "written"}
+			full[field] = value
+
+			tests := []struct {
+				method  string
+				path    string
+				body    interface{}
+				headers map[string]string
+			}{
+				{http.MethodPost, "/v1/module-infos", full, nil},
+				{http.MethodPut, path, full, anyVersion},
+				{http.MethodPatch, path, map[string]interface{}{field: value}, mergePatch},
+			}
+
+			for _, tt := range tests {
+				res, body := ts.do(t, tt.method, tt.path, adminToken, tt.body, tt.headers)
+				if res.StatusCode != http.StatusUnprocessableEntity {
+					t.Errorf("%s %s with %d bytes of %s: got status %d, want %d: %s",
+						tt.method, tt.path, len(value), field, res.StatusCode, http.StatusUnprocessableEntity, body)
+				}
+			}
+		}
+	}
+}
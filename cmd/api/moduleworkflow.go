@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// moduleAccess reports whether the user wrote the module and whether they may
+// review it, being an admin or the director of one of the module's departments.
+func (app *application) moduleAccess(user *data.UserInfo, module *data.ModuleInfo) (author, reviewer bool, err error) {
+	if user.IsAnonymous() {
+		return false, false, nil
+	}
+
+	author = module.AuthorID != nil && *module.AuthorID == int64(user.ID)
+	if user.Role == data.Admin {
+		return author, true, nil
+	}
+
+	directors, err := app.models.ModuleInfos.GetDirectors(int64(module.ID))
+	if err != nil {
+		return false, false, err
+	}
+	return author, data.IsDirector(user, directors), nil
+}
+
+// checkModuleEditable writes an error response and returns false unless the user
+// may change the content of the module: its author or an admin may, as long as the
+// module isn't archived. Changing a scheduled module cancels the scheduled version.
+func (app *application) checkModuleEditable(w http.ResponseWriter, r *http.Request, module *data.ModuleInfo) bool {
+	user := app.contextGetUser(r)
+
+	author := module.AuthorID != nil && *module.AuthorID == int64(user.ID)
+	if !author && user.Role != data.Admin {
+		app.forbiddenResponse(w, r)
+		return false
+	}
+
+	if module.Status == data.ModuleArchived {
+		app.errorResponse(w, r, http.StatusConflict, "archived modules can't be changed")
+		return false
+	}
+	return true
+}
+
+// moduleTransitionHandler builds the handler for one workflow action. Authors
+// submit their drafts, reviewers (admins and the directors of the module's
+// departments) approve, reject and publish them, and admins archive modules.
+// Reviewers can't approve their own modules unless they are admins. The body may
+// carry a comment, which is required for rejections, and when publishing an
+// effectiveFrom date in the future to schedule the version. With If-Match the
+// action only applies if the module is still at the given version.
+func (app *application) moduleTransitionHandler(action string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		module, err := app.models.ModuleInfos.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		user := app.contextGetUser(r)
+
+		author, reviewer, err := app.moduleAccess(user, module)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		var permitted bool
+		switch action {
+		case data.ModuleSubmit:
+			permitted = author || user.Role == data.Admin
+		case data.ModuleApprove:
+			permitted = reviewer && (!author || user.Role == data.Admin)
+		case data.ModuleReject, data.ModulePublish:
+			permitted = reviewer
+		case data.ModuleArchive:
+			permitted = user.Role == data.Admin
+		}
+		if !permitted {
+			app.forbiddenResponse(w, r)
+			return
+		}
+
+		var input struct {
+			Comment       string     `json:"comment"`
+			EffectiveFrom *time.Time `json:"effectiveFrom"`
+		}
+
+		// The body is optional for everything but rejections.
+		if r.ContentLength != 0 {
+			err = app.readJSON(w, r, &input)
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
+		}
+
+		v := validator.New()
+		v.Check(action != data.ModuleReject || input.Comment != "", "comment", "must explain why the module was rejected")
+		v.Check(len(input.Comment) <= 2000, "comment", "must not be more than 2000 bytes long")
+		if input.EffectiveFrom != nil {
+			v.Check(action == data.ModulePublish, "effectiveFrom", "can only be set when publishing")
+			v.Check(input.EffectiveFrom.After(time.Now()), "effectiveFrom", "must be in the future")
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		expected, _, err := app.readExpectedVersion(r, nil)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		module, err = app.models.ModuleInfos.Transition(id, int64(user.ID), action, input.Comment, expected, input.EffectiveFrom)
+		if err != nil {
+			var transitionErr *data.TransitionError
+			switch {
+			case errors.As(err, &transitionErr):
+				app.errorResponse(w, r, http.StatusConflict, transitionErr.Error())
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		app.notifyModuleTransition(module, user, action, input.Comment)
+
+		headers := make(http.Header)
+		headers.Set("ETag", etag(module.Version))
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"module_info": module}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	})
+}
+
+// notifyModuleTransition tells the reviewers about submitted modules and the author
+// about everything reviewers do with them. Publishing also notifies the groups the
+// module is assigned to once the change is live; for scheduled versions that is
+// left to publishScheduledModules.
+func (app *application) notifyModuleTransition(module *data.ModuleInfo, actor *data.UserInfo, action, comment string) {
+	if action == data.ModulePublish && module.Status == data.ModulePublished {
+		app.notifyModuleChange(module)
+	}
+
+	app.background(func() {
+		properties := map[string]string{"module_id": strconv.Itoa(module.ID), "action": action}
+
+		if action == data.ModuleSubmit {
+			directors, err := app.models.ModuleInfos.GetDirectors(int64(module.ID))
+			if err != nil {
+				app.logger.PrintError(err, properties)
+				return
+			}
+
+			reviewers, err := app.models.UserInfos.GetModuleReviewers(directors)
+			if err != nil {
+				app.logger.PrintError(err, properties)
+				return
+			}
+
+			for _, reviewer := range reviewers {
+				if reviewer.ID == actor.ID {
+					continue
+				}
+				app.notify(reviewer, notification{
+					category: data.CategoryModuleReviews,
+					subject:  "Module submitted for review: " + module.ModuleName,
+					body:     fmt.Sprintf("Dear %s,\n\nVersion %s of the module %q has been submitted for review.", reviewer.Name, module.Version, module.ModuleName),
+				})
+			}
+			return
+		}
+
+		if module.AuthorID == nil || *module.AuthorID == int64(actor.ID) {
+			return
+		}
+
+		author, err := app.models.UserInfos.GetByID(*module.AuthorID)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.PrintError(err, properties)
+			}
+			return
+		}
+
+		verbs := map[string]string{
+			data.ModuleApprove: "approved",
+			data.ModuleReject:  "rejected",
+			data.ModulePublish: "published",
+			data.ModuleArchive: "archived",
+		}
+
+		verb := verbs[action]
+		if module.Status == data.ModuleScheduled {
+			verb = "scheduled for publication"
+		}
+
+		body := fmt.Sprintf("Dear %s,\n\nVersion %s of your module %q has been %s.", author.Name, module.Version, module.ModuleName, verb)
+		if comment != "" {
+			body += "\n\nComment from the reviewer:\n\n" + comment
+		}
+
+		app.notify(author, notification{
+			category: data.CategoryModuleReviews,
+			subject:  "Module " + verb + ": " + module.ModuleName,
+			body:     body,
+		})
+	})
+}
+
+// listModuleReviewsHandler returns the workflow history of a module to its author
+// and reviewers.
+func (app *application) listModuleReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	module, ok := app.readModuleForStaff(w, r)
+	if !ok {
+		return
+	}
+
+	reviews, err := app.models.ModuleInfos.GetReviews(int64(module.ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readModuleForStaff loads the module named by the :id parameter for its author or
+// reviewers, who may see unpublished versions. Anyone else gets a 404, and false is
+// returned whenever a response has been written.
+func (app *application) readModuleForStaff(w http.ResponseWriter, r *http.Request) (*data.ModuleInfo, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	module, err := app.models.ModuleInfos.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	author, reviewer, err := app.moduleAccess(app.contextGetUser(r), module)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !author && !reviewer {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	return module, true
+}
+
+// publishScheduledModules promotes the scheduled versions that have taken effect
+// and tells the groups the modules are assigned to.
+func (app *application) publishScheduledModules() error {
+	modules, err := app.models.ModuleInfos.PromoteScheduled()
+	if err != nil {
+		return err
+	}
+
+	for _, module := range modules {
+		app.notifyModuleChange(module)
+	}
+
+	if len(modules) > 0 {
+		app.logger.PrintInfo("published scheduled modules", map[string]string{"count": strconv.Itoa(len(modules))})
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// transitionModule runs a workflow action on the module and returns it as the
+// response shows it.
+func transitionModule(t *testing.T, ts *testServer, token string, id int, action string, body interface{}) *data.ModuleInfo {
+	t.Helper()
+
+	res, rb := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/module-infos/%d/%s", id, action), token, body, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("%s: got status %d: %s", action, res.StatusCode, rb)
+	}
+
+	var got struct {
+		ModuleInfo *data.ModuleInfo `json:"module_info"`
+	}
+	decode(t, rb, &got)
+	return got.ModuleInfo
+}
+
+// TestModuleWorkflowPublish takes a module from draft to published and checks
+// that the published version is recorded.
+func TestModuleWorkflowPublish(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+	ts := newTestServer(t, app.routes())
+
+	admin, adminToken := insertTestUser(t, app, "admin@example.com", data.Admin, "admin-pa55word")
+
+	authorID := int64(admin.ID)
+	module := &data.ModuleInfo{ModuleName: "Databases", ContactHours: 30, ExamType: "written", AuthorID: &authorID}
+	err := app.models.ModuleInfos.Insert(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		action string
+		status string
+	}{
+		{data.ModuleSubmit, data.ModuleInReview},
+		{data.ModuleApprove, data.ModuleApproved},
+		{data.ModulePublish, data.ModulePublished},
+	}
+
+	var got *data.ModuleInfo
+	for _, tt := range tests {
+		got = transitionModule(t, ts, adminToken, module.ID, tt.action, nil)
+		if got.Status != tt.status {
+			t.Fatalf("%s: got status %q, want %q", tt.action, got.Status, tt.status)
+		}
+	}
+
+	if got.PublishedVersion == nil || fmt.Sprint(*got.PublishedVersion) != got.Version {
+		t.Errorf("publish: got published version %v, want %s", got.PublishedVersion, got.Version)
+	}
+
+	var published int
+	err = db.QueryRow("SELECT published_version FROM module_info WHERE id = $1", module.ID).Scan(&published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(published) != got.Version {
+		t.Errorf("stored published version is %d, want %s", published, got.Version)
+	}
+}
+
+// TestModuleScheduleSuperseded schedules a version, publishes a newer one straight
+// away and checks that the scheduled one doesn't take over once its date passes.
+func TestModuleScheduleSuperseded(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+	ts := newTestServer(t, app.routes())
+
+	admin, adminToken := insertTestUser(t, app, "admin@example.com", data.Admin, "admin-pa55word")
+
+	authorID := int64(admin.ID)
+	module := &data.ModuleInfo{ModuleName: "Databases", ContactHours: 30, ExamType: "written", AuthorID: &authorID}
+	err := app.models.ModuleInfos.Insert(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edit := func(name string) {
+		t.Helper()
+		res, rb := ts.do(t, http.MethodPut, fmt.Sprintf("/v1/module-infos/%d", module.ID), adminToken,
+			map[string]interface{}{"moduleName": name, "contactHours": 30, "examType": "written"},
+			map[string]string{"If-Match": "*"})
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("edit: got status %d: %s", res.StatusCode, rb)
+		}
+	}
+	publish := func(body interface{}) *data.ModuleInfo {
+		t.Helper()
+		transitionModule(t, ts, adminToken, module.ID, data.ModuleSubmit, nil)
+		transitionModule(t, ts, adminToken, module.ID, data.ModuleApprove, nil)
+		return transitionModule(t, ts, adminToken, module.ID, data.ModulePublish, body)
+	}
+
+	publish(nil)
+
+	// Version 2 is scheduled for later.
+	edit("Databases II")
+	scheduled := publish(map[string]interface{}{"effectiveFrom": time.Now().Add(time.Hour)})
+	if scheduled.Status != data.ModuleScheduled {
+		t.Fatalf("got status %q, want %q", scheduled.Status, data.ModuleScheduled)
+	}
+
+	// Version 3 replaces it and is published now.
+	edit("Databases III")
+	latest := publish(nil)
+
+	// Two hours on, version 2's date would have passed.
+	_, err = db.Exec(`UPDATE module_info_revisions SET effective_from = effective_from - interval '2 hours' WHERE module_id = $1`, module.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = app.models.ModuleInfos.PromoteScheduled()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := app.models.ModuleInfos.Get(int64(module.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PublishedVersion == nil || fmt.Sprint(*got.PublishedVersion) != latest.Version {
+		t.Errorf("got published version %v, want %s", got.PublishedVersion, latest.Version)
+	}
+
+	effective, err := app.models.ModuleInfos.GetEffective(int64(module.ID), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if effective.ModuleName != "Databases III" {
+		t.Errorf("got %q in effect, want %q", effective.ModuleName, "Databases III")
+	}
+}
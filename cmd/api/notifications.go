@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// notification is a message about an event, routed to the user through the channel
+// they chose for its category. The HTML version of the email is derived from the
+// plain text body.
+type notification struct {
+	category string
+	subject  string
+	body     string
+}
+
+// notify delivers n to user in the background according to their preferences.
+// Mandatory categories are always emailed.
+func (app *application) notify(user *data.UserInfo, n notification) {
+	app.background(func() {
+		properties := map[string]string{"user_id": strconv.Itoa(user.ID), "category": n.category}
+
+		channel, err := app.models.Notifications.GetChannel(int64(user.ID), n.category)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+			return
+		}
+
+		switch channel {
+		case data.ChannelEmail:
+			err = app.sendNotificationEmail(user, n)
+		case data.ChannelInApp:
+			err = app.models.Notifications.Insert(&data.Notification{
+				UserID:   int64(user.ID),
+				Category: n.category,
+				Subject:  n.subject,
+				Body:     n.body,
+			})
+		}
+		if err != nil {
+			app.logger.PrintError(err, properties)
+		}
+	})
+}
+
+// notifyModuleChange tells the members of every group the module is assigned to
+// that a new version of it was published.
+func (app *application) notifyModuleChange(module *data.ModuleInfo) {
+	app.background(func() {
+		audience, err := app.models.UserInfos.GetModuleAudience(int64(module.ID))
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"module_id": strconv.Itoa(module.ID)})
+			return
+		}
+
+		for _, user := range audience {
+			app.notify(user, notification{
+				category: data.CategoryModuleChanges,
+				subject:  "Module updated: " + module.ModuleName,
+				body:     fmt.Sprintf("Dear %s,\n\nThe module %q has been updated. It now has %s of contact hours, is worth %s ECTS credits and ends with a %s exam.", user.Name, module.ModuleName, module.ContactHours, strconv.FormatFloat(module.ECTSCredits, 'f', -1, 64), module.ExamType),
+			})
+		}
+	})
+}
+
+func (app *application) sendNotificationEmail(user *data.UserInfo, n notification) error {
+	plainBody := n.body
+	htmlBody := "<p>" + strings.ReplaceAll(html.EscapeString(n.body), "\n\n", "</p><p>") + "</p>"
+	var headers map[string]string
+
+	category, _ := data.LookupCategory(n.category)
+	if !category.Mandatory {
+		link := app.unsubscribeURL(int64(user.ID), n.category)
+		plainBody += "\n\n--\nTo stop receiving these emails, visit " + link
+		htmlBody += `<hr><p><a href="` + html.EscapeString(link) + `">Unsubscribe</a> from these emails.</p>`
+		headers = map[string]string{
+			"List-Unsubscribe":      "<" + link + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+
+	return app.mailer.Send(user.Email, n.subject, plainBody, htmlBody, headers)
+}
+
+// An unsubscribe token is "<base64 user id:category>.<signature>", so the link works
+// without logging in but can't be forged for another user.
+func (app *application) unsubscribeURL(userID int64, category string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", userID, category)))
+	token := payload + "." + sign(app.config.notifications.secret, payload)
+	return app.config.baseURL + "/v1/notifications/unsubscribe?token=" + token
+}
+
+func (app *application) parseUnsubscribeToken(token string) (int64, string, bool) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(sign(app.config.notifications.secret, payload))) {
+		return 0, "", false
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return 0, "", false
+	}
+
+	id, category, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return 0, "", false
+	}
+
+	userID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return userID, category, true
+}
+
+// unsubscribeHandler switches a category from email to the in-app inbox. It answers
+// both the link in the email footer (GET) and one-click unsubscribe (POST).
+func (app *application) unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, category, ok := app.parseUnsubscribeToken(r.URL.Query().Get("token"))
+	if !ok {
+		v := validator.New()
+		v.AddError("token", "invalid unsubscribe token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pref := &data.NotificationPreference{Category: category, Channel: data.ChannelInApp}
+
+	v := validator.New()
+	if data.ValidateNotificationPreference(v, pref); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Notifications.SetPreference(userID, pref)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	message := fmt.Sprintf("you will no longer receive %s emails, they will appear in your in-app notifications instead", strings.ReplaceAll(category, "_", " "))
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Unread bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Unread = app.readString(qs, "unread", "false") == "true"
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"created_at", "-created_at"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	notifications, metadata, err := app.models.Notifications.GetAllForUser(id, input.Unread, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"notifications": notifications, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// markNotificationsReadHandler marks the listed notifications, or with "all": true
+// every unread notification, as read.
+func (app *application) markNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	var input struct {
+		IDs []int64 `json:"ids"`
+		All bool    `json:"all"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if !input.All {
+		data.ValidateIDs(v, "ids", input.IDs)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.All {
+		marked, err := app.models.Notifications.MarkAllRead(id)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"marked": marked}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, notificationID := range input.IDs {
+		err = app.models.Notifications.MarkRead(id, notificationID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"marked": len(input.IDs)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	prefs, err := app.models.Notifications.GetPreferences(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"preferences": prefs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateNotificationPreferencesHandler accepts a map of category to channel, e.g.
+// {"module_changes": "in_app"}. Categories that are left out keep their channel.
+func (app *application) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	var input map[string]string
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	var prefs []*data.NotificationPreference
+	for category, channel := range input {
+		pref := &data.NotificationPreference{Category: category, Channel: channel}
+
+		pv := validator.New()
+		data.ValidateNotificationPreference(pv, pref)
+		for _, message := range pv.Errors {
+			v.AddError(category, message)
+		}
+		prefs = append(prefs, pref)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	for _, pref := range prefs {
+		err = app.models.Notifications.SetPreference(id, pref)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	all, err := app.models.Notifications.GetPreferences(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"preferences": all}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// checkOfferingReferences adds validation errors unless the offering refers to an
+// existing module that isn't archived, an existing term whose dates contain the
+// offering's, an existing teacher and an existing grade scale. Dates left zero
+// default to the term's.
+func (app *application) checkOfferingReferences(v *validator.Validator, offering *data.Offering) error {
+	module, err := app.models.ModuleInfos.Get(offering.ModuleID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("moduleId", "must be an existing module id")
+	case err != nil:
+		return err
+	default:
+		v.Check(module.Status != data.ModuleArchived, "moduleId", "must not be an archived module")
+	}
+
+	term, err := app.models.Terms.Get(offering.TermID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("termId", "must be an existing term id")
+	case err != nil:
+		return err
+	default:
+		if offering.StartsOn.IsZero() {
+			offering.StartsOn = term.StartsOn
+		}
+		if offering.EndsOn.IsZero() {
+			offering.EndsOn = term.EndsOn
+		}
+		v.Check(!offering.StartsOn.Before(term.StartsOn.Time), "startsOn", fmt.Sprintf("must not be before the term starts on %s", term.StartsOn))
+		v.Check(!offering.EndsOn.After(term.EndsOn.Time), "endsOn", fmt.Sprintf("must not be after the term ends on %s", term.EndsOn))
+	}
+
+	if offering.TeacherID != nil {
+		_, err = app.models.UserInfos.GetByID(*offering.TeacherID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("teacherId", "must be an existing user id")
+		case err != nil:
+			return err
+		}
+	}
+
+	if offering.GradeScaleID != nil {
+		_, err = app.models.GradeScales.Get(*offering.GradeScaleID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("gradeScaleId", "must be an existing grade scale id")
+		case err != nil:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeOfferingError writes the response for an error returned when saving an
+// offering. Its references were checked before, so they only fail if the module,
+// term or teacher was deleted or the term's dates changed in the meantime. New
+// dates may also make the offering's sessions clash with others.
+func (app *application) writeOfferingError(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	var conflictErr *data.SessionConflictError
+
+	switch {
+	case errors.Is(err, data.ErrDuplicateOffering):
+		v.AddError("moduleId", "the module is already offered in this term")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrInvalidReference):
+		v.AddError("offering", "refers to a module, term, teacher or grade scale that no longer exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrOfferingOutsideTerm):
+		v.AddError("startsOn", "must fall within the dates of the term")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.As(err, &conflictErr):
+		app.sessionConflictResponse(w, r, conflictErr.Conflicts)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createOfferingHandler offers a module in a term. The offering runs for the whole
+// term unless startsOn or endsOn narrow it down.
+func (app *application) createOfferingHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ModuleID  int64     `json:"moduleId"`
+		TermID    int64     `json:"termId"`
+		TeacherID *int64    `json:"teacherId"`
+		Capacity  int       `json:"capacity"`
+		StartsOn  data.Date `json:"startsOn"`
+		EndsOn    data.Date `json:"endsOn"`
+		Schedule  string    `json:"schedule"`
+
+		EnrollmentDeadline *time.Time `json:"enrollmentDeadline"`
+		GradeScaleID       *int64     `json:"gradeScaleId"`
+		GradesLockAt       *time.Time `json:"gradesLockAt"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	offering := &data.Offering{
+		ModuleID:  input.ModuleID,
+		TermID:    input.TermID,
+		TeacherID: input.TeacherID,
+		Capacity:  input.Capacity,
+		StartsOn:  input.StartsOn,
+		EndsOn:    input.EndsOn,
+		Schedule:  input.Schedule,
+
+		EnrollmentDeadline: input.EnrollmentDeadline,
+		GradeScaleID:       input.GradeScaleID,
+		GradesLockAt:       input.GradesLockAt,
+	}
+
+	v := validator.New()
+	if data.ValidateOffering(v, offering); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.checkOfferingReferences(v, offering)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateOffering(v, offering); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Offerings.Insert(offering)
+	if err != nil {
+		app.writeOfferingError(w, r, v, err)
+		return
+	}
+
+	// Insert doesn't read the module name back.
+	offering, err = app.models.Offerings.Get(offering.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/module-offerings/%d", offering.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"offering": offering}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getOfferingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	offering, err := app.models.Offerings.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(offering.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"offering": offering}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOfferingsHandler lists offerings, filtered by ?term=, ?module= and ?teacher=.
+// Under /v1/terms/:id/offerings the term comes from the path. Only admins see the
+// offerings of modules that aren't published.
+func (app *application) listOfferingsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TermID    int
+		ModuleID  int
+		TeacherID int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.TermID = app.readInt(qs, "term", 0, v)
+	input.ModuleID = app.readInt(qs, "module", 0, v)
+	input.TeacherID = app.readInt(qs, "teacher", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "module_name")
+	input.Filters.SortSafelist = []string{
+		"id", "module_name", "capacity", "starts_on",
+		"-id", "-module_name", "-capacity", "-starts_on",
+	}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+
+	v.Check(input.TermID >= 0, "term", "must be a positive term id")
+	v.Check(input.ModuleID >= 0, "module", "must be a positive module id")
+	v.Check(input.TeacherID >= 0, "teacher", "must be a positive user id")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	termID := int64(input.TermID)
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") != "" {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		_, err = app.models.Terms.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		termID = id
+	}
+
+	admin := app.contextGetUser(r).Role == data.Admin
+
+	offerings, metadata, err := app.models.Offerings.GetAll(termID, int64(input.ModuleID), int64(input.TeacherID), !admin, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"offerings": offerings, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateOfferingHandler changes the fields present in the body. A teacherId of 0
+// leaves the offering without a teacher, and a gradeScaleId of 0 puts it back on
+// the default grade scale.
+func (app *application) updateOfferingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	offering, err := app.models.Offerings.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		ModuleID  *int64     `json:"moduleId"`
+		TermID    *int64     `json:"termId"`
+		TeacherID *int64     `json:"teacherId"`
+		Capacity  *int       `json:"capacity"`
+		StartsOn  *data.Date `json:"startsOn"`
+		EndsOn    *data.Date `json:"endsOn"`
+		Schedule  *string    `json:"schedule"`
+		Version   *int       `json:"version"`
+
+		EnrollmentDeadline *time.Time `json:"enrollmentDeadline"`
+		GradeScaleID       *int64     `json:"gradeScaleId"`
+		GradesLockAt       *time.Time `json:"gradesLockAt"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != offering.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	capacity := offering.Capacity
+
+	if input.ModuleID != nil {
+		offering.ModuleID = *input.ModuleID
+	}
+	if input.TermID != nil && *input.TermID != offering.TermID {
+		offering.TermID = *input.TermID
+		// Moving to another term, the dates default to the new term's.
+		offering.StartsOn, offering.EndsOn = data.Date{}, data.Date{}
+	}
+	if input.TeacherID != nil {
+		offering.TeacherID = input.TeacherID
+		if *input.TeacherID == 0 {
+			offering.TeacherID = nil
+		}
+	}
+	if input.Capacity != nil {
+		offering.Capacity = *input.Capacity
+	}
+	if input.StartsOn != nil {
+		offering.StartsOn = *input.StartsOn
+	}
+	if input.EndsOn != nil {
+		offering.EndsOn = *input.EndsOn
+	}
+	if input.Schedule != nil {
+		offering.Schedule = *input.Schedule
+	}
+	if input.EnrollmentDeadline != nil {
+		offering.EnrollmentDeadline = input.EnrollmentDeadline
+	}
+	if input.GradeScaleID != nil {
+		offering.GradeScaleID = input.GradeScaleID
+		if *input.GradeScaleID == 0 {
+			offering.GradeScaleID = nil
+		}
+	}
+	if input.GradesLockAt != nil {
+		offering.GradesLockAt = input.GradesLockAt
+	}
+
+	v := validator.New()
+	if data.ValidateOffering(v, offering); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.checkOfferingReferences(v, offering)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if data.ValidateOffering(v, offering); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Offerings.Update(offering)
+	if err != nil {
+		app.writeOfferingError(w, r, v, err)
+		return
+	}
+
+	// Seats added to the offering go to its waitlist.
+	if offering.Capacity > capacity {
+		promoted, err := app.models.Enrollments.Promote(offering.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.notifyPromotions(promoted)
+	}
+
+	offering, err = app.models.Offerings.Get(offering.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(offering.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"offering": offering}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteOfferingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Offerings.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "offering successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
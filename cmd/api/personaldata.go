@@ -0,0 +1,173 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/storage"
+	"io"
+	"net/http"
+	"time"
+)
+
+// canAccessUser reports whether the authenticated user may act on the user with the
+// given id: admins may act on anyone, everybody else only on themselves.
+func (app *application) canAccessUser(r *http.Request, id int64) bool {
+	user := app.contextGetUser(r)
+	return user.Role == data.Admin || int64(user.ID) == id
+}
+
+func (app *application) exportPersonalDataHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	pd, err := app.models.PersonalData.Export(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Build the archive in memory first so that a failure can still be reported
+	// with a proper error response.
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"profile.json", pd.User},
+		{"tokens.json", pd.Tokens},
+		{"departments.json", pd.Departments},
+		{"notifications.json", pd.Notifications},
+		{"notification_preferences.json", pd.Preferences},
+		{"account_events.json", pd.AccountEvents},
+		{"groups.json", pd.Groups},
+		{"enrollments.json", pd.Enrollments},
+		{"scores.json", pd.Scores},
+		{"grade_overrides.json", pd.Overrides},
+		{"transcript.json", pd.Transcript},
+		{"transcript_verifications.json", pd.Verifications},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		js, err := json.MarshalIndent(file.content, "", "\t")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		_, err = f.Write(js)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// The avatar is personal data too; export the largest thumbnail if there is one.
+	record, err := app.models.Avatars.Get(id)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if record != nil {
+		err = app.addAvatarToZip(zw, id, record)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="personal-data-%d.zip"`, id))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func (app *application) erasePersonalDataHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	err = app.models.PersonalData.Erase(id, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.storage.DeletePrefix(fmt.Sprintf("avatars/%d", id))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "personal data successfully erased"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addAvatarToZip(zw *zip.Writer, userID int64, record *data.Avatar) error {
+	f, err := app.storage.Get(avatarKey(userID, "large"))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+	defer f.Close()
+
+	name := "avatar.png"
+	if record.ContentType == "image/jpeg" {
+		name = "avatar.jpg"
+	}
+
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: record.UpdatedAt})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, f)
+	return err
+}
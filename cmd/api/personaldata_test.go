@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// readExport fetches the personal data export of the user and returns its files.
+func readExport(t *testing.T, ts *testServer, id int, token string) map[string][]byte {
+	t.Helper()
+
+	res, body := ts.do(t, http.MethodGet, fmt.Sprintf("/v1/users/%d/personal-data", id), token, nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("export: got status %d: %s", res.StatusCode, body)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = content
+	}
+	return files
+}
+
+// TestCalendarFeedExportAndErase checks that a calendar feed shows up in the
+// export and stops working once the user is erased.
+func TestCalendarFeedExportAndErase(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+	ts := newTestServer(t, app.routes())
+
+	user, token := insertTestUser(t, app, "user@example.com", data.Registered, "user-pa55word")
+
+	res, body := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/users/%d/calendar-feed", user.ID), token, nil, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("create feed: got status %d: %s", res.StatusCode, body)
+	}
+	var created struct {
+		CalendarFeed struct {
+			URL string `json:"url"`
+		} `json:"calendar_feed"`
+	}
+	decode(t, body, &created)
+	feedPath := strings.TrimPrefix(created.CalendarFeed.URL, app.config.baseURL)
+
+	res, body = ts.do(t, http.MethodGet, feedPath, "", nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("feed: got status %d: %s", res.StatusCode, body)
+	}
+
+	var tokens []*data.TokenMetadata
+	decode(t, readExport(t, ts, user.ID, token)["tokens.json"], &tokens)
+	found := false
+	for _, token := range tokens {
+		found = found || token.Scope == data.ScopeCalendar
+	}
+	if !found {
+		t.Errorf("export: tokens.json has no %s token", data.ScopeCalendar)
+	}
+
+	res, body = ts.do(t, http.MethodPost, fmt.Sprintf("/v1/users/%d/erase", user.ID), token, nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("erase: got status %d: %s", res.StatusCode, body)
+	}
+
+	res, body = ts.do(t, http.MethodGet, feedPath, "", nil, nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("feed after erase: got status %d, want %d: %s", res.StatusCode, http.StatusNotFound, body)
+	}
+
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM tokens WHERE user_id = $1", user.ID).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("after erase: %d tokens left", count)
+	}
+}
+
+// TestRotateKeysSkipsErased checks that key rotation leaves the placeholders of
+// erased users unsealed.
+func TestRotateKeysSkipsErased(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, newTestKeyring(t))
+
+	user, _ := insertTestUser(t, app, "user@example.com", data.Registered, "user-pa55word")
+
+	err := app.models.PersonalData.Erase(int64(user.ID), int64(user.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		n, err := app.models.UserInfos.RotateKeys(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	var sealed bool
+	err = db.QueryRow("SELECT data_key IS NOT NULL OR search_words IS NOT NULL FROM user_info WHERE id = $1", user.ID).Scan(&sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed {
+		t.Error("the erased user was sealed by the rotation")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// modulePrerequisitesHandler builds the handlers that add or remove prerequisites of
+// a module. Both take {"prerequisite_ids": [...]} and report how many changed.
+func (app *application) modulePrerequisitesHandler(apply func(moduleID int64, ids []int64) (int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		_, err = app.models.ModuleInfos.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		var input struct {
+			PrerequisiteIDs []int64 `json:"prerequisite_ids"`
+		}
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		v := validator.New()
+		data.ValidateIDs(v, "prerequisite_ids", input.PrerequisiteIDs)
+		v.Check(!validator.PermittedValue(id, input.PrerequisiteIDs...), "prerequisite_ids", "a module can't be its own prerequisite")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		affected, err := apply(id, input.PrerequisiteIDs)
+		if err != nil {
+			var cycle *data.PrerequisiteCycleError
+			switch {
+			case errors.As(err, &cycle):
+				v.AddError("prerequisite_ids", cycle.Error())
+				app.failedValidationResponse(w, r, v.Errors)
+			case errors.Is(err, data.ErrInvalidReference):
+				v.AddError("prerequisite_ids", "must only contain existing ids")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"affected": affected}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// listModulePrerequisitesHandler returns the direct prerequisites of a module, or
+// with ?transitive=true everything it depends on.
+func (app *application) listModulePrerequisitesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.ModuleInfos.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	transitive := app.readString(r.URL.Query(), "transitive", "false") == "true"
+
+	prerequisites, err := app.models.ModulePrerequisites.GetAll(id, transitive)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"prerequisites": prerequisites}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// moduleOrderHandler returns the modules listed in ?ids= together with their
+// prerequisites in the order they should be taken. With ?format=dot the graph is
+// returned in Graphviz DOT format instead of JSON.
+func (app *application) moduleOrderHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	var ids []int64
+	for _, s := range app.readCSV(qs, "ids", []string{}) {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			v.AddError("ids", "must be a comma-separated list of module ids")
+			break
+		}
+		ids = append(ids, id)
+	}
+	if v.Valid() {
+		data.ValidateIDs(v, "ids", ids)
+	}
+
+	format := app.readString(qs, "format", "json")
+	v.Check(validator.PermittedValue(format, "json", "dot"), "format", "must be json or dot")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	modules, edges, err := app.models.ModulePrerequisites.GetPlan(ids)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidReference):
+			v.AddError("ids", "must only contain existing ids")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(prerequisiteDOT(modules, edges)))
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"modules": modules, "prerequisites": edges}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// prerequisiteDOT renders the plan as a left-to-right graph with one rank per level.
+// Prerequisites that weren't requested are drawn dashed.
+func prerequisiteDOT(modules []*data.PlannedModule, edges []data.PrerequisiteEdge) string {
+	var b strings.Builder
+
+	b.WriteString("digraph prerequisites {\n\trankdir=LR;\n\tnode [shape=box];\n")
+
+	levels := make(map[int][]int)
+	for _, pm := range modules {
+		style := ""
+		if !pm.Requested {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "\tm%d [label=%s%s];\n", pm.ID, dotQuote(pm.ModuleName), style)
+		levels[pm.Level] = append(levels[pm.Level], pm.ID)
+	}
+
+	for level := 1; level <= len(levels); level++ {
+		b.WriteString("\t{ rank=same;")
+		for _, id := range levels[level] {
+			fmt.Fprintf(&b, " m%d;", id)
+		}
+		b.WriteString(" }\n")
+	}
+
+	for _, edge := range edges {
+		fmt.Fprintf(&b, "\tm%d -> m%d;\n", edge.PrerequisiteID, edge.ModuleID)
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote quotes s as a DOT string. Unlike strconv.Quote it leaves non-ASCII text
+// alone, since DOT doesn't understand \u escapes.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long an issued proof-of-work challenge stays valid.
+const challengeTTL = 5 * time.Minute
+
+// A challenge has the form "<nonce>.<expiry unix>.<signature>". The client must find
+// a solution such that sha256(challenge + solution) starts with at least
+// powDifficulty zero bits. Challenges are stateless apart from the ledger below,
+// which remembers spent challenges until they expire so they can't be replayed.
+type registrationChallenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	Expiry     time.Time `json:"expiry"`
+}
+
+type challengeLedger struct {
+	mu    sync.Mutex
+	spent map[string]time.Time
+}
+
+func newChallengeLedger() *challengeLedger {
+	return &challengeLedger{spent: make(map[string]time.Time)}
+}
+
+// spend marks the challenge as used and reports whether it was unused before.
+func (l *challengeLedger) spend(challenge string, expiry time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for c, exp := range l.spent {
+		if time.Now().After(exp) {
+			delete(l.spent, c)
+		}
+	}
+
+	if _, found := l.spent[challenge]; found {
+		return false
+	}
+	l.spent[challenge] = expiry
+	return true
+}
+
+func (app *application) newRegistrationChallenge() (*registrationChallenge, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	expiry := time.Now().Add(challengeTTL)
+	payload := fmt.Sprintf("%s.%d", base64.RawURLEncoding.EncodeToString(nonce), expiry.Unix())
+
+	return &registrationChallenge{
+		Challenge:  payload + "." + sign(app.config.registration.secret, payload),
+		Difficulty: app.config.registration.powDifficulty,
+		Expiry:     expiry,
+	}, nil
+}
+
+// verifyRegistrationChallenge checks the signature, expiry and solution of a
+// challenge and records it as spent.
+func (app *application) verifyRegistrationChallenge(challenge, solution string) bool {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(app.config.registration.secret, payload))) {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false
+	}
+	expiry := time.Unix(unix, 0)
+	if time.Now().After(expiry) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(challenge + solution))
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	if zeros < app.config.registration.powDifficulty {
+		return false
+	}
+
+	return app.spentChallenges.spend(challenge, expiry)
+}
+
+func (app *application) registrationChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, err := app.newRegistrationChallenge()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"challenge": challenge}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// selfRegisterUserHandler lets students create their own account when open
+// registration is enabled. The role is always Registered and the account stays
+// inactive until the emailed activation token is redeemed.
+func (app *application) selfRegisterUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string `json:"name"`
+		Surname   string `json:"surname"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+		Challenge string `json:"challenge"`
+		Solution  string `json:"solution"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if app.config.registration.powDifficulty > 0 {
+		v.Check(input.Challenge != "", "challenge", "must be provided")
+		if v.Valid() {
+			v.Check(app.verifyRegistrationChallenge(input.Challenge, input.Solution), "challenge", "invalid, expired or unsolved challenge")
+		}
+	}
+
+	data.ValidateEmailDomain(v, input.Email, app.config.registration.allowedDomains)
+	data.ValidatePasswordPlaintext(v, input.Password)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := &data.UserInfo{
+		Name:      input.Name,
+		Surname:   input.Surname,
+		Email:     input.Email,
+		Role:      data.Registered,
+		Activated: false,
+	}
+
+	err = user.PasswordHash.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.UserInfos.Insert(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.models.Tokens.New(int64(user.ID), 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.notify(user, notification{
+		category: data.CategoryAccount,
+		subject:  "Activate your account",
+		body:     "Dear " + user.Name + ",\n\nPlease activate your account with the token:\n\n" + token.Plaintext,
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user.Account()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"strconv"
+)
+
+// listModuleRevisionsHandler returns the history of a module to its author and
+// reviewers, since it includes unpublished versions.
+func (app *application) listModuleRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	module, ok := app.readModuleForStaff(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := app.models.ModuleInfos.GetRevisions(int64(module.ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getModuleRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	module, ok := app.readModuleForStaff(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.ModuleInfos.GetRevision(int64(module.ID), version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// diffModuleRevisionsHandler lists the fields that changed between ?from= and ?to=.
+// to defaults to the current version.
+func (app *application) diffModuleRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	module, ok := app.readModuleForStaff(w, r)
+	if !ok {
+		return
+	}
+	id := int64(module.ID)
+
+	current, err := strconv.Atoi(module.Version)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	from := app.readInt(qs, "from", 0, v)
+	to := app.readInt(qs, "to", current, v)
+
+	v.Check(from > 0, "from", "must be a version of the module")
+	v.Check(from <= current, "from", "must not be greater than the current version")
+	v.Check(to > 0, "to", "must be a version of the module")
+	v.Check(to <= current, "to", "must not be greater than the current version")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	fromRevision, err := app.models.ModuleInfos.GetRevision(id, from)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	toRevision, err := app.models.ModuleInfos.GetRevision(id, to)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"from": from, "to": to, "changes": data.DiffRevisions(fromRevision, toRevision)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreModuleRevisionHandler rolls a module back to an older version by saving
+// that version's content as a new draft. With If-Match the rollback only happens if
+// the module is still at the given version.
+func (app *application) restoreModuleRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	module, err := app.models.ModuleInfos.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkModuleEditable(w, r, module) {
+		return
+	}
+
+	expected, _, err := app.readExpectedVersion(r, nil)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	moduleInfo, err := app.models.ModuleInfos.Restore(id, version, expected)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(moduleInfo.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"module_info": moduleInfo}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
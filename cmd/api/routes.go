@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/shynggys9219/greenlight/internal/data"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -11,31 +12,147 @@ func (app *application) routes() http.Handler {
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	router.GlobalOPTIONS = http.HandlerFunc(app.globalOptions)
 	// Register the relevant methods, URL patterns and handler functions for our
 	// endpoints using the HandlerFunc() method. Note that http.MethodGet and
 	// http.MethodPost are constants which equate to the strings "GET" and "POST"
 	// respectively.
-	router.HandlerFunc(http.MethodDelete, "/v1/module-infos/:id", app.deleteModuleInfo)
-	router.HandlerFunc(http.MethodPut, "/v1/module-infos/:id", app.editModuleInfo)
-	router.HandlerFunc(http.MethodGet, "/v1/module-infos", app.getLastFiftyModuleInfo)
-	router.HandlerFunc(http.MethodGet, "/v1/module-infos/:id", app.getModuleInfo)
-	router.HandlerFunc(http.MethodPost, "/v1/module-infos/create", app.createModuleInfo)
+	router.Handler(http.MethodDelete, "/v1/module-infos/:id", app.requireAdminRole(app.deleteModuleInfo))
+	router.Handler(http.MethodPut, "/v1/module-infos/:id", app.requireActivatedUser(http.HandlerFunc(app.editModuleInfo)))
+	router.Handler(http.MethodPatch, "/v1/module-infos/:id", app.requireActivatedUser(http.HandlerFunc(app.patchModuleInfoHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/module-infos", app.listModuleInfoHandler)
+	router.Handler(http.MethodGet, "/v1/module-infos/:id", app.acceptPatch(http.HandlerFunc(app.getModuleInfo)))
+	router.Handler(http.MethodPost, "/v1/module-infos", app.requireActivatedUser(http.HandlerFunc(app.createModuleInfo)))
+	router.Handler(http.MethodPost, "/v1/module-infos/:id/submit", app.requireActivatedUser(app.moduleTransitionHandler(data.ModuleSubmit)))
+	router.Handler(http.MethodPost, "/v1/module-infos/:id/approve", app.requireActivatedUser(app.moduleTransitionHandler(data.ModuleApprove)))
+	router.Handler(http.MethodPost, "/v1/module-infos/:id/reject", app.requireActivatedUser(app.moduleTransitionHandler(data.ModuleReject)))
+	router.Handler(http.MethodPost, "/v1/module-infos/:id/publish", app.requireActivatedUser(app.moduleTransitionHandler(data.ModulePublish)))
+	router.Handler(http.MethodPost, "/v1/module-infos/:id/archive", app.requireActivatedUser(app.moduleTransitionHandler(data.ModuleArchive)))
+	router.Handler(http.MethodGet, "/v1/module-infos/:id/reviews", app.requireActivatedUser(http.HandlerFunc(app.listModuleReviewsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/module-infos/:id/prerequisites", app.listModulePrerequisitesHandler)
+	router.Handler(http.MethodPost, "/v1/module-infos/:id/prerequisites", app.requireAdminRole(app.modulePrerequisitesHandler(app.models.ModulePrerequisites.Add)))
+	router.Handler(http.MethodDelete, "/v1/module-infos/:id/prerequisites", app.requireAdminRole(app.modulePrerequisitesHandler(app.models.ModulePrerequisites.Remove)))
+	router.Handler(http.MethodGet, "/v1/module-infos/:id/revisions", app.requireActivatedUser(http.HandlerFunc(app.listModuleRevisionsHandler)))
+	router.Handler(http.MethodGet, "/v1/module-infos/:id/revisions/:version", app.requireActivatedUser(http.HandlerFunc(app.getModuleRevisionHandler)))
+	router.Handler(http.MethodPost, "/v1/module-infos/:id/revisions/:version/restore", app.requireAdminRole(app.restoreModuleRevisionHandler))
+	router.Handler(http.MethodGet, "/v1/module-infos/:id/diff", app.requireActivatedUser(http.HandlerFunc(app.diffModuleRevisionsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/module-order", app.moduleOrderHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/department-info", app.createDepInfoHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/department-info/:id", app.getDepInfoHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/department-info", app.listDepInfoHandler)
+	router.Handler(http.MethodGet, "/v1/department-info/:id", app.acceptPatch(http.HandlerFunc(app.getDepInfoHandler)))
+	router.Handler(http.MethodPatch, "/v1/department-info/:id", app.requireAdminRole(app.patchDepInfoHandler))
 	//router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
+	//router.HandlerFunc(http.MethodGet, "/v1/movies", app.showAllMovies)
 	//router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
 	//router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.updateMovieHandler)
 	//router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
 	// Return the httprouter instance.
 
 	router.Handler(http.MethodPost, "/v1/users", app.requireAdminRole(app.registerUserInfoHandler))
-	router.Handler(http.MethodPut, "/v1/users/activated", app.requireAdminRole(app.activateUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 	router.Handler(http.MethodPut, "/v1/users/edit", app.requireAdminRole(app.editUserInfo))
 	router.Handler(http.MethodDelete, "/v1/users/delete", app.requireAdminRole(app.deleteUserInfo))
-	router.Handler(http.MethodGet, "/v1/users/:id", app.requireAdminRole(app.getUserInfoHandler))
-	router.Handler(http.MethodGet, "/v1/users/all", app.requireAdminRole(app.listUsersHandler))
+	router.Handler(http.MethodGet, "/v1/users/:id", app.requireActivatedUser(app.acceptPatch(http.HandlerFunc(app.getUserInfoHandler))))
+	router.Handler(http.MethodPatch, "/v1/users/:id", app.requireAdminRole(app.patchUserInfoHandler))
+	router.Handler(http.MethodGet, "/v1/users", app.requireAdminRole(app.listUsersHandler))
+	router.Handler(http.MethodPost, "/v1/users/:id/suspend", app.requireAdminRole(app.suspendUserHandler))
+	router.Handler(http.MethodPost, "/v1/users/:id/reactivate", app.requireAdminRole(app.reactivateUserHandler))
+	router.Handler(http.MethodPost, "/v1/users/:id/expiry", app.requireAdminRole(app.setUserExpiryHandler))
+	router.Handler(http.MethodGet, "/v1/users/:id/account-events", app.requireAdminRole(app.listAccountEventsHandler))
+	router.Handler(http.MethodGet, "/v1/users/:id/personal-data", app.requireActivatedUser(http.HandlerFunc(app.exportPersonalDataHandler)))
+	router.Handler(http.MethodPost, "/v1/users/:id/erase", app.requireActivatedUser(http.HandlerFunc(app.erasePersonalDataHandler)))
+	router.Handler(http.MethodPut, "/v1/users/me/avatar", app.requireActivatedUser(http.HandlerFunc(app.uploadAvatarHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/avatar", app.getAvatarHandler)
+	router.Handler(http.MethodGet, "/v1/users/:id/notifications", app.requireActivatedUser(http.HandlerFunc(app.listNotificationsHandler)))
+	router.Handler(http.MethodPost, "/v1/users/:id/notifications/mark-read", app.requireActivatedUser(http.HandlerFunc(app.markNotificationsReadHandler)))
+	router.Handler(http.MethodGet, "/v1/users/:id/notification-preferences", app.requireActivatedUser(app.acceptPatch(http.HandlerFunc(app.getNotificationPreferencesHandler))))
+	router.Handler(http.MethodPatch, "/v1/users/:id/notification-preferences", app.requireActivatedUser(http.HandlerFunc(app.updateNotificationPreferencesHandler)))
+	router.Handler(http.MethodGet, "/v1/users/:id/enrollments", app.requireActivatedUser(http.HandlerFunc(app.listUserEnrollmentsHandler)))
+	router.Handler(http.MethodGet, "/v1/users/:id/timetable", app.requireActivatedUser(app.timetableHandler(app.teacherTimetable)))
+	router.Handler(http.MethodPost, "/v1/users/:id/calendar-feed", app.requireActivatedUser(http.HandlerFunc(app.createCalendarFeedHandler)))
+	router.Handler(http.MethodPost, "/v1/users/:id/calendar-feed/revoke", app.requireActivatedUser(http.HandlerFunc(app.revokeCalendarFeedHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/calendar-feeds/:token", app.calendarFeedHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/notifications/unsubscribe", app.unsubscribeHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/notifications/unsubscribe", app.unsubscribeHandler)
+
+	router.Handler(http.MethodPost, "/v1/groups", app.requireAdminRole(app.createGroupHandler))
+	router.Handler(http.MethodGet, "/v1/groups", app.requireActivatedUser(http.HandlerFunc(app.listGroupsHandler)))
+	router.Handler(http.MethodGet, "/v1/groups/:id", app.requireActivatedUser(http.HandlerFunc(app.getGroupHandler)))
+	router.Handler(http.MethodPut, "/v1/groups/:id", app.requireAdminRole(app.updateGroupHandler))
+	router.Handler(http.MethodDelete, "/v1/groups/:id", app.requireAdminRole(app.deleteGroupHandler))
+	router.Handler(http.MethodPost, "/v1/groups/:id/members", app.requireAdminRole(app.groupBulkHandler("user_ids", app.models.Groups.AddMembers)))
+	router.Handler(http.MethodDelete, "/v1/groups/:id/members", app.requireAdminRole(app.groupBulkHandler("user_ids", app.models.Groups.RemoveMembers)))
+	router.Handler(http.MethodGet, "/v1/groups/:id/modules", app.requireActivatedUser(http.HandlerFunc(app.listGroupModulesHandler)))
+	router.Handler(http.MethodPost, "/v1/groups/:id/modules", app.requireAdminRole(app.groupBulkHandler("module_ids", app.models.Groups.AssignModules)))
+	router.Handler(http.MethodDelete, "/v1/groups/:id/modules", app.requireAdminRole(app.groupBulkHandler("module_ids", app.models.Groups.UnassignModules)))
+	router.Handler(http.MethodPost, "/v1/groups/:id/announcements", app.requireAdminRole(app.sendGroupAnnouncementHandler))
+
+	router.Handler(http.MethodPost, "/v1/terms", app.requireAdminRole(app.createTermHandler))
+	router.Handler(http.MethodGet, "/v1/terms", app.requireActivatedUser(http.HandlerFunc(app.listTermsHandler)))
+	router.Handler(http.MethodGet, "/v1/terms/:id", app.requireActivatedUser(http.HandlerFunc(app.getTermHandler)))
+	router.Handler(http.MethodPut, "/v1/terms/:id", app.requireAdminRole(app.updateTermHandler))
+	router.Handler(http.MethodDelete, "/v1/terms/:id", app.requireAdminRole(app.deleteTermHandler))
+	router.Handler(http.MethodGet, "/v1/terms/:id/offerings", app.requireActivatedUser(http.HandlerFunc(app.listOfferingsHandler)))
+
+	router.Handler(http.MethodPost, "/v1/module-offerings", app.requireAdminRole(app.createOfferingHandler))
+	router.Handler(http.MethodGet, "/v1/module-offerings", app.requireActivatedUser(http.HandlerFunc(app.listOfferingsHandler)))
+	router.Handler(http.MethodGet, "/v1/module-offerings/:id", app.requireActivatedUser(http.HandlerFunc(app.getOfferingHandler)))
+	router.Handler(http.MethodPut, "/v1/module-offerings/:id", app.requireAdminRole(app.updateOfferingHandler))
+	router.Handler(http.MethodDelete, "/v1/module-offerings/:id", app.requireAdminRole(app.deleteOfferingHandler))
+	router.Handler(http.MethodPost, "/v1/module-offerings/:id/enrollment", app.requireActivatedUser(http.HandlerFunc(app.enrollHandler)))
+	router.Handler(http.MethodGet, "/v1/module-offerings/:id/enrollment", app.requireActivatedUser(http.HandlerFunc(app.getEnrollmentHandler)))
+	router.Handler(http.MethodDelete, "/v1/module-offerings/:id/enrollment", app.requireActivatedUser(http.HandlerFunc(app.dropEnrollmentHandler)))
+	router.Handler(http.MethodGet, "/v1/module-offerings/:id/enrollments", app.requireActivatedUser(http.HandlerFunc(app.listOfferingEnrollmentsHandler)))
+	router.Handler(http.MethodDelete, "/v1/module-offerings/:id/enrollments/:user_id", app.requireAdminRole(app.dropEnrollmentHandler))
+	router.Handler(http.MethodPost, "/v1/module-offerings/:id/sessions", app.requireAdminRole(app.createSessionHandler))
+	router.Handler(http.MethodGet, "/v1/module-offerings/:id/sessions", app.requireActivatedUser(app.timetableHandler(app.offeringTimetable)))
+
+	router.Handler(http.MethodGet, "/v1/module-sessions/:id", app.requireActivatedUser(http.HandlerFunc(app.getSessionHandler)))
+	router.Handler(http.MethodPut, "/v1/module-sessions/:id", app.requireAdminRole(app.updateSessionHandler))
+	router.Handler(http.MethodDelete, "/v1/module-sessions/:id", app.requireAdminRole(app.deleteSessionHandler))
+	router.Handler(http.MethodGet, "/v1/module-infos/:id/timetable", app.requireActivatedUser(app.timetableHandler(app.moduleTimetable)))
+	router.HandlerFunc(http.MethodGet, "/v1/module-infos/:id/calendar.ics", app.moduleCalendarHandler)
+	router.Handler(http.MethodGet, "/v1/rooms/:room/timetable", app.requireActivatedUser(app.timetableHandler(app.roomTimetable)))
+
+	router.Handler(http.MethodPost, "/v1/exam-rooms", app.requireAdminRole(app.createExamRoomHandler))
+	router.Handler(http.MethodGet, "/v1/exam-rooms", app.requireActivatedUser(http.HandlerFunc(app.listExamRoomsHandler)))
+	router.Handler(http.MethodPut, "/v1/exam-rooms/:id", app.requireAdminRole(app.updateExamRoomHandler))
+	router.Handler(http.MethodDelete, "/v1/exam-rooms/:id", app.requireAdminRole(app.deleteExamRoomHandler))
+	router.Handler(http.MethodPost, "/v1/module-offerings/:id/exam", app.requireAdminRole(app.createExamHandler))
+	router.Handler(http.MethodGet, "/v1/module-offerings/:id/exam", app.requireActivatedUser(http.HandlerFunc(app.getOfferingExamHandler)))
+	router.Handler(http.MethodGet, "/v1/exam-sessions", app.requireActivatedUser(http.HandlerFunc(app.listExamsHandler)))
+	router.Handler(http.MethodGet, "/v1/exam-sessions/:id", app.requireActivatedUser(http.HandlerFunc(app.getExamHandler)))
+	router.Handler(http.MethodPut, "/v1/exam-sessions/:id", app.requireAdminRole(app.updateExamHandler))
+	router.Handler(http.MethodDelete, "/v1/exam-sessions/:id", app.requireAdminRole(app.deleteExamHandler))
+	router.Handler(http.MethodPost, "/v1/exam-timetable", app.requireAdminRole(app.generateExamTimetableHandler(false)))
+	router.Handler(http.MethodPost, "/v1/exam-timetable/preview", app.requireAdminRole(app.generateExamTimetableHandler(true)))
+
+	router.Handler(http.MethodPost, "/v1/grade-scales", app.requireAdminRole(app.createGradeScaleHandler))
+	router.Handler(http.MethodGet, "/v1/grade-scales", app.requireActivatedUser(http.HandlerFunc(app.listGradeScalesHandler)))
+	router.Handler(http.MethodGet, "/v1/grade-scales/:id", app.requireActivatedUser(http.HandlerFunc(app.getGradeScaleHandler)))
+	router.Handler(http.MethodPut, "/v1/grade-scales/:id", app.requireAdminRole(app.updateGradeScaleHandler))
+	router.Handler(http.MethodDelete, "/v1/grade-scales/:id", app.requireAdminRole(app.deleteGradeScaleHandler))
+	router.Handler(http.MethodPost, "/v1/assessment-components", app.requireAdminRole(app.createComponentHandler))
+	router.Handler(http.MethodGet, "/v1/module-infos/:id/assessments", app.requireActivatedUser(http.HandlerFunc(app.listComponentsHandler)))
+	router.Handler(http.MethodPut, "/v1/assessment-components/:id", app.requireAdminRole(app.updateComponentHandler))
+	router.Handler(http.MethodDelete, "/v1/assessment-components/:id", app.requireAdminRole(app.deleteComponentHandler))
+	router.Handler(http.MethodPut, "/v1/module-offerings/:id/grades", app.requireActivatedUser(http.HandlerFunc(app.enterScoresHandler)))
+	router.Handler(http.MethodGet, "/v1/module-offerings/:id/grades", app.requireActivatedUser(http.HandlerFunc(app.listOfferingGradesHandler)))
+	router.Handler(http.MethodGet, "/v1/users/:id/grades", app.requireActivatedUser(http.HandlerFunc(app.listUserGradesHandler)))
+	router.Handler(http.MethodPost, "/v1/module-offerings/:id/completions", app.requireAdminRole(app.recordCompletionsHandler))
+	router.Handler(http.MethodGet, "/v1/users/:id/transcript", app.requireActivatedUser(http.HandlerFunc(app.transcriptHandler)))
+	router.Handler(http.MethodPost, "/v1/users/:id/transcript/verification", app.requireActivatedUser(http.HandlerFunc(app.issueTranscriptVerificationHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/transcript-verifications/:code", app.verifyTranscriptHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/transcript-verifications/:code", app.checkTranscriptHandler)
+	router.Handler(http.MethodGet, "/v1/workload", app.requireAdminRole(app.listWorkloadHandler))
+	router.Handler(http.MethodGet, "/v1/users/:id/workload", app.requireActivatedUser(http.HandlerFunc(app.userWorkloadHandler)))
+
+	if app.config.registration.open {
+		router.Handler(http.MethodPost, "/v1/registrations", app.registrationRateLimit(http.HandlerFunc(app.selfRegisterUserHandler)))
+		router.Handler(http.MethodPost, "/v1/registrations/challenge", app.registrationRateLimit(http.HandlerFunc(app.registrationChallengeHandler)))
+	}
 
 	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestModuleRoutes checks that the module routes are registered under the methods
+// they are documented with. Unauthenticated requests are stopped by the auth
+// middleware with 401 before anything touches the database, whereas a route that
+// doesn't exist answers 404 or 405.
+func TestModuleRoutes(t *testing.T) {
+	app := &application{}
+	routes := app.routes()
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/v1/module-infos"},
+		{http.MethodPost, "/v1/module-infos/1/revisions/2/restore"},
+		{http.MethodPost, "/v1/module-infos/1/prerequisites"},
+		{http.MethodDelete, "/v1/module-infos/1/prerequisites"},
+		{http.MethodPost, "/v1/module-infos/1/submit"},
+		{http.MethodPost, "/v1/module-infos/1/approve"},
+		{http.MethodPost, "/v1/module-infos/1/reject"},
+		{http.MethodPost, "/v1/module-infos/1/publish"},
+		{http.MethodPost, "/v1/module-infos/1/archive"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, rr.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+// TestOptionsAcceptPatch checks that OPTIONS advertises the PATCH formats on the
+// paths that take PATCH, and only there.
+func TestOptionsAcceptPatch(t *testing.T) {
+	app := &application{}
+	routes := app.routes()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v1/users/1", acceptedPatchTypes},
+		{"/v1/module-infos/1", acceptedPatchTypes},
+		{"/v1/department-info/1", acceptedPatchTypes},
+		{"/v1/groups/1", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Accept-Patch"); got != tt.want {
+			t.Errorf("OPTIONS %s: got Accept-Patch %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
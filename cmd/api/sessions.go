@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// saveSession validates the session and its teacher and saves it with save,
+// writing the response for any error. It returns false if it wrote one.
+func (app *application) saveSession(w http.ResponseWriter, r *http.Request, session *data.Session, save func(*data.Session) error) bool {
+	v := validator.New()
+	if data.ValidateSession(v, session); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	if session.TeacherID != nil {
+		_, err := app.models.UserInfos.GetByID(*session.TeacherID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("teacherId", "must be an existing user id")
+			app.failedValidationResponse(w, r, v.Errors)
+			return false
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+	}
+
+	err := save(session)
+	if err != nil {
+		var conflictErr *data.SessionConflictError
+		switch {
+		case errors.As(err, &conflictErr):
+			app.sessionConflictResponse(w, r, conflictErr.Conflicts)
+		case errors.Is(err, data.ErrInvalidReference):
+			v.AddError("teacherId", "must be an existing user id")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+	return true
+}
+
+// createSessionHandler adds a weekly session to an offering. It is taught by the
+// offering's teacher unless teacherId says otherwise. Sessions that would put a
+// room or a teacher in two places at once are rejected with 409 and the sessions
+// they clash with.
+func (app *application) createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	offering, err := app.models.Offerings.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Day       string `json:"day"`
+		StartsAt  string `json:"startsAt"`
+		EndsAt    string `json:"endsAt"`
+		Room      string `json:"room"`
+		TeacherID *int64 `json:"teacherId"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	session := &data.Session{
+		OfferingID: offering.ID,
+		Day:        strings.ToLower(input.Day),
+		StartsAt:   input.StartsAt,
+		EndsAt:     input.EndsAt,
+		Room:       input.Room,
+		TeacherID:  input.TeacherID,
+	}
+	if session.TeacherID == nil {
+		session.TeacherID = offering.TeacherID
+	}
+
+	if !app.saveSession(w, r, session, app.models.Sessions.Insert) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/module-sessions/%d", session.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"session": session}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	session, err := app.models.Sessions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(session.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"session": session}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateSessionHandler moves a session to the day, time, room or teacher present
+// in the body. A teacherId of 0 leaves it without a teacher.
+func (app *application) updateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	session, err := app.models.Sessions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Day       *string `json:"day"`
+		StartsAt  *string `json:"startsAt"`
+		EndsAt    *string `json:"endsAt"`
+		Room      *string `json:"room"`
+		TeacherID *int64  `json:"teacherId"`
+		Version   *int    `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != session.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Day != nil {
+		session.Day = strings.ToLower(*input.Day)
+	}
+	if input.StartsAt != nil {
+		session.StartsAt = *input.StartsAt
+	}
+	if input.EndsAt != nil {
+		session.EndsAt = *input.EndsAt
+	}
+	if input.Room != nil {
+		session.Room = *input.Room
+	}
+	if input.TeacherID != nil {
+		session.TeacherID = input.TeacherID
+		if *input.TeacherID == 0 {
+			session.TeacherID = nil
+		}
+	}
+
+	if !app.saveSession(w, r, session, app.models.Sessions.Update) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(session.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"session": session}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Sessions.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// timetableHandler builds the handlers that list weekly sessions: of an offering,
+// of a module, in a room or taught by a user. filter fills in the part of the
+// filter that comes from the URL and returns false if it wrote a response. The
+// timetables of all but an offering accept ?term=, and default to the offerings
+// that haven't ended. Only admins and the teacher whose timetable it is see the
+// sessions of modules that aren't published.
+func (app *application) timetableHandler(filter func(w http.ResponseWriter, r *http.Request, f *data.TimetableFilter) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var f data.TimetableFilter
+
+		v := validator.New()
+
+		f.TermID = int64(app.readInt(r.URL.Query(), "term", 0, v))
+		v.Check(f.TermID >= 0, "term", "must be a positive term id")
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		if !filter(w, r, &f) {
+			return
+		}
+
+		user := app.contextGetUser(r)
+		f.PublishedOnly = user.Role != data.Admin && f.TeacherID != int64(user.ID)
+
+		sessions, err := app.models.Sessions.GetTimetable(f)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) offeringTimetable(w http.ResponseWriter, r *http.Request, f *data.TimetableFilter) bool {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return false
+	}
+
+	_, err = app.models.Offerings.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	f.OfferingID = id
+	return true
+}
+
+func (app *application) moduleTimetable(w http.ResponseWriter, r *http.Request, f *data.TimetableFilter) bool {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return false
+	}
+
+	f.ModuleID = id
+	return true
+}
+
+func (app *application) roomTimetable(w http.ResponseWriter, r *http.Request, f *data.TimetableFilter) bool {
+	f.Room = strings.TrimSpace(httprouter.ParamsFromContext(r.Context()).ByName("room"))
+	if f.Room == "" {
+		app.notFoundResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// teacherTimetable reads the teacher from the :id parameter, which may be "me".
+func (app *application) teacherTimetable(w http.ResponseWriter, r *http.Request, f *data.TimetableFilter) bool {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return false
+	}
+
+	f.TeacherID = id
+	return true
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (app *application) createTermHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string    `json:"name"`
+		AcademicYear string    `json:"academicYear"`
+		StartsOn     data.Date `json:"startsOn"`
+		EndsOn       data.Date `json:"endsOn"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	term := &data.Term{
+		Name:         input.Name,
+		AcademicYear: input.AcademicYear,
+		StartsOn:     input.StartsOn,
+		EndsOn:       input.EndsOn,
+	}
+
+	v := validator.New()
+	if data.ValidateTerm(v, term); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Terms.Insert(term)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateTerm):
+			v.AddError("name", "a term with this name already exists for the academic year")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/terms/%d", term.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"term": term}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getTermHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	term, err := app.models.Terms.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(term.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"term": term}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listTermsHandler lists the terms, optionally of one academic year or only those
+// running on the ?on= date. ?current=true is short for ?on= today.
+func (app *application) listTermsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		AcademicYear string
+		On           time.Time
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.AcademicYear = app.readString(qs, "academic_year", "")
+	input.On = app.readTime(qs, "on", time.Time{}, v)
+	if app.readString(qs, "current", "") == "true" {
+		input.On = time.Now()
+	}
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-starts_on")
+	input.Filters.SortSafelist = []string{"id", "name", "starts_on", "-id", "-name", "-starts_on"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	terms, metadata, err := app.models.Terms.GetAll(input.AcademicYear, input.On, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"terms": terms, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateTermHandler changes the fields present in the body. The new dates must
+// still contain all of the term's offerings.
+func (app *application) updateTermHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	term, err := app.models.Terms.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name         *string    `json:"name"`
+		AcademicYear *string    `json:"academicYear"`
+		StartsOn     *data.Date `json:"startsOn"`
+		EndsOn       *data.Date `json:"endsOn"`
+		Version      *int       `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != term.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Name != nil {
+		term.Name = *input.Name
+	}
+	if input.AcademicYear != nil {
+		term.AcademicYear = *input.AcademicYear
+	}
+	if input.StartsOn != nil {
+		term.StartsOn = *input.StartsOn
+	}
+	if input.EndsOn != nil {
+		term.EndsOn = *input.EndsOn
+	}
+
+	v := validator.New()
+	if data.ValidateTerm(v, term); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Terms.Update(term)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateTerm):
+			v.AddError("name", "a term with this name already exists for the academic year")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrOfferingsOutsideTerm):
+			app.errorResponse(w, r, http.StatusConflict, "the term has module offerings outside of the new dates")
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(term.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"term": term}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteTermHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Terms.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrTermInUse):
+			app.errorResponse(w, r, http.StatusConflict, "the term still has module offerings")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "term successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
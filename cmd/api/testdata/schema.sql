@@ -0,0 +1,41 @@
+-- The tables as they stood after migration 000009. The early migrations can't be
+-- replayed as written (000006 points at module_info before 000007 creates it, 000008
+-- names the columns fname/sname/user_role and 000009 references a users table), so
+-- the tests start from this and apply 000010 onwards on top.
+CREATE TABLE module_info (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    module_name VARCHAR(255) NOT NULL,
+    module_duration INTEGER NOT NULL,
+    exam_type VARCHAR(255) NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE department_info (
+    id BIGSERIAL PRIMARY KEY,
+    department_name VARCHAR,
+    department_director VARCHAR,
+    staff_quantity INT NOT NULL,
+    module_id INT REFERENCES module_info(id)
+);
+
+CREATE TABLE user_info (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    name VARCHAR(255),
+    surname VARCHAR(255),
+    email VARCHAR(255) UNIQUE NOT NULL,
+    password_hash BYTEA NOT NULL,
+    role VARCHAR(50),
+    activated BOOL NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE tokens (
+    hash BYTEA PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES user_info ON DELETE CASCADE,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    scope TEXT NOT NULL
+);
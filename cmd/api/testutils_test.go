@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/fieldcrypt"
+	"github.com/shynggys9219/greenlight/internal/jsonlog"
+	"github.com/shynggys9219/greenlight/internal/mailer"
+	"github.com/shynggys9219/greenlight/internal/storage"
+)
+
+// testDSNEnv names the variable holding the DSN of a PostgreSQL database the
+// tests may create schemas in. Tests that need a database are skipped without it.
+const testDSNEnv = "GREENLIGHT_TEST_DB_DSN"
+
+// newTestDB opens a connection pool whose search path points at a fresh schema
+// holding every migration. The schema is dropped when the test ends.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	b := make([]byte, 8)
+	_, err = rand.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(b)
+
+	// pg_trgm lives in public so that every test schema can see it.
+	for _, stmt := range []string{"CREATE EXTENSION IF NOT EXISTS pg_trgm SCHEMA public", "CREATE SCHEMA " + schema} {
+		_, err = admin.Exec(stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		admin, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer admin.Close()
+		_, err = admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	db, err := sql.Open("postgres", withSearchPath(dsn, schema+",public"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	files := []string{filepath.Join("testdata", "schema.sql")}
+	migrations, err := filepath.Glob(filepath.Join("..", "..", "migrations", "*.up.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+	for _, migration := range migrations {
+		if filepath.Base(migration) >= "000010" {
+			files = append(files, migration)
+		}
+	}
+
+	for _, file := range files {
+		script, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(script))
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+	}
+
+	return db
+}
+
+// withSearchPath adds a search_path run-time parameter to a URL or key=value DSN.
+func withSearchPath(dsn, path string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			q := u.Query()
+			q.Set("search_path", path)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + path
+}
+
+// newTestKeyring returns a keyring with random keys, for tests of sealed user rows.
+func newTestKeyring(t *testing.T) *fieldcrypt.Keyring {
+	t.Helper()
+
+	master := make([]byte, 32)
+	index := make([]byte, 32)
+	for _, key := range [][]byte{master, index} {
+		_, err := rand.Read(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := fieldcrypt.NewKeyring(master, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// newTestApplication returns an application backed by db, with open registration,
+// no rate limiting and a mailer that can't reach anything.
+func newTestApplication(t *testing.T, db *sql.DB, keys *fieldcrypt.Keyring) *application {
+	t.Helper()
+
+	var cfg config
+	cfg.env = "testing"
+	cfg.baseURL = "http://localhost:4000"
+	cfg.registration.open = true
+	cfg.registration.allowedDomains = []string{"example.com"}
+	cfg.registration.limiter.rps = 1000
+	cfg.registration.limiter.burst = 1000
+	cfg.registration.secret = "registration-secret"
+	cfg.storage.maxAvatarBytes = 1 << 20
+	cfg.notifications.secret = "notifications-secret"
+	cfg.pagination.secret = "cursor-secret"
+
+	data.SetCursorSecret([]byte(cfg.pagination.secret))
+
+	store, err := storage.NewLocalDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		config: cfg,
+		logger: jsonlog.New(io.Discard, jsonlog.LevelOff),
+		models: data.NewModels(db, keys),
+		mailer: mailer.New("localhost", 1, "", "", "Greenlight <no-reply@example.com>"),
+
+		spentChallenges: newChallengeLedger(),
+		storage:         store,
+	}
+}
+
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return &testServer{ts}
+}
+
+// do sends a request, authenticated with token unless it is empty, and returns the
+// response with its body read.
+func (ts *testServer) do(t *testing.T, method, path, token string, body interface{}, headers map[string]string) (*http.Response, []byte) {
+	t.Helper()
+
+	var rb io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rb = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, rb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	rs, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, rs
+}
+
+// insertTestUser adds an activated user with the given role and returns it along
+// with an authentication token.
+func insertTestUser(t *testing.T, app *application, email, role, plaintextPassword string) (*data.UserInfo, string) {
+	t.Helper()
+
+	user := &data.UserInfo{
+		Name:      "Test",
+		Surname:   role,
+		Email:     email,
+		Role:      role,
+		Activated: true,
+	}
+	err := user.PasswordHash.Set(plaintextPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.UserInfos.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.models.Tokens.New(int64(user.ID), time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token.Plaintext
+}
+
+// userVersion reads the version of a user straight from the database.
+func userVersion(t *testing.T, db *sql.DB, id int) int {
+	t.Helper()
+
+	var version int
+	err := db.QueryRow("SELECT version FROM user_info WHERE id = $1", id).Scan(&version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
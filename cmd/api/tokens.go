@@ -52,6 +52,16 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
+	// Only tell the client why the account is blocked once the password is known
+	// to be right.
+	switch {
+	case user.IsSuspended():
+		app.accountSuspendedResponse(w, r)
+		return
+	case user.IsExpired():
+		app.accountExpiredResponse(w, r)
+		return
+	}
 	// Otherwise, if the password is correct, we generate a new token with a 24-hour
 	// expiry time and the scope 'authentication'.
 	token, err := app.models.Tokens.New(int64(user.ID), 24*time.Hour, data.ScopeAuthentication)
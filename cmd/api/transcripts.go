@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/pdf"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordCompletionsHandler records the final grades of an offering's students as
+// completed modules worth the credits in the body, or else the module's ECTS
+// credits. Recording again replaces the earlier records, and students whose
+// grades are incomplete are listed instead.
+func (app *application) recordCompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	offering, err := app.models.Offerings.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Credits *float64 `json:"credits"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Credits == nil {
+		module, err := app.models.ModuleInfos.Get(offering.ModuleID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		input.Credits = &module.ECTSCredits
+	}
+
+	v := validator.New()
+	v.Check(*input.Credits >= 0, "credits", "must not be negative")
+	v.Check(*input.Credits <= 999, "credits", "must not be more than 999")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	completions, incomplete, err := app.models.Transcripts.RecordCompletions(offering.ID, *input.Credits, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoGradeScale):
+			app.errorResponse(w, r, http.StatusConflict, "the offering has no grade scale and no scale is the default")
+		case errors.Is(err, data.ErrInvalidReference):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"completions": completions, "incomplete": incomplete}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// loadTranscript reads the transcript of the user in the URL, writing an error
+// response and returning nil if that fails or the caller may not see it.
+func (app *application) loadTranscript(w http.ResponseWriter, r *http.Request) *data.Transcript {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return nil
+	}
+
+	user, err := app.models.UserInfos.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	transcript, err := app.models.Transcripts.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+	transcript.Name = user.Name
+	transcript.Surname = user.Surname
+
+	return transcript
+}
+
+// transcriptHandler returns a user's transcript as JSON, or as a PDF with
+// ?format=pdf or an Accept header asking for one. The PDF is an official copy and
+// carries a verification code; the JSON is for the student's own use and doesn't,
+// unless one is asked for through issueTranscriptVerificationHandler.
+func (app *application) transcriptHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	format := app.readString(r.URL.Query(), "format", "")
+	if format == "" {
+		format = "json"
+		if strings.Contains(r.Header.Get("Accept"), pdf.ContentType) {
+			format = "pdf"
+		}
+	}
+	v.Check(validator.PermittedValue(format, "json", "pdf"), "format", "must be json or pdf")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	transcript := app.loadTranscript(w, r)
+	if transcript == nil {
+		return
+	}
+
+	if format == "pdf" {
+		// Render once before issuing, so a transcript the PDF can't show gets no
+		// code. Only the transcript decides that; the code and date are plain ASCII.
+		_, err := app.transcriptPDF(transcript, &data.TranscriptVerification{IssuedAt: time.Now()})
+		if err != nil {
+			switch {
+			case errors.Is(err, pdf.ErrMissingGlyph):
+				app.unrenderablePDFResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		verification, err := app.models.Transcripts.Issue(transcript)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		document, err := app.transcriptPDF(transcript, verification)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", pdf.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", fmt.Sprintf("transcript-%d.pdf", transcript.UserID)))
+		w.Write(document)
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"transcript": transcript}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// issueTranscriptVerificationHandler issues a verification code for the user's
+// transcript as it stands, for handing the JSON transcript to somebody who wants
+// to check it. Asking again for an unchanged transcript returns the same code.
+func (app *application) issueTranscriptVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	transcript := app.loadTranscript(w, r)
+	if transcript == nil {
+		return
+	}
+
+	verification, err := app.models.Transcripts.Issue(transcript)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"transcript":       transcript,
+		"verification":     verification,
+		"verification_url": app.verificationURL(verification.Code),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyTranscriptHandler tells anybody holding a verification code whether it
+// was issued and when. Dashes and case in the code don't matter.
+func (app *application) verifyTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	verification, err := app.models.Transcripts.GetVerification(httprouter.ParamsFromContext(r.Context()).ByName("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"verification": verification}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkTranscriptHandler checks a JSON transcript against the one issued with the
+// code and answers whether they match. Nothing about the issued transcript is
+// given away beyond that and when it was issued.
+func (app *application) checkTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	verification, err := app.models.Transcripts.GetVerification(httprouter.ParamsFromContext(r.Context()).ByName("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Transcript *data.Transcript `json:"transcript"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Transcript != nil, "transcript", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := verification.Matches(input.Transcript)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"verification": verification, "match": match}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) verificationURL(code string) string {
+	return app.config.baseURL + "/v1/transcript-verifications/" + data.FormatVerificationCode(code)
+}
+
+// transcriptLayout places transcript lines top to bottom, starting a new page
+// when one is full.
+type transcriptLayout struct {
+	doc    *pdf.Document
+	y      float64
+	pages  int
+	footer string
+}
+
+const (
+	transcriptMargin = 50.0
+	transcriptBottom = 80.0
+)
+
+// keep starts a new page unless height is left on this one.
+func (l *transcriptLayout) keep(height float64) {
+	if l.pages == 0 || l.y-height < transcriptBottom {
+		l.doc.AddPage()
+		l.pages++
+		l.y = pdf.PageHeight - transcriptMargin
+		l.doc.Text(transcriptMargin, transcriptMargin, pdf.Regular, 8, l.footer)
+		l.doc.Text(pdf.PageWidth-transcriptMargin-30, transcriptMargin, pdf.Regular, 8, fmt.Sprintf("Page %d", l.pages))
+	}
+}
+
+// line moves down by height, starting a new page if the line would not fit.
+func (l *transcriptLayout) line(height float64) float64 {
+	l.keep(height)
+	l.y -= height
+	return l.y
+}
+
+// transcriptColumns are the x positions of the module, grade, percent, points and
+// credits columns.
+var transcriptColumns = []float64{transcriptMargin, 330, 390, 450, 510}
+
+func (l *transcriptLayout) row(font pdf.Font, cells ...string) {
+	y := l.line(15)
+	for i, cell := range cells {
+		l.doc.Text(transcriptColumns[i], y, font, 10, cell)
+	}
+}
+
+func formatGPA(gpa *float64) string {
+	if gpa == nil {
+		return "-"
+	}
+	return strconv.FormatFloat(*gpa, 'f', 2, 64)
+}
+
+func formatCredits(credits float64) string {
+	return strconv.FormatFloat(credits, 'f', -1, 64)
+}
+
+// transcriptPDF renders a transcript as a PDF. Module names are cut short so
+// that they don't run into the grade columns. It fails with pdf.ErrMissingGlyph
+// if the transcript has characters the fonts can't show.
+func (app *application) transcriptPDF(t *data.Transcript, verification *data.TranscriptVerification) ([]byte, error) {
+	code := data.FormatVerificationCode(verification.Code)
+	name := strings.TrimSpace(t.Name + " " + t.Surname)
+
+	doc := &pdf.Document{Title: "Transcript of " + name}
+	l := &transcriptLayout{
+		doc:    doc,
+		footer: fmt.Sprintf("Verify this transcript with code %s at %s", code, app.verificationURL(verification.Code)),
+	}
+
+	doc.Text(transcriptMargin, l.line(10), pdf.Bold, 18, "Academic Transcript")
+	l.line(14)
+	doc.Text(transcriptMargin, l.line(15), pdf.Regular, 10, "Student: "+name)
+	doc.Text(transcriptMargin, l.line(15), pdf.Regular, 10, fmt.Sprintf("Student ID: %d", t.UserID))
+	doc.Text(transcriptMargin, l.line(15), pdf.Regular, 10, "Issued: "+verification.IssuedAt.UTC().Format("2 January 2006"))
+	doc.Text(transcriptMargin, l.line(15), pdf.Bold, 10, "Verification code: "+code)
+
+	for _, term := range t.Terms {
+		// A term starts on a new page unless its heading and first module fit.
+		l.keep(10 + 18 + 2*15)
+		l.line(10)
+		doc.Text(transcriptMargin, l.line(18), pdf.Bold, 12, fmt.Sprintf("%s %s", term.Name, term.AcademicYear))
+		doc.Line(transcriptMargin, l.y-4, pdf.PageWidth-transcriptMargin, l.y-4, 0.5)
+		l.row(pdf.Bold, "Module", "Grade", "Percent", "Points", "Credits")
+
+		for _, m := range term.Modules {
+			module := m.ModuleName
+			if len([]rune(module)) > 55 {
+				module = string([]rune(module)[:54]) + "…"
+			}
+			l.row(pdf.Regular, module, m.Letter, strconv.FormatFloat(m.Percent, 'f', 2, 64), strconv.FormatFloat(m.Points, 'f', 2, 64), formatCredits(m.Credits))
+		}
+
+		l.row(pdf.Bold, "Term GPA", "", "", formatGPA(term.GPA), formatCredits(term.Credits))
+	}
+
+	if len(t.Terms) == 0 {
+		l.line(10)
+		doc.Text(transcriptMargin, l.line(15), pdf.Regular, 10, "No modules completed.")
+	}
+
+	l.keep(10 + 4 + 16 + 2*15 + 20)
+	l.line(10)
+	y := l.line(4)
+	doc.Line(transcriptMargin, y, pdf.PageWidth-transcriptMargin, y, 1)
+	doc.Text(transcriptMargin, l.line(16), pdf.Bold, 11, "Cumulative GPA: "+formatGPA(t.GPA))
+	doc.Text(transcriptMargin, l.line(15), pdf.Regular, 10, "Credits attempted: "+formatCredits(t.CreditsAttempted))
+	doc.Text(transcriptMargin, l.line(15), pdf.Regular, 10, "Credits earned: "+formatCredits(t.CreditsEarned))
+	doc.Text(transcriptMargin, l.line(20), pdf.Regular, 8, "The cumulative GPA counts the latest attempt at each module, weighted by its credits.")
+
+	return doc.Encode()
+}
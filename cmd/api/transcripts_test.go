@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// TestTranscriptVerification checks that codes are only issued on request, that
+// looking a code up gives away nothing but the verification, that a presented
+// transcript is matched against the issued one, and that erasure removes codes.
+func TestTranscriptVerification(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+	ts := newTestServer(t, app.routes())
+
+	user, token := insertTestUser(t, app, "user@example.com", data.Registered, "user-pa55word")
+	path := fmt.Sprintf("/v1/users/%d/transcript", user.ID)
+
+	res, body := ts.do(t, http.MethodGet, path, token, nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("transcript: got status %d: %s", res.StatusCode, body)
+	}
+	if strings.Contains(string(body), "verification") {
+		t.Errorf("transcript: a code was issued without being asked for: %s", body)
+	}
+
+	res, body = ts.do(t, http.MethodPost, path+"/verification", token, nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("issue: got status %d: %s", res.StatusCode, body)
+	}
+	var issued struct {
+		Transcript   json.RawMessage              `json:"transcript"`
+		Verification *data.TranscriptVerification `json:"verification"`
+	}
+	decode(t, body, &issued)
+	verifyPath := "/v1/transcript-verifications/" + data.FormatVerificationCode(issued.Verification.Code)
+
+	res, body = ts.do(t, http.MethodGet, verifyPath, "", nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("verify: got status %d: %s", res.StatusCode, body)
+	}
+	var looked map[string]json.RawMessage
+	decode(t, body, &looked)
+	if len(looked) != 1 || looked["verification"] == nil {
+		t.Errorf("verify: got more than the verification: %s", body)
+	}
+	if strings.Contains(string(body), "user@example.com") || strings.Contains(string(body), "terms") {
+		t.Errorf("verify: response shows the transcript: %s", body)
+	}
+
+	var altered map[string]interface{}
+	decode(t, issued.Transcript, &altered)
+	altered["creditsEarned"] = 240
+
+	tests := []struct {
+		name       string
+		transcript interface{}
+		want       bool
+	}{
+		{"issued", issued.Transcript, true},
+		{"altered", altered, false},
+	}
+
+	for _, tt := range tests {
+		res, body = ts.do(t, http.MethodPost, verifyPath, "", map[string]interface{}{"transcript": tt.transcript}, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("check %s: got status %d: %s", tt.name, res.StatusCode, body)
+		}
+		var checked struct {
+			Match bool `json:"match"`
+		}
+		decode(t, body, &checked)
+		if checked.Match != tt.want {
+			t.Errorf("check %s: got match %v, want %v", tt.name, checked.Match, tt.want)
+		}
+	}
+
+	res, body = ts.do(t, http.MethodPost, fmt.Sprintf("/v1/users/%d/erase", user.ID), token, nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("erase: got status %d: %s", res.StatusCode, body)
+	}
+
+	res, body = ts.do(t, http.MethodGet, verifyPath, "", nil, nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("verify after erase: got status %d, want %d: %s", res.StatusCode, http.StatusNotFound, body)
+	}
+}
+
+// TestTranscriptExport checks that the personal data export carries the
+// transcript and the verification codes issued for it.
+func TestTranscriptExport(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+	ts := newTestServer(t, app.routes())
+
+	user, token := insertTestUser(t, app, "user@example.com", data.Registered, "user-pa55word")
+
+	res, body := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/users/%d/transcript/verification", user.ID), token, nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("issue: got status %d: %s", res.StatusCode, body)
+	}
+	var issued struct {
+		Verification *data.TranscriptVerification `json:"verification"`
+	}
+	decode(t, body, &issued)
+
+	files := readExport(t, ts, user.ID, token)
+
+	var transcript data.Transcript
+	decode(t, files["transcript.json"], &transcript)
+	if transcript.UserID != int64(user.ID) {
+		t.Errorf("export: transcript.json is for user %d, want %d", transcript.UserID, user.ID)
+	}
+
+	var verifications []*data.TranscriptVerification
+	decode(t, files["transcript_verifications.json"], &verifications)
+	if len(verifications) != 1 || verifications[0].Code != issued.Verification.Code {
+		t.Errorf("export: got verifications %s, want the code %s", files["transcript_verifications.json"], issued.Verification.Code)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"github.com/shynggys9219/greenlight/internal/data"
 	"github.com/shynggys9219/greenlight/internal/validator"
 	"net/http"
@@ -60,25 +59,13 @@ func (app *application) registerUserInfoHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	subject := "Welcome!"
-	plainBody := "Dear " + user.Name + ",\n\nWelcome to my API!"
-	htmlBody := "<p>Dear " + user.Name + ",</p><p>Welcome to my API!</p>"
+	app.notify(user, notification{
+		category: data.CategoryAccount,
+		subject:  "Welcome!",
+		body:     "Dear " + user.Name + ",\n\nWelcome to my API! Activate your account with the token:\n\n" + token.Plaintext,
+	})
 
-	go func() {
-
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
-			}
-		}()
-
-		err = app.mailer.Send(user.Email, subject, plainBody, htmlBody, token.Plaintext)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
-	}()
-
-	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user.AdminView()}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -135,16 +122,19 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Send the updated user details to the client in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	// Send the updated user details to the client in a JSON response. Only the
+	// owner of the account holds the activation token.
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user.Account()}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// getUserInfoHandler shows a user to any signed-in user, with the fields depending
+// on who is asking (see data.UserInfo.ViewFor).
 func (app *application) getUserInfoHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse user ID from query parameters
-	id, err := app.readIDParam(r)
+	// Parse user ID from the URL, "me" meaning the authenticated user.
+	id, err := app.readUserIDParam(r)
 	if err != nil || id < 1 {
 		app.notFoundResponse(w, r)
 		return
@@ -152,20 +142,33 @@ func (app *application) getUserInfoHandler(w http.ResponseWriter, r *http.Reques
 	// Get user info from the database
 	user, err := app.models.UserInfos.GetByID(id)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", etag(user.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user.ViewFor(app.contextGetUser(r))}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// editUserInfo replaces the name, surname and email of the user given by ?id=. It
+// is rejected with 409 if the user changed since the client read it, going by
+// If-Match or the version field, and if it changes between being read here and
+// written. Without either it is rejected with 428.
 func (app *application) editUserInfo(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
+	id, err := app.readIDQuery(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
 	user, err := app.models.UserInfos.GetByID(id)
@@ -185,11 +188,27 @@ func (app *application) editUserInfo(w http.ResponseWriter, r *http.Request) {
 		Email     string `json:"email"`
 		Role      string `json:"role"`
 		Activated bool   `json:"activated"`
+		Version   *int   `json:"version"`
 	}
 
 	err = app.readJSON(w, r, &input)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != user.Version {
+		app.editConflictResponse(w, r)
 		return
 	}
 
@@ -198,21 +217,148 @@ func (app *application) editUserInfo(w http.ResponseWriter, r *http.Request) {
 	user.Email = input.Email
 
 	err = app.models.UserInfos.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v := validator.New()
+			v.AddError("email", "a user with this email already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(user.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user.ViewFor(app.contextGetUser(r))}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// patchUserInfoHandler applies a JSON merge patch to a user: only the members
+// present in the body are changed and validated. Admins can't change their own role
+// or deactivate themselves, so that they can't lock themselves out.
+func (app *application) patchUserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	user, err := app.models.UserInfos.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		Surname   *string `json:"surname"`
+		Email     *string `json:"email"`
+		Role      *string `json:"role"`
+		Activated *bool   `json:"activated"`
+		Version   *int    `json:"version"`
+	}
+
+	err = app.readMergePatch(w, r, &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedPatch):
+			app.unsupportedPatchResponse(w, r)
+		case errors.Is(err, errPatchTooLarge):
+			app.patchTooLargeResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	expected, ok, err := app.requireExpectedVersion(r, input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errPreconditionRequired):
+			app.preconditionRequiredResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if ok && expected != user.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	self := app.contextGetUser(r).ID == user.ID
+
+	v := validator.New()
+
+	if input.Name != nil {
+		v.Check(*input.Name != "", "name", "must be provided")
+		v.Check(len(*input.Name) <= 500, "name", "must not be more than 500 bytes long")
+		user.Name = *input.Name
+	}
+	if input.Surname != nil {
+		v.Check(len(*input.Surname) <= 500, "surname", "must not be more than 500 bytes long")
+		user.Surname = *input.Surname
+	}
+	if input.Email != nil {
+		data.ValidateEmail(v, *input.Email)
+		user.Email = *input.Email
+	}
+	if input.Role != nil {
+		v.Check(validator.PermittedValue(*input.Role, data.Admin, data.Registered), "role", "must be admin or registered")
+		v.Check(!self || *input.Role == user.Role, "role", "you can't change your own role")
+		user.Role = *input.Role
+	}
+	if input.Activated != nil {
+		v.Check(!self || *input.Activated, "activated", "you can't deactivate your own account")
+		user.Activated = *input.Activated
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.UserInfos.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(user.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user.AdminView()}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// deleteUserInfo deletes the user given by ?id=.
 func (app *application) deleteUserInfo(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
+	id, err := app.readIDQuery(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
 	err = app.models.UserInfos.Delete(id)
@@ -231,10 +377,19 @@ func (app *application) deleteUserInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// userSearchView is a search hit as shown to admins.
+type userSearchView struct {
+	*data.UserAdminView
+	Rank      float64 `json:"rank"`
+	Highlight string  `json:"highlight"`
+}
+
 func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
+		Query   string
 		Name    string
 		Surname string
+		GroupID int
 		data.Filters
 	}
 
@@ -242,28 +397,71 @@ func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request)
 
 	qs := r.URL.Query()
 
+	input.Query = app.readString(qs, "q", "")
 	input.Name = app.readString(qs, "name", "")
 	input.Surname = app.readString(qs, "surname", "")
+	input.GroupID = app.readInt(qs, "group", 0, v)
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
-	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+	// Sealed names and emails would sort in the order of their ciphertext.
+	if !app.models.UserInfos.Encrypted() {
+		input.Filters.SortSafelist = append(input.Filters.SortSafelist, "name", "surname", "email", "-name", "-surname", "-email")
+	}
+
+	// Search results are ranked, so they sort by relevance unless told otherwise.
+	if input.Query != "" {
+		input.Filters.Sort = app.readString(qs, "sort", "-rank")
+		input.Filters.SortSafelist = append(input.Filters.SortSafelist, "rank", "-rank")
+	} else {
+		input.Filters.Sort = app.readString(qs, "sort", "id")
+	}
+
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
 
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	v.Check(len(input.Query) <= 200, "q", "must not be more than 200 bytes long")
+	// Search results are ordered by a computed rank, which can't be used as a keyset.
+	v.Check(input.Query == "" || input.Filters.Cursor == "", "cursor", "can't be combined with q")
+	v.Check(input.GroupID >= 0, "group", "must be a positive group id")
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	users, metadata, err := app.models.UserInfos.GetAll(input.Name, input.Surname, input.Filters)
+	if input.Query != "" {
+		results, metadata, err := app.models.UserInfos.Search(input.Query, input.Name, input.Surname, int64(input.GroupID), input.Filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		views := make([]*userSearchView, len(results))
+		for i, result := range results {
+			views[i] = &userSearchView{UserAdminView: result.AdminView(), Rank: result.Rank, Highlight: result.Highlight}
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"users": views, "metadata": metadata}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	users, metadata, err := app.models.UserInfos.GetAll(input.Name, input.Surname, int64(input.GroupID), input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	views := make([]*data.UserAdminView, len(users))
+	for i, user := range users {
+		views[i] = user.AdminView()
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": views, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 
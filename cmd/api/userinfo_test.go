@@ -0,0 +1,252 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/fieldcrypt"
+)
+
+var bcryptRX = regexp.MustCompile(`\$2[aby]\$\d\d\$`)
+
+// TestUserResponsesOmitPassword runs every handler that returns a user and checks
+// that none of them carries the password, its hash or a password member, with and
+// without encrypted PII.
+func TestUserResponsesOmitPassword(t *testing.T) {
+	tests := []struct {
+		name string
+		keys func(t *testing.T) *fieldcrypt.Keyring
+	}{
+		{"plaintext", func(t *testing.T) *fieldcrypt.Keyring { return nil }},
+		{"encrypted", newTestKeyring},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			app := newTestApplication(t, db, tt.keys(t))
+			ts := newTestServer(t, app.routes())
+
+			const adminPassword = "admin-pa55word"
+			admin, adminToken := insertTestUser(t, app, "admin@example.com", data.Admin, adminPassword)
+			_, otherToken := insertTestUser(t, app, "other@example.com", data.Registered, "other-pa55word")
+
+			// secrets collects every password and stored hash the responses must not show.
+			var secrets [][]byte
+			addUser := func(id int, plaintext string) {
+				secrets = append(secrets, []byte(plaintext), storedHash(t, db, id))
+			}
+			addUser(admin.ID, adminPassword)
+
+			check := func(what string, status int, res *http.Response, body []byte) {
+				t.Helper()
+				if res.StatusCode != status {
+					t.Fatalf("%s: got status %d, want %d: %s", what, res.StatusCode, status, body)
+				}
+				assertNoPasswordMaterial(t, what, body, secrets)
+			}
+
+			// Admin registration.
+			const registeredPassword = "registered-pa55word"
+			res, body := ts.do(t, http.MethodPost, "/v1/users", adminToken, map[string]string{
+				"name":         "Bob",
+				"email":        "bob@example.com",
+				"passwordHash": registeredPassword,
+			}, nil)
+			var registered struct {
+				User struct {
+					ID int `json:"id"`
+				} `json:"user"`
+			}
+			decode(t, body, &registered)
+			addUser(registered.User.ID, registeredPassword)
+			check("register", http.StatusCreated, res, body)
+
+			// Self-registration.
+			const selfPassword = "alice-pa55word"
+			res, body = ts.do(t, http.MethodPost, "/v1/registrations", "", map[string]string{
+				"name":     "Alice",
+				"surname":  "Liddell",
+				"email":    "alice@example.com",
+				"password": selfPassword,
+			}, nil)
+			var self struct {
+				User struct {
+					ID int `json:"id"`
+				} `json:"user"`
+			}
+			decode(t, body, &self)
+			addUser(self.User.ID, selfPassword)
+			check("self-registration", http.StatusAccepted, res, body)
+
+			// Activation.
+			activation, err := app.models.Tokens.New(int64(self.User.ID), time.Hour, data.ScopeActivation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, body = ts.do(t, http.MethodPut, "/v1/users/activated", "", map[string]string{"token": activation.Plaintext}, nil)
+			check("activate", http.StatusOK, res, body)
+
+			selfToken, err := app.models.Tokens.New(int64(self.User.ID), time.Hour, data.ScopeAuthentication)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Get, as an admin, as the user and as somebody else.
+			path := fmt.Sprintf("/v1/users/%d", self.User.ID)
+			for _, viewer := range []struct{ name, token string }{
+				{"admin", adminToken},
+				{"self", selfToken.Plaintext},
+				{"other", otherToken},
+			} {
+				res, body = ts.do(t, http.MethodGet, path, viewer.token, nil, nil)
+				check("get as "+viewer.name, http.StatusOK, res, body)
+			}
+			res, body = ts.do(t, http.MethodGet, "/v1/users/me", selfToken.Plaintext, nil, nil)
+			check("get me", http.StatusOK, res, body)
+
+			// Edit.
+			res, body = ts.do(t, http.MethodPut, fmt.Sprintf("/v1/users/edit?id=%d", self.User.ID), adminToken, map[string]string{
+				"name":    "Alice",
+				"surname": "Pleasance",
+				"email":   "alice@example.com",
+			}, map[string]string{"If-Match": etag(userVersion(t, db, self.User.ID))})
+			check("edit", http.StatusOK, res, body)
+
+			// PATCH.
+			res, body = ts.do(t, http.MethodPatch, path, adminToken, map[string]string{"surname": "Liddell"}, map[string]string{
+				"Content-Type": "application/merge-patch+json",
+				"If-Match":     etag(userVersion(t, db, self.User.ID)),
+			})
+			check("patch", http.StatusOK, res, body)
+
+			// List, plain and filtered.
+			res, body = ts.do(t, http.MethodGet, "/v1/users", adminToken, nil, nil)
+			check("list", http.StatusOK, res, body)
+			res, body = ts.do(t, http.MethodGet, "/v1/users?name=Alice", adminToken, nil, nil)
+			check("list by name", http.StatusOK, res, body)
+
+			// Search.
+			res, body = ts.do(t, http.MethodGet, "/v1/users?q=alice", adminToken, nil, nil)
+			check("search", http.StatusOK, res, body)
+			var found struct {
+				Users []json.RawMessage `json:"users"`
+			}
+			decode(t, body, &found)
+			if len(found.Users) == 0 {
+				t.Fatalf("search: no users found for %q: %s", "alice", body)
+			}
+
+			// Personal-data export, every file in the archive.
+			res, body = ts.do(t, http.MethodGet, path+"/personal-data", selfToken.Plaintext, nil, nil)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("export: got status %d: %s", res.StatusCode, body)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range zr.File {
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var content bytes.Buffer
+				_, err = content.ReadFrom(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.HasSuffix(f.Name, ".json") {
+					assertNoPasswordMaterial(t, "export "+f.Name, content.Bytes(), secrets)
+				}
+			}
+		})
+	}
+}
+
+// storedHash reads the password hash of a user straight from the database.
+func storedHash(t *testing.T, db *sql.DB, id int) []byte {
+	t.Helper()
+
+	var hash []byte
+	err := db.QueryRow("SELECT password_hash FROM user_info WHERE id = $1", id).Scan(&hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func decode(t *testing.T, body []byte, dst interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(body, dst)
+	if err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+}
+
+// assertNoPasswordMaterial fails the test if body holds any of the secrets in raw,
+// base64 or hex form, anything shaped like a bcrypt hash, or a JSON member whose
+// name mentions a password.
+func assertNoPasswordMaterial(t *testing.T, what string, body []byte, secrets [][]byte) {
+	t.Helper()
+
+	for _, secret := range secrets {
+		for _, form := range []string{
+			string(secret),
+			base64.StdEncoding.EncodeToString(secret),
+			hex.EncodeToString(secret),
+		} {
+			if bytes.Contains(body, []byte(form)) {
+				t.Errorf("%s: response contains password material %q", what, form)
+			}
+		}
+	}
+
+	if bcryptRX.Match(body) {
+		t.Errorf("%s: response contains a bcrypt hash: %s", what, body)
+	}
+
+	var v interface{}
+	err := json.Unmarshal(body, &v)
+	if err != nil {
+		t.Fatalf("%s: %v: %s", what, err, body)
+	}
+	if key, ok := passwordKey(v); ok {
+		t.Errorf("%s: response has a %q member: %s", what, key, body)
+	}
+}
+
+// passwordKey looks for an object member named like a password anywhere in v.
+func passwordKey(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if strings.Contains(strings.ToLower(key), "password") {
+				return key, true
+			}
+			if key, ok := passwordKey(value); ok {
+				return key, true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if key, ok := passwordKey(value); ok {
+				return key, true
+			}
+		}
+	}
+	return "", false
+}
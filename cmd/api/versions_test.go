@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/shynggys9219/greenlight/internal/data"
+)
+
+// TestConcurrentUpdates sends two updates based on the same version at the same
+// time and checks that exactly one of them is applied and the other gets 409.
+func TestConcurrentUpdates(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+	ts := newTestServer(t, app.routes())
+
+	admin, adminToken := insertTestUser(t, app, "admin@example.com", data.Admin, "admin-pa55word")
+	user, _ := insertTestUser(t, app, "user@example.com", data.Registered, "user-pa55word")
+
+	authorID := int64(admin.ID)
+	module := &data.ModuleInfo{ModuleName: "Databases", ContactHours: 30, ExamType: "written", AuthorID: &authorID}
+	err := app.models.ModuleInfos.Insert(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group := &data.Group{Name: "SE-2204", AcademicYear: "2024-2025"}
+	err = app.models.Groups.Insert(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version := func(table string, id interface{}) int {
+		var v int
+		err := db.QueryRow(fmt.Sprintf("SELECT version FROM %s WHERE id = $1", table), id).Scan(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	mergePatch := map[string]string{"Content-Type": "application/merge-patch+json"}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		bodies  [2]map[string]interface{}
+		headers map[string]string
+		version func() int
+	}{
+		{
+			name:   "edit user",
+			method: http.MethodPut,
+			path:   fmt.Sprintf("/v1/users/edit?id=%d", user.ID),
+			bodies: [2]map[string]interface{}{
+				{"name": "First", "surname": "Writer", "email": "user@example.com"},
+				{"name": "Second", "surname": "Writer", "email": "user@example.com"},
+			},
+			version: func() int { return version("user_info", user.ID) },
+		},
+		{
+			name:   "patch user",
+			method: http.MethodPatch,
+			path:   fmt.Sprintf("/v1/users/%d", user.ID),
+			bodies: [2]map[string]interface{}{
+				{"surname": "First"},
+				{"surname": "Second"},
+			},
+			headers: mergePatch,
+			version: func() int { return version("user_info", user.ID) },
+		},
+		{
+			name:   "edit module",
+			method: http.MethodPut,
+			path:   fmt.Sprintf("/v1/module-infos/%d", module.ID),
+			bodies: [2]map[string]interface{}{
+				{"moduleName": "Databases I", "contactHours": 30, "examType": "written"},
+				{"moduleName": "Databases II", "contactHours": 30, "examType": "written"},
+			},
+			version: func() int { return version("module_info", module.ID) },
+		},
+		{
+			name:   "patch module",
+			method: http.MethodPatch,
+			path:   fmt.Sprintf("/v1/module-infos/%d", module.ID),
+			bodies: [2]map[string]interface{}{
+				{"examType": "oral"},
+				{"examType": "project"},
+			},
+			headers: mergePatch,
+			version: func() int { return version("module_info", module.ID) },
+		},
+		{
+			name:   "update group",
+			method: http.MethodPut,
+			path:   fmt.Sprintf("/v1/groups/%d", group.ID),
+			bodies: [2]map[string]interface{}{
+				{"description": "first"},
+				{"description": "second"},
+			},
+			version: func() int { return version("groups", group.ID) },
+		},
+	}
+
+	for _, tt := range tests {
+		for _, via := range []string{"If-Match", "version field"} {
+			t.Run(tt.name+" with "+via, func(t *testing.T) {
+				current := tt.version()
+
+				var requests [2]*http.Request
+				for i, body := range tt.bodies {
+					headers := map[string]string{}
+					for key, value := range tt.headers {
+						headers[key] = value
+					}
+
+					b := map[string]interface{}{}
+					for key, value := range body {
+						b[key] = value
+					}
+					if via == "If-Match" {
+						headers["If-Match"] = etag(current)
+					} else {
+						b["version"] = current
+					}
+
+					requests[i] = newTestRequest(t, ts, tt.method, tt.path, adminToken, b, headers)
+				}
+
+				statuses := sendConcurrently(t, ts, requests)
+				sort.Ints(statuses)
+				if statuses[0] != http.StatusOK || statuses[1] != http.StatusConflict {
+					t.Errorf("got statuses %v, want one %d and one %d", statuses, http.StatusOK, http.StatusConflict)
+				}
+
+				if got := tt.version(); got != current+1 {
+					t.Errorf("got version %d after the updates, want %d", got, current+1)
+				}
+			})
+		}
+	}
+}
+
+// TestUpdatesRequireVersion checks that updates without If-Match or a version are
+// refused with 428, and that If-Match: * overrides that.
+func TestUpdatesRequireVersion(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, nil)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := insertTestUser(t, app, "admin@example.com", data.Admin, "admin-pa55word")
+	user, _ := insertTestUser(t, app, "user@example.com", data.Registered, "user-pa55word")
+
+	path := fmt.Sprintf("/v1/users/edit?id=%d", user.ID)
+	body := map[string]string{"name": "Test", "surname": "User", "email": "user@example.com"}
+
+	res, rb := ts.do(t, http.MethodPut, path, adminToken, body, nil)
+	if res.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("without a version: got status %d, want %d: %s", res.StatusCode, http.StatusPreconditionRequired, rb)
+	}
+
+	res, rb = ts.do(t, http.MethodPatch, fmt.Sprintf("/v1/users/%d", user.ID), adminToken, body, map[string]string{"Content-Type": "application/merge-patch+json"})
+	if res.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("patch without a version: got status %d, want %d: %s", res.StatusCode, http.StatusPreconditionRequired, rb)
+	}
+
+	res, rb = ts.do(t, http.MethodPut, path, adminToken, body, map[string]string{"If-Match": "*"})
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("with If-Match: *: got status %d, want %d: %s", res.StatusCode, http.StatusOK, rb)
+	}
+}
+
+func newTestRequest(t *testing.T, ts *testServer, method, path, token string, body interface{}, headers map[string]string) *http.Request {
+	t.Helper()
+
+	js, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(js))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req
+}
+
+// sendConcurrently releases all requests at once and returns their statuses in
+// the order of the requests.
+func sendConcurrently(t *testing.T, ts *testServer, requests [2]*http.Request) []int {
+	t.Helper()
+
+	statuses := make([]int, len(requests))
+	errs := make([]error, len(requests))
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func(i int, req *http.Request) {
+			defer wg.Done()
+			<-start
+
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			res.Body.Close()
+			statuses[i] = res.StatusCode
+		}(i, req)
+	}
+	close(start)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/shynggys9219/greenlight/internal/data"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"net/http"
+)
+
+// readWorkloadFilter reads the ?term= and ?department= filters of the workload
+// reports.
+func (app *application) readWorkloadFilter(r *http.Request, v *validator.Validator) data.WorkloadFilter {
+	qs := r.URL.Query()
+
+	termID := app.readInt(qs, "term", 0, v)
+	departmentID := app.readInt(qs, "department", 0, v)
+
+	v.Check(termID >= 0, "term", "must be a positive term id")
+	v.Check(departmentID >= 0, "department", "must be a positive department id")
+
+	return data.WorkloadFilter{TermID: int64(termID), DepartmentID: int64(departmentID)}
+}
+
+// listWorkloadHandler reports the hours every teacher is due per term and
+// department. ?teacher= limits it to one teacher.
+func (app *application) listWorkloadHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	f := app.readWorkloadFilter(r, v)
+	teacherID := app.readInt(r.URL.Query(), "teacher", 0, v)
+	v.Check(teacherID >= 0, "teacher", "must be a positive user id")
+	f.TeacherID = int64(teacherID)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, err := app.models.Workload.GetAll(f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"workload": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userWorkloadHandler reports a teacher's own hours per term and department.
+func (app *application) userWorkloadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.canAccessUser(r, id) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	f := app.readWorkloadFilter(r, v)
+	f.TeacherID = id
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, err := app.models.Workload.GetAll(f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"workload": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -7,10 +7,12 @@ require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.2
 	golang.org/x/crypto v0.22.0
+	golang.org/x/image v0.18.0
 	golang.org/x/time v0.5.0
 )
 
 require (
+	golang.org/x/text v0.16.0 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 	gopkg.in/mail.v2 v2.3.1 // indirect
 )
@@ -0,0 +1,102 @@
+// Package avatar validates uploaded profile pictures and renders the thumbnails that
+// are served back to clients.
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Sizes lists the square thumbnails generated for every avatar, in pixels.
+var Sizes = map[string]int{
+	"small":  64,
+	"medium": 128,
+	"large":  256,
+}
+
+// maxPixels guards against decompression bombs: a tiny file that claims huge
+// dimensions.
+const maxPixels = 8000 * 8000
+
+var (
+	ErrUnsupportedType = errors.New("avatar must be a JPEG, PNG or WebP image")
+	ErrTooLarge        = errors.New("avatar dimensions are too large")
+)
+
+// Thumbnail is one rendered size of an avatar.
+type Thumbnail struct {
+	Size        string
+	ContentType string
+	Data        []byte
+}
+
+// Process sniffs the real content type of raw, decodes it and renders every entry
+// of Sizes. Re-encoding from decoded pixels drops EXIF and any other metadata.
+// JPEG uploads produce JPEG thumbnails; PNG and WebP produce PNG so that
+// transparency survives.
+func Process(raw []byte) ([]Thumbnail, error) {
+	contentType := http.DetectContentType(raw)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	square := centredSquare(src.Bounds())
+
+	var thumbnails []Thumbnail
+	for name, size := range Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, square, draw.Src, nil)
+
+		var buf bytes.Buffer
+		thumbnail := Thumbnail{Size: name}
+		if contentType == "image/jpeg" {
+			thumbnail.ContentType = "image/jpeg"
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+		} else {
+			thumbnail.ContentType = "image/png"
+			err = png.Encode(&buf, dst)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		thumbnail.Data = buf.Bytes()
+		thumbnails = append(thumbnails, thumbnail)
+	}
+
+	return thumbnails, nil
+}
+
+// centredSquare returns the largest square centred within b, which is the part of
+// the picture kept for the thumbnails.
+func centredSquare(b image.Rectangle) image.Rectangle {
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
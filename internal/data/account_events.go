@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	ActionSuspended     = "suspended"
+	ActionReactivated   = "reactivated"
+	ActionExpiryChanged = "expiry_changed"
+	ActionErased        = "erased"
+)
+
+// AccountEvent records a change to the status of an account and the admin who made
+// it. ActorID is nil once the acting admin has been deleted.
+type AccountEvent struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"userId"`
+	ActorID   *int64     `json:"actorId"`
+	Action    string     `json:"action"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// changeStatus applies an update to the user row and records event in the same
+// transaction. The update must take the user id as its last parameter.
+func (m UserInfoModel) changeStatus(event *AccountEvent, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, append(args, event.UserID)...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	query = `
+INSERT INTO account_events (user_id, actor_id, action, reason, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, event.UserID, event.ActorID, event.Action, event.Reason, event.ExpiresAt).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Suspend blocks the account until it is reactivated. Suspending an already
+// suspended account replaces the reason.
+func (m UserInfoModel) Suspend(userID, actorID int64, reason string) error {
+	query := `
+UPDATE user_info
+SET suspended_at = coalesce(suspended_at, now()), suspension_reason = $1, updated_at = now(), version = version + 1
+WHERE id = $2 AND erased_at IS NULL`
+
+	event := &AccountEvent{UserID: userID, ActorID: &actorID, Action: ActionSuspended, Reason: reason}
+	return m.changeStatus(event, query, reason)
+}
+
+// Reactivate lifts a suspension and sets a new expiry date, which may be nil for an
+// account that never expires.
+func (m UserInfoModel) Reactivate(userID, actorID int64, expiresAt *time.Time) error {
+	query := `
+UPDATE user_info
+SET suspended_at = NULL, suspension_reason = '', expires_at = $1, expiry_warned_at = NULL,
+    updated_at = now(), version = version + 1
+WHERE id = $2 AND erased_at IS NULL`
+
+	event := &AccountEvent{UserID: userID, ActorID: &actorID, Action: ActionReactivated, ExpiresAt: expiresAt}
+	return m.changeStatus(event, query, expiresAt)
+}
+
+// SetExpiry changes when the account expires; nil removes the expiry. The expiry
+// warning is reset so the user is warned again about the new date.
+func (m UserInfoModel) SetExpiry(userID, actorID int64, expiresAt *time.Time) error {
+	query := `
+UPDATE user_info
+SET expires_at = $1, expiry_warned_at = NULL, updated_at = now(), version = version + 1
+WHERE id = $2 AND erased_at IS NULL`
+
+	event := &AccountEvent{UserID: userID, ActorID: &actorID, Action: ActionExpiryChanged, ExpiresAt: expiresAt}
+	return m.changeStatus(event, query, expiresAt)
+}
+
+// GetAccountEvents returns the status history of the account, newest first.
+func (m UserInfoModel) GetAccountEvents(userID int64) ([]*AccountEvent, error) {
+	query := `
+SELECT id, user_id, actor_id, action, reason, expires_at, created_at
+FROM account_events
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AccountEvent{}
+	for rows.Next() {
+		var event AccountEvent
+		err = rows.Scan(
+			&event.ID,
+			&event.UserID,
+			&event.ActorID,
+			&event.Action,
+			&event.Reason,
+			&event.ExpiresAt,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ClaimExpiringAccounts returns the active accounts that expire before the given
+// time and haven't been warned yet, and marks them as warned. Rows being claimed
+// by another instance are skipped, so each user is warned once.
+func (m UserInfoModel) ClaimExpiringAccounts(before time.Time) ([]*UserInfo, error) {
+	query := `
+UPDATE user_info SET expiry_warned_at = now()
+WHERE id IN (
+    SELECT id FROM user_info
+    WHERE expires_at > now() AND expires_at <= $1
+    AND expiry_warned_at IS NULL AND suspended_at IS NULL AND erased_at IS NULL
+    FOR UPDATE SKIP LOCKED)
+RETURNING id, created_at, name, surname, email, role, activated, version, data_key, expires_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := []*UserInfo{}
+	for rows.Next() {
+		var info UserInfo
+		var dataKey sql.NullString
+		err = rows.Scan(
+			&info.ID,
+			&info.CreatedAt,
+			&info.Name,
+			&info.Surname,
+			&info.Email,
+			&info.Role,
+			&info.Activated,
+			&info.Version,
+			&dataKey,
+			&info.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.open(&info, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, &info)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Avatar records that a user has uploaded a picture. The thumbnails themselves live
+// in storage under "avatars/<user id>/<size>".
+type Avatar struct {
+	UserID      int64
+	ContentType string
+	UpdatedAt   time.Time
+}
+
+type AvatarModel struct {
+	DB *sql.DB
+}
+
+// Upsert records a new upload for the user and sets avatar.UpdatedAt.
+func (m AvatarModel) Upsert(avatar *Avatar) error {
+	query := `
+INSERT INTO user_avatars (user_id, content_type, updated_at)
+VALUES ($1, $2, now())
+ON CONFLICT (user_id) DO UPDATE SET content_type = EXCLUDED.content_type, updated_at = now()
+RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, avatar.UserID, avatar.ContentType).Scan(&avatar.UpdatedAt)
+}
+
+func (m AvatarModel) Get(userID int64) (*Avatar, error) {
+	if userID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT user_id, content_type, updated_at FROM user_avatars WHERE user_id = $1`
+
+	var avatar Avatar
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&avatar.UserID, &avatar.ContentType, &avatar.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &avatar, nil
+}
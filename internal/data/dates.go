@@ -0,0 +1,64 @@
+package data
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// ErrInvalidDate is returned when decoding a Date that isn't written as YYYY-MM-DD.
+var ErrInvalidDate = errors.New("invalid date: must be written as YYYY-MM-DD")
+
+// Date is a calendar day, stored in DATE columns and written in JSON as
+// "YYYY-MM-DD". The time is always midnight UTC.
+type Date struct {
+	time.Time
+}
+
+// NewDate returns the day t falls on in its own location.
+func NewDate(t time.Time) Date {
+	return Date{time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}
+}
+
+func (d Date) String() string {
+	return d.Format(dateLayout)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+func (d *Date) UnmarshalJSON(jsonValue []byte) error {
+	s, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidDate
+	}
+
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return ErrInvalidDate
+	}
+
+	d.Time = t
+	return nil
+}
+
+// Scan implements sql.Scanner for DATE columns.
+func (d *Date) Scan(src any) error {
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("can't scan %T into a Date", src)
+	}
+
+	*d = NewDate(t)
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (d Date) Value() (driver.Value, error) {
+	return d.String(), nil
+}
@@ -1,9 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"time"
 )
 
 type DepartmentInfoModel struct {
@@ -11,7 +14,7 @@ type DepartmentInfoModel struct {
 }
 
 func (m DepartmentInfoModel) Insert(info *DepartmentInfo) error {
-	query := "INSERT INTO department_info(department_name, department_director, staff_quantity, module_id) VALUES ($1,$2,$3, $4) RETURNING id "
+	query := "INSERT INTO department_info(department_name, department_director, staff_quantity, module_id) VALUES ($1,$2,$3, $4) RETURNING id, version"
 
 	log.Println("inserted to db")
 
@@ -23,6 +26,7 @@ func (m DepartmentInfoModel) Insert(info *DepartmentInfo) error {
 		&info.ModuleID,
 	).Scan(
 		&info.ID,
+		&info.Version,
 	)
 }
 
@@ -30,7 +34,7 @@ func (m DepartmentInfoModel) Get(id int64) (*DepartmentInfo, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := "SELECT * FROM department_info WHERE id = $1"
+	query := "SELECT id, department_name, department_director, staff_quantity, module_id, version FROM department_info WHERE id = $1"
 
 	var info DepartmentInfo
 
@@ -40,6 +44,7 @@ func (m DepartmentInfoModel) Get(id int64) (*DepartmentInfo, error) {
 		&info.DepartmentDirector,
 		&info.StaffQuantity,
 		&info.ModuleID,
+		&info.Version,
 	)
 	if err != nil {
 		switch {
@@ -52,3 +57,90 @@ func (m DepartmentInfoModel) Get(id int64) (*DepartmentInfo, error) {
 	}
 	return &info, nil
 }
+
+// GetAll lists departments whose name contains name, optionally only those linked to
+// moduleID. Zero values disable a filter.
+func (m DepartmentInfoModel) GetAll(name string, moduleID int64, filters Filters) ([]*DepartmentInfo, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s, id, department_name, department_director, staff_quantity, module_id, version
+FROM department_info
+WHERE (department_name ILIKE '%%' || $1 || '%%' OR $1 = '')
+AND ($2 = 0 OR module_id = $2)
+AND %s
+ORDER BY %s LIMIT $5 OFFSET $6`, filters.cursorColumn(), filters.keyset(3), filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{name, moduleID}
+	args = append(args, filters.keysetArgs()...)
+	args = append(args, filters.limit(), filters.offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	infos := []*DepartmentInfo{}
+	keys := []cursorKey{}
+
+	for rows.Next() {
+		var info DepartmentInfo
+		var key cursorKey
+		err = rows.Scan(
+			&totalRecords,
+			&key.value,
+			&info.ID,
+			&info.DepartmentName,
+			&info.DepartmentDirector,
+			&info.StaffQuantity,
+			&info.ModuleID,
+			&info.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		key.id = int64(info.ID)
+		infos = append(infos, &info)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	infos, metadata := paginate(infos, keys, totalRecords, filters)
+
+	return infos, metadata, nil
+}
+
+// Update saves the department. It returns ErrEditConflict unless info.Version is
+// still the version stored in the database, and ErrInvalidReference for an unknown
+// module.
+func (m DepartmentInfoModel) Update(info *DepartmentInfo) error {
+	query := `
+UPDATE department_info
+SET department_name = $1, department_director = $2, staff_quantity = $3, module_id = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`
+
+	args := []any{info.DepartmentName, info.DepartmentDirector, info.StaffQuantity, info.ModuleID, info.ID, info.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&info.Version)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrInvalidReference
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
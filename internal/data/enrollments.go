@@ -0,0 +1,350 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// The states of an enrollment. Waitlisted users are enrolled in the order they
+// asked as seats become free.
+const (
+	EnrollmentEnrolled   = "enrolled"
+	EnrollmentWaitlisted = "waitlisted"
+	EnrollmentDropped    = "dropped"
+)
+
+var EnrollmentStatuses = []string{EnrollmentEnrolled, EnrollmentWaitlisted, EnrollmentDropped}
+
+var (
+	ErrAlreadyEnrolled = errors.New("already enrolled or waitlisted")
+
+	// ErrEnrollmentClosed is returned when enrolling after the offering's
+	// enrollment deadline.
+	ErrEnrollmentClosed = errors.New("enrollment is closed")
+)
+
+// Enrollment is a user's place in a module offering. Position is the user's place
+// on the waitlist, starting at 1, and is only set while they are waitlisted.
+type Enrollment struct {
+	ID          int64      `json:"id"`
+	OfferingID  int64      `json:"offeringId"`
+	ModuleID    int64      `json:"moduleId"`
+	ModuleName  string     `json:"moduleName"`
+	TermID      int64      `json:"termId"`
+	UserID      int64      `json:"userId"`
+	Status      string     `json:"status"`
+	Position    *int       `json:"position,omitempty"`
+	RequestedAt time.Time  `json:"requestedAt"`
+	EnrolledAt  *time.Time `json:"enrolledAt,omitempty"`
+	DroppedAt   *time.Time `json:"droppedAt,omitempty"`
+}
+
+type EnrollmentModel struct {
+	DB *sql.DB
+}
+
+// lockOffering locks the offering's row until tx ends, so that enrollments in it
+// are made one at a time and its capacity can't be overrun. It returns the
+// offering's capacity and when enrollment closes.
+func lockOffering(ctx context.Context, tx *sql.Tx, offeringID int64) (capacity int, closes time.Time, err error) {
+	query := `
+SELECT capacity, COALESCE(enrollment_deadline, starts_on::timestamptz)
+FROM module_offerings WHERE id = $1 FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, offeringID).Scan(&capacity, &closes)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, time.Time{}, ErrRecordNotFound
+		default:
+			return 0, time.Time{}, err
+		}
+	}
+	return capacity, closes, nil
+}
+
+// Enroll enrolls the user in the offering if it has a free seat and nobody is
+// waiting for one, and puts them on the waitlist otherwise. Users who dropped out
+// before can enroll again, at the back of the queue. It returns
+// ErrAlreadyEnrolled if the user is enrolled or waitlisted already, and
+// ErrEnrollmentClosed once the deadline has passed.
+func (m EnrollmentModel) Enroll(offeringID, userID int64) (*Enrollment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	capacity, closes, err := lockOffering(ctx, tx, offeringID)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(closes) {
+		return nil, ErrEnrollmentClosed
+	}
+
+	var enrolled, waitlisted int
+	query := `
+SELECT count(*) FILTER (WHERE status = 'enrolled'), count(*) FILTER (WHERE status = 'waitlisted')
+FROM enrollments WHERE offering_id = $1`
+
+	err = tx.QueryRowContext(ctx, query, offeringID).Scan(&enrolled, &waitlisted)
+	if err != nil {
+		return nil, err
+	}
+
+	status := EnrollmentEnrolled
+	if enrolled >= capacity || waitlisted > 0 {
+		status = EnrollmentWaitlisted
+	}
+
+	query = `
+INSERT INTO enrollments (offering_id, user_id, status, enrolled_at)
+VALUES ($1, $2, $3, CASE WHEN $4 THEN now() END)
+ON CONFLICT (offering_id, user_id) DO UPDATE
+SET status = EXCLUDED.status, requested_at = now(), enrolled_at = EXCLUDED.enrolled_at, dropped_at = NULL
+WHERE enrollments.status = 'dropped'
+RETURNING id`
+
+	var id int64
+	err = tx.QueryRowContext(ctx, query, offeringID, userID, status, status == EnrollmentEnrolled).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrAlreadyEnrolled
+		case isForeignKeyViolation(err):
+			return nil, ErrInvalidReference
+		default:
+			return nil, err
+		}
+	}
+
+	enrollment, err := getEnrollment(ctx, tx, offeringID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return enrollment, tx.Commit()
+}
+
+// Drop takes the user out of the offering, or off its waitlist, and gives the
+// seats that are free now to the users waiting longest. It returns the users'
+// enrollments that were promoted from the waitlist, or ErrRecordNotFound if the
+// user was neither enrolled nor waitlisted.
+func (m EnrollmentModel) Drop(offeringID, userID int64) ([]*Enrollment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	capacity, _, err := lockOffering(ctx, tx, offeringID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+UPDATE enrollments SET status = 'dropped', dropped_at = now()
+WHERE offering_id = $1 AND user_id = $2 AND status <> 'dropped'`
+
+	result, err := tx.ExecContext(ctx, query, offeringID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	promoted, err := promote(ctx, tx, offeringID, capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	return promoted, tx.Commit()
+}
+
+// Promote gives the offering's free seats to the users waiting longest, for when
+// its capacity was raised, and returns their enrollments.
+func (m EnrollmentModel) Promote(offeringID int64) ([]*Enrollment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	capacity, _, err := lockOffering(ctx, tx, offeringID)
+	if err != nil {
+		return nil, err
+	}
+
+	promoted, err := promote(ctx, tx, offeringID, capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	return promoted, tx.Commit()
+}
+
+// promote enrolls waitlisted users, longest waiting first, until the offering is
+// full. The offering must be locked by tx.
+func promote(ctx context.Context, tx *sql.Tx, offeringID int64, capacity int) ([]*Enrollment, error) {
+	query := `
+UPDATE enrollments SET status = 'enrolled', enrolled_at = now()
+WHERE id IN (
+    SELECT id FROM enrollments
+    WHERE offering_id = $1 AND status = 'waitlisted'
+    ORDER BY requested_at, id
+    LIMIT GREATEST($2 - (SELECT count(*) FROM enrollments WHERE offering_id = $1 AND status = 'enrolled'), 0)
+)
+RETURNING user_id`
+
+	rows, err := tx.QueryContext(ctx, query, offeringID, capacity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int64{}
+	for rows.Next() {
+		var userID int64
+		err = rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	promoted := []*Enrollment{}
+	for _, userID := range userIDs {
+		enrollment, err := getEnrollment(ctx, tx, offeringID, userID)
+		if err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, enrollment)
+	}
+	return promoted, nil
+}
+
+// enrollmentQuery selects enrollments with their offering's module and term, and
+// the position of waitlisted ones. Conditions on the enrollments are added to it.
+const enrollmentQuery = `
+SELECT enrollments.id, enrollments.offering_id, module_offerings.module_id, module_info.module_name,
+       module_offerings.term_id, enrollments.user_id, enrollments.status,
+       CASE WHEN enrollments.status = 'waitlisted' THEN (
+           SELECT count(*) FROM enrollments AS ahead
+           WHERE ahead.offering_id = enrollments.offering_id AND ahead.status = 'waitlisted'
+           AND (ahead.requested_at, ahead.id) <= (enrollments.requested_at, enrollments.id)
+       ) END,
+       enrollments.requested_at, enrollments.enrolled_at, enrollments.dropped_at
+FROM enrollments
+INNER JOIN module_offerings ON module_offerings.id = enrollments.offering_id
+INNER JOIN module_info ON module_info.id = module_offerings.module_id`
+
+func scanEnrollment(row rowScanner, enrollment *Enrollment) error {
+	return row.Scan(
+		&enrollment.ID,
+		&enrollment.OfferingID,
+		&enrollment.ModuleID,
+		&enrollment.ModuleName,
+		&enrollment.TermID,
+		&enrollment.UserID,
+		&enrollment.Status,
+		&enrollment.Position,
+		&enrollment.RequestedAt,
+		&enrollment.EnrolledAt,
+		&enrollment.DroppedAt,
+	)
+}
+
+func getEnrollment(ctx context.Context, db rowQuerier, offeringID, userID int64) (*Enrollment, error) {
+	query := enrollmentQuery + `
+WHERE enrollments.offering_id = $1 AND enrollments.user_id = $2`
+
+	var enrollment Enrollment
+	err := scanEnrollment(db.QueryRowContext(ctx, query, offeringID, userID), &enrollment)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &enrollment, nil
+}
+
+// Get returns the user's enrollment in the offering, whatever its status.
+func (m EnrollmentModel) Get(offeringID, userID int64) (*Enrollment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getEnrollment(ctx, m.DB, offeringID, userID)
+}
+
+// GetForOffering returns the offering's enrollments with the given status, or all
+// of them if status is empty: the enrolled users first, then the waitlist in
+// order, then those who dropped out.
+func (m EnrollmentModel) GetForOffering(offeringID int64, status string) ([]*Enrollment, error) {
+	query := enrollmentQuery + `
+WHERE enrollments.offering_id = $1 AND (enrollments.status = $2 OR $2 = '')
+ORDER BY array_position(ARRAY['enrolled', 'waitlisted', 'dropped']::varchar[], enrollments.status),
+         enrollments.requested_at, enrollments.id`
+
+	return m.getAll(query, offeringID, status)
+}
+
+// GetForUser returns the user's enrollments, newest first.
+func (m EnrollmentModel) GetForUser(userID int64, status string) ([]*Enrollment, error) {
+	query := enrollmentQuery + `
+WHERE enrollments.user_id = $1 AND (enrollments.status = $2 OR $2 = '')
+ORDER BY enrollments.requested_at DESC, enrollments.id DESC`
+
+	return m.getAll(query, userID, status)
+}
+
+func (m EnrollmentModel) getAll(query string, args ...any) ([]*Enrollment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	enrollments := []*Enrollment{}
+	for rows.Next() {
+		var enrollment Enrollment
+		err = scanEnrollment(rows, &enrollment)
+		if err != nil {
+			return nil, err
+		}
+		enrollments = append(enrollments, &enrollment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return enrollments, nil
+}
@@ -0,0 +1,189 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateExamRoom = errors.New("duplicate exam room name")
+
+	// ErrExamRoomInUse is returned when deleting a room that exams are held in.
+	ErrExamRoomInUse = errors.New("exam room has exam sessions")
+)
+
+// ExamRoom is a room exams can be held in. Capacity is the number of candidates
+// it seats under exam conditions.
+type ExamRoom struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Name      string    `json:"name"`
+	Capacity  int       `json:"capacity"`
+	Version   int       `json:"version"`
+}
+
+func ValidateExamRoom(v *validator.Validator, room *ExamRoom) {
+	room.Name = strings.TrimSpace(room.Name)
+	v.Check(room.Name != "", "name", "must be provided")
+	v.Check(len(room.Name) <= 50, "name", "must not be more than 50 bytes long")
+
+	v.Check(room.Capacity > 0, "capacity", "must be greater than zero")
+	v.Check(room.Capacity <= 10000, "capacity", "must not be more than 10000")
+}
+
+type ExamRoomModel struct {
+	DB *sql.DB
+}
+
+func isDuplicateExamRoom(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Constraint == "exam_rooms_name_key"
+}
+
+func (m ExamRoomModel) Insert(room *ExamRoom) error {
+	query := `
+INSERT INTO exam_rooms (name, capacity)
+VALUES ($1, $2)
+RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, room.Name, room.Capacity).Scan(&room.ID, &room.CreatedAt, &room.Version)
+	if err != nil {
+		switch {
+		case isDuplicateExamRoom(err):
+			return ErrDuplicateExamRoom
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ExamRoomModel) Get(id int64) (*ExamRoom, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, capacity, version FROM exam_rooms WHERE id = $1`
+
+	var room ExamRoom
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&room.ID, &room.CreatedAt, &room.Name, &room.Capacity, &room.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &room, nil
+}
+
+// GetAll returns the rooms seating at least minCapacity candidates, smallest
+// first.
+func (m ExamRoomModel) GetAll(minCapacity int) ([]*ExamRoom, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getExamRooms(ctx, m.DB, minCapacity, nil)
+}
+
+// getExamRooms returns the rooms seating at least minCapacity candidates,
+// smallest first. A non-empty ids limits them to those rooms.
+func getExamRooms(ctx context.Context, db rowsQuerier, minCapacity int, ids []int64) ([]*ExamRoom, error) {
+	query := `
+SELECT id, created_at, name, capacity, version
+FROM exam_rooms
+WHERE capacity >= $1 AND (id = ANY($2) OR coalesce(cardinality($2::bigint[]), 0) = 0)
+ORDER BY capacity, lower(name), id`
+
+	rows, err := db.QueryContext(ctx, query, minCapacity, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []*ExamRoom{}
+	for rows.Next() {
+		var room ExamRoom
+		err = rows.Scan(&room.ID, &room.CreatedAt, &room.Name, &room.Capacity, &room.Version)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, &room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+// Update renames or resizes the room unless it changed since it was read, which
+// yields ErrEditConflict. Exams already booked in the room are not moved when it
+// shrinks.
+func (m ExamRoomModel) Update(room *ExamRoom) error {
+	query := `
+UPDATE exam_rooms
+SET name = $1, capacity = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, room.Name, room.Capacity, room.ID, room.Version).Scan(&room.Version)
+	if err != nil {
+		switch {
+		case isDuplicateExamRoom(err):
+			return ErrDuplicateExamRoom
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a room. Rooms that exams are booked in yield ErrExamRoomInUse.
+func (m ExamRoomModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM exam_rooms WHERE id = $1`, id)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrExamRoomInUse
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,479 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"sort"
+	"time"
+)
+
+// ExamGeneration describes an exam timetable to generate: the offerings of a term
+// whose exams are to be placed, the slots they may start in, how long each exam
+// lasts and the rooms and invigilators available. Without OfferingIDs every
+// offering of the term that has no exam yet is placed; without RoomIDs every
+// room may be used. Each exam gets one invigilator per CandidatesPerInvigilator
+// candidates, and at least one.
+type ExamGeneration struct {
+	TermID                   int64       `json:"termId"`
+	OfferingIDs              []int64     `json:"offeringIds"`
+	Slots                    []time.Time `json:"slots"`
+	DurationMinutes          int         `json:"durationMinutes"`
+	RoomIDs                  []int64     `json:"roomIds"`
+	InvigilatorIDs           []int64     `json:"invigilatorIds"`
+	CandidatesPerInvigilator int         `json:"candidatesPerInvigilator"`
+}
+
+func ValidateExamGeneration(v *validator.Validator, g *ExamGeneration) {
+	v.Check(g.TermID > 0, "termId", "must be a positive term id")
+
+	v.Check(len(g.OfferingIDs) <= 500, "offeringIds", "must not contain more than 500 offering ids")
+	v.Check(validator.Unique(g.OfferingIDs), "offeringIds", "must not contain duplicate offering ids")
+	for _, id := range g.OfferingIDs {
+		v.Check(id > 0, "offeringIds", "must contain positive offering ids")
+	}
+
+	v.Check(len(g.Slots) > 0, "slots", "must contain at least one start time")
+	v.Check(len(g.Slots) <= 200, "slots", "must not contain more than 200 start times")
+	v.Check(validator.Unique(g.Slots), "slots", "must not contain duplicate start times")
+
+	v.Check(g.DurationMinutes >= 15, "durationMinutes", "must be at least 15")
+	v.Check(g.DurationMinutes <= 720, "durationMinutes", "must not be more than 720")
+
+	v.Check(len(g.RoomIDs) <= 200, "roomIds", "must not contain more than 200 room ids")
+	v.Check(validator.Unique(g.RoomIDs), "roomIds", "must not contain duplicate room ids")
+	for _, id := range g.RoomIDs {
+		v.Check(id > 0, "roomIds", "must contain positive room ids")
+	}
+
+	validateInvigilators(v, g.InvigilatorIDs)
+	v.Check(len(g.InvigilatorIDs) > 0, "invigilatorIds", "must contain at least one user id")
+
+	v.Check(g.CandidatesPerInvigilator > 0, "candidatesPerInvigilator", "must be greater than zero")
+}
+
+// ExamTimetable is the outcome of an ExamGeneration: the exams it placed and the
+// conflicts it couldn't resolve.
+type ExamTimetable struct {
+	ExamSessions []*ExamSession           `json:"examSessions"`
+	Conflicts    []*ExamTimetableConflict `json:"conflicts"`
+}
+
+// ExamTimetableConflict is an offering whose exam couldn't be placed, with the
+// reasons why, or an exam of the term that already has more candidates than its
+// room seats, in which case ExamSessionID is set.
+type ExamTimetableConflict struct {
+	OfferingID    int64    `json:"offeringId"`
+	ModuleName    string   `json:"moduleName,omitempty"`
+	ExamSessionID *int64   `json:"examSessionId,omitempty"`
+	Candidates    int      `json:"candidates"`
+	Reasons       []string `json:"reasons"`
+}
+
+// Generate places the exams described by g and reports those it couldn't place.
+// Exams already booked are respected: no room, invigilator or candidate is given
+// two exams at once. Unless dryRun is set the placed exams are booked, all of
+// them or none.
+func (m ExamSessionModel) Generate(g *ExamGeneration, dryRun bool) (*ExamTimetable, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// A dry run is rolled back like a failed one.
+	defer tx.Rollback()
+
+	err = lockExamTimetable(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+SELECT EXISTS (SELECT 1 FROM exam_sessions WHERE offering_id = module_offerings.id), %s
+FROM module_offerings
+INNER JOIN module_info ON module_info.id = module_offerings.module_id
+WHERE module_offerings.term_id = $1
+AND (module_offerings.id = ANY($2) OR coalesce(cardinality($2::bigint[]), 0) = 0)
+ORDER BY module_offerings.id`, offeringColumns)
+
+	rows, err := tx.QueryContext(ctx, query, g.TermID, pq.Array(g.OfferingIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timetable := &ExamTimetable{ExamSessions: []*ExamSession{}, Conflicts: []*ExamTimetableConflict{}}
+	offerings := []*Offering{}
+	found := map[int64]bool{}
+
+	for rows.Next() {
+		var offering Offering
+		var hasExam bool
+		err = scanOffering(rows, &offering, &hasExam)
+		if err != nil {
+			return nil, err
+		}
+		found[offering.ID] = true
+
+		switch {
+		case !hasExam:
+			offerings = append(offerings, &offering)
+		case len(g.OfferingIDs) > 0:
+			timetable.Conflicts = append(timetable.Conflicts, &ExamTimetableConflict{
+				OfferingID: offering.ID,
+				ModuleName: offering.ModuleName,
+				Candidates: offering.Enrolled,
+				Reasons:    []string{"the offering already has an exam session"},
+			})
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, id := range g.OfferingIDs {
+		if !found[id] {
+			timetable.Conflicts = append(timetable.Conflicts, &ExamTimetableConflict{
+				OfferingID: id,
+				Reasons:    []string{"no offering of the term has this id"},
+			})
+		}
+	}
+
+	rooms, err := getExamRooms(ctx, tx, 0, g.RoomIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := append([]time.Time(nil), g.Slots...)
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Before(slots[j]) })
+	duration := time.Duration(g.DurationMinutes) * time.Minute
+
+	// Every exam booked in the window of the slots can stand in the way.
+	query = examQuery + `
+INNER JOIN module_info ON module_info.id = module_offerings.module_id
+WHERE exam_sessions.starts_at < $2
+AND exam_sessions.starts_at + exam_sessions.duration_minutes * interval '1 minute' > $1`
+
+	booked, err := queryExams(ctx, tx, query, slots[0], slots[len(slots)-1].Add(duration))
+	if err != nil {
+		return nil, err
+	}
+
+	offeringIDs := []int64{}
+	for _, offering := range offerings {
+		offeringIDs = append(offeringIDs, offering.ID)
+	}
+	for _, exam := range booked {
+		offeringIDs = append(offeringIDs, exam.OfferingID)
+	}
+
+	candidates, err := getCandidates(ctx, tx, offeringIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	planner := &examPlanner{
+		slots:                    slots,
+		duration:                 duration,
+		rooms:                    rooms,
+		invigilatorIDs:           g.InvigilatorIDs,
+		candidatesPerInvigilator: g.CandidatesPerInvigilator,
+		candidates:               candidates,
+	}
+	for _, exam := range booked {
+		planner.book(exam)
+	}
+
+	placed, conflicts := planner.plan(offerings)
+	timetable.Conflicts = append(timetable.Conflicts, conflicts...)
+
+	if !dryRun {
+		for _, exam := range placed {
+			err = insertExam(ctx, tx, exam)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	timetable.ExamSessions = append(timetable.ExamSessions, placed...)
+
+	// Enrollments that came in after an exam was booked may have outgrown its room.
+	query = examQuery + `
+INNER JOIN module_info ON module_info.id = module_offerings.module_id
+WHERE module_offerings.term_id = $1
+ORDER BY exam_sessions.starts_at, exam_sessions.id`
+
+	termExams, err := queryExams(ctx, tx, query, g.TermID)
+	if err != nil {
+		return nil, err
+	}
+	for _, exam := range termExams {
+		if exam.Candidates > exam.RoomCapacity {
+			id := exam.ID
+			timetable.Conflicts = append(timetable.Conflicts, &ExamTimetableConflict{
+				OfferingID:    exam.OfferingID,
+				ModuleName:    exam.ModuleName,
+				ExamSessionID: &id,
+				Candidates:    exam.Candidates,
+				Reasons:       []string{fmt.Sprintf("%d candidates are enrolled but %s seats %d", exam.Candidates, exam.RoomName, exam.RoomCapacity)},
+			})
+		}
+	}
+
+	if !dryRun {
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return timetable, nil
+}
+
+// getCandidates returns the users enrolled in each of the offerings.
+func getCandidates(ctx context.Context, db rowsQuerier, offeringIDs []int64) (map[int64][]int64, error) {
+	query := `
+SELECT offering_id, user_id FROM enrollments
+WHERE offering_id = ANY($1) AND status = 'enrolled'`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(offeringIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := map[int64][]int64{}
+	for rows.Next() {
+		var offeringID, userID int64
+		err = rows.Scan(&offeringID, &userID)
+		if err != nil {
+			return nil, err
+		}
+		candidates[offeringID] = append(candidates[offeringID], userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+// examBooking is the time an exam takes up in a room, for its invigilators and
+// for its candidates.
+type examBooking struct {
+	startsAt, endsAt time.Time
+	roomID           int64
+	invigilatorIDs   []int64
+	offeringID       int64
+}
+
+// examPlanner places exams greedily. The exams hardest to place go first: those
+// sharing candidates with the most other exams, then the largest. Each takes the
+// earliest slot where none of its candidates sits another exam, the smallest free
+// room that seats them all, and the free invigilators with the fewest exams so
+// far. It doesn't backtrack, so it may fail to place exams a search would fit in,
+// but every failure comes with the reason each slot was turned down.
+type examPlanner struct {
+	slots                    []time.Time
+	duration                 time.Duration
+	rooms                    []*ExamRoom
+	invigilatorIDs           []int64
+	candidatesPerInvigilator int
+	candidates               map[int64][]int64
+
+	bookings []examBooking
+	load     map[int64]int
+}
+
+func (p *examPlanner) book(exam *ExamSession) {
+	if p.load == nil {
+		p.load = map[int64]int{}
+	}
+	p.bookings = append(p.bookings, examBooking{
+		startsAt:       exam.StartsAt,
+		endsAt:         exam.StartsAt.Add(time.Duration(exam.DurationMinutes) * time.Minute),
+		roomID:         exam.RoomID,
+		invigilatorIDs: exam.InvigilatorIDs,
+		offeringID:     exam.OfferingID,
+	})
+	for _, id := range exam.InvigilatorIDs {
+		p.load[id]++
+	}
+}
+
+func (p *examPlanner) plan(offerings []*Offering) ([]*ExamSession, []*ExamTimetableConflict) {
+	sets := map[int64]map[int64]bool{}
+	for id, users := range p.candidates {
+		sets[id] = map[int64]bool{}
+		for _, user := range users {
+			sets[id][user] = true
+		}
+	}
+
+	degree := map[int64]int{}
+	for i, a := range offerings {
+		for _, b := range offerings[i+1:] {
+			if sharesCandidates(sets[a.ID], sets[b.ID]) {
+				degree[a.ID]++
+				degree[b.ID]++
+			}
+		}
+	}
+
+	ordered := append([]*Offering(nil), offerings...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if degree[a.ID] != degree[b.ID] {
+			return degree[a.ID] > degree[b.ID]
+		}
+		if a.Enrolled != b.Enrolled {
+			return a.Enrolled > b.Enrolled
+		}
+		return a.ID < b.ID
+	})
+
+	placed := []*ExamSession{}
+	conflicts := []*ExamTimetableConflict{}
+
+	for _, offering := range ordered {
+		exam, reasons := p.place(offering, sets)
+		if exam == nil {
+			conflicts = append(conflicts, &ExamTimetableConflict{
+				OfferingID: offering.ID,
+				ModuleName: offering.ModuleName,
+				Candidates: offering.Enrolled,
+				Reasons:    reasons,
+			})
+			continue
+		}
+		p.book(exam)
+		placed = append(placed, exam)
+	}
+
+	sort.SliceStable(placed, func(i, j int) bool {
+		if !placed[i].StartsAt.Equal(placed[j].StartsAt) {
+			return placed[i].StartsAt.Before(placed[j].StartsAt)
+		}
+		return placed[i].RoomName < placed[j].RoomName
+	})
+	return placed, conflicts
+}
+
+// place finds a slot, room and invigilators for the offering's exam, or returns
+// the reasons it couldn't.
+func (p *examPlanner) place(offering *Offering, sets map[int64]map[int64]bool) (*ExamSession, []string) {
+	seats := len(p.candidates[offering.ID])
+
+	largest := 0
+	for _, room := range p.rooms {
+		if room.Capacity > largest {
+			largest = room.Capacity
+		}
+	}
+	if largest < seats {
+		return nil, []string{fmt.Sprintf("no room seats its %d candidates", seats)}
+	}
+
+	needed := (seats + p.candidatesPerInvigilator - 1) / p.candidatesPerInvigilator
+	if needed < 1 {
+		needed = 1
+	}
+	if needed > len(p.invigilatorIDs) {
+		return nil, []string{fmt.Sprintf("it needs %d invigilators but only %d are available", needed, len(p.invigilatorIDs))}
+	}
+
+	reasons := []string{}
+	for _, slot := range p.slots {
+		end := slot.Add(p.duration)
+		label := slot.Format(time.RFC3339)
+
+		busyRooms := map[int64]bool{}
+		busyInvigilators := map[int64]bool{}
+		clashing := map[int64]bool{}
+		for _, b := range p.bookings {
+			if !b.startsAt.Before(end) || !b.endsAt.After(slot) {
+				continue
+			}
+			busyRooms[b.roomID] = true
+			for _, id := range b.invigilatorIDs {
+				busyInvigilators[id] = true
+			}
+			for user := range sets[b.offeringID] {
+				if sets[offering.ID][user] {
+					clashing[user] = true
+				}
+			}
+		}
+
+		if len(clashing) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s: %d candidates sit another exam", label, len(clashing)))
+			continue
+		}
+
+		var room *ExamRoom
+		for _, r := range p.rooms {
+			if r.Capacity >= seats && !busyRooms[r.ID] {
+				room = r
+				break
+			}
+		}
+		if room == nil {
+			reasons = append(reasons, fmt.Sprintf("%s: every room seating %d is taken", label, seats))
+			continue
+		}
+
+		// Candidates can't invigilate their own exam.
+		free := []int64{}
+		for _, id := range p.invigilatorIDs {
+			if !busyInvigilators[id] && !sets[offering.ID][id] {
+				free = append(free, id)
+			}
+		}
+		if len(free) < needed {
+			reasons = append(reasons, fmt.Sprintf("%s: %d of the %d invigilators needed are free", label, len(free), needed))
+			continue
+		}
+		sort.SliceStable(free, func(i, j int) bool {
+			if p.load[free[i]] != p.load[free[j]] {
+				return p.load[free[i]] < p.load[free[j]]
+			}
+			return free[i] < free[j]
+		})
+		invigilators := append([]int64(nil), free[:needed]...)
+		sort.Slice(invigilators, func(i, j int) bool { return invigilators[i] < invigilators[j] })
+
+		return &ExamSession{
+			OfferingID:      offering.ID,
+			ModuleID:        offering.ModuleID,
+			ModuleName:      offering.ModuleName,
+			ExamType:        offering.ExamType,
+			TermID:          offering.TermID,
+			RoomID:          room.ID,
+			RoomName:        room.Name,
+			RoomCapacity:    room.Capacity,
+			Candidates:      seats,
+			StartsAt:        slot,
+			DurationMinutes: int(p.duration / time.Minute),
+			EndsAt:          end,
+			InvigilatorIDs:  invigilators,
+		}, nil
+	}
+	return nil, reasons
+}
+
+func sharesCandidates(a, b map[int64]bool) bool {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	for user := range a {
+		if b[user] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,454 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateExam = errors.New("module offering already has an exam session")
+
+	// ErrExamRoomTooSmall is returned when an exam's room seats fewer people than
+	// are enrolled in its offering.
+	ErrExamRoomTooSmall = errors.New("exam room seats fewer than the candidates")
+)
+
+// ExamSession is the sitting of a module offering's exam: when it starts, how long
+// it lasts, the room it is held in and the users invigilating it. Candidates are
+// the users enrolled in the offering, counted when the session is read, so they
+// may outgrow the room when enrollments come in after it was booked.
+type ExamSession struct {
+	ID              int64     `json:"id"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+	OfferingID      int64     `json:"offeringId"`
+	ModuleID        int64     `json:"moduleId"`
+	ModuleName      string    `json:"moduleName"`
+	ExamType        string    `json:"examType"`
+	TermID          int64     `json:"termId"`
+	RoomID          int64     `json:"roomId"`
+	RoomName        string    `json:"roomName"`
+	RoomCapacity    int       `json:"roomCapacity"`
+	Candidates      int       `json:"candidates"`
+	StartsAt        time.Time `json:"startsAt"`
+	DurationMinutes int       `json:"durationMinutes"`
+	EndsAt          time.Time `json:"endsAt"`
+	InvigilatorIDs  []int64   `json:"invigilatorIds"`
+	Version         int       `json:"version"`
+}
+
+func ValidateExamSession(v *validator.Validator, exam *ExamSession) {
+	v.Check(!exam.StartsAt.IsZero(), "startsAt", "must be provided")
+
+	v.Check(exam.DurationMinutes >= 15, "durationMinutes", "must be at least 15")
+	v.Check(exam.DurationMinutes <= 720, "durationMinutes", "must not be more than 720")
+
+	v.Check(exam.RoomID > 0, "roomId", "must be a positive room id")
+
+	validateInvigilators(v, exam.InvigilatorIDs)
+	v.Check(len(exam.InvigilatorIDs) > 0, "invigilatorIds", "must contain at least one user id")
+}
+
+func validateInvigilators(v *validator.Validator, ids []int64) {
+	v.Check(len(ids) <= 50, "invigilatorIds", "must not contain more than 50 user ids")
+	v.Check(validator.Unique(ids), "invigilatorIds", "must not contain duplicate user ids")
+	for _, id := range ids {
+		v.Check(id > 0, "invigilatorIds", "must contain positive user ids")
+	}
+}
+
+// ExamConflictError is returned when an exam would be held in the same room, by
+// the same invigilator or for some of the same candidates as other exams at an
+// overlapping time.
+type ExamConflictError struct {
+	Conflicts []*ExamSession
+}
+
+func (e *ExamConflictError) Error() string {
+	ids := make([]string, len(e.Conflicts))
+	for i, conflict := range e.Conflicts {
+		ids[i] = strconv.FormatInt(conflict.ID, 10)
+	}
+	return "exam session conflicts with exam sessions " + strings.Join(ids, ", ")
+}
+
+type ExamSessionModel struct {
+	DB *sql.DB
+}
+
+func isDuplicateExam(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Constraint == "exam_sessions_offering_id_key"
+}
+
+// examQuery selects exam sessions with their offering's module and term, their
+// room and invigilators. A join of module_info, or of publishedModules, and the
+// conditions on the sessions are added to it.
+const examQuery = `
+SELECT exam_sessions.id, exam_sessions.created_at, exam_sessions.updated_at, exam_sessions.offering_id,
+       module_offerings.module_id, module_info.module_name, module_info.exam_type, module_offerings.term_id,
+       exam_sessions.room_id, exam_rooms.name, exam_rooms.capacity,
+       (SELECT count(*) FROM enrollments WHERE offering_id = exam_sessions.offering_id AND status = 'enrolled'),
+       exam_sessions.starts_at, exam_sessions.duration_minutes,
+       ARRAY(SELECT user_id FROM exam_invigilators WHERE exam_id = exam_sessions.id ORDER BY user_id),
+       exam_sessions.version
+FROM exam_sessions
+INNER JOIN module_offerings ON module_offerings.id = exam_sessions.offering_id
+INNER JOIN exam_rooms ON exam_rooms.id = exam_sessions.room_id`
+
+func scanExam(row rowScanner, exam *ExamSession) error {
+	err := row.Scan(
+		&exam.ID,
+		&exam.CreatedAt,
+		&exam.UpdatedAt,
+		&exam.OfferingID,
+		&exam.ModuleID,
+		&exam.ModuleName,
+		&exam.ExamType,
+		&exam.TermID,
+		&exam.RoomID,
+		&exam.RoomName,
+		&exam.RoomCapacity,
+		&exam.Candidates,
+		&exam.StartsAt,
+		&exam.DurationMinutes,
+		pq.Array(&exam.InvigilatorIDs),
+		&exam.Version,
+	)
+	if err != nil {
+		return err
+	}
+	exam.EndsAt = exam.StartsAt.Add(time.Duration(exam.DurationMinutes) * time.Minute)
+	return nil
+}
+
+func queryExams(ctx context.Context, db rowsQuerier, query string, args ...any) ([]*ExamSession, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exams := []*ExamSession{}
+	for rows.Next() {
+		var exam ExamSession
+		err = scanExam(rows, &exam)
+		if err != nil {
+			return nil, err
+		}
+		exams = append(exams, &exam)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return exams, nil
+}
+
+// lockExamTimetable takes a transaction-level advisory lock on the exam
+// timetable. Exams are booked rarely and by few people, so all bookings are
+// simply written one at a time.
+func lockExamTimetable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('exam-timetable'))`)
+	return err
+}
+
+// checkExam locks the exam timetable and checks that the exam's room seats the
+// candidates of its offering, which yields ErrExamRoomTooSmall, and that it
+// doesn't clash with other exams, which yields an *ExamConflictError. Missing
+// offerings and rooms yield ErrInvalidReference.
+func checkExam(ctx context.Context, tx *sql.Tx, exam *ExamSession) error {
+	err := lockExamTimetable(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+SELECT (SELECT count(*) FROM enrollments WHERE offering_id = $1 AND status = 'enrolled'),
+       (SELECT capacity FROM exam_rooms WHERE id = $2)
+FROM module_offerings WHERE id = $1`
+
+	var capacity *int
+	err = tx.QueryRowContext(ctx, query, exam.OfferingID, exam.RoomID).Scan(&exam.Candidates, &capacity)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrInvalidReference
+		default:
+			return err
+		}
+	}
+	if capacity == nil {
+		return ErrInvalidReference
+	}
+	if *capacity < exam.Candidates {
+		return ErrExamRoomTooSmall
+	}
+
+	query = examQuery + `
+INNER JOIN module_info ON module_info.id = module_offerings.module_id
+WHERE exam_sessions.id <> $1
+AND exam_sessions.starts_at < $3
+AND exam_sessions.starts_at + exam_sessions.duration_minutes * interval '1 minute' > $2
+AND (exam_sessions.room_id = $4
+     OR EXISTS (SELECT 1 FROM exam_invigilators WHERE exam_id = exam_sessions.id AND user_id = ANY($5))
+     OR EXISTS (
+         SELECT 1 FROM enrollments a
+         INNER JOIN enrollments b ON b.user_id = a.user_id
+         WHERE a.offering_id = exam_sessions.offering_id AND a.status = 'enrolled'
+         AND b.offering_id = $6 AND b.status = 'enrolled'
+     ))
+ORDER BY exam_sessions.starts_at, exam_sessions.id`
+
+	end := exam.StartsAt.Add(time.Duration(exam.DurationMinutes) * time.Minute)
+	args := []any{exam.ID, exam.StartsAt, end, exam.RoomID, pq.Array(exam.InvigilatorIDs), exam.OfferingID}
+
+	conflicts, err := queryExams(ctx, tx, query, args...)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return &ExamConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+// setInvigilators replaces the invigilators of an exam.
+func setInvigilators(ctx context.Context, tx *sql.Tx, examID int64, userIDs []int64) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM exam_invigilators WHERE exam_id = $1`, examID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+INSERT INTO exam_invigilators (exam_id, user_id)
+SELECT $1, unnest($2::bigint[])`
+
+	_, err = tx.ExecContext(ctx, query, examID, pq.Array(userIDs))
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrInvalidReference
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// insertExam writes the exam and its invigilators and reads it back into exam.
+func insertExam(ctx context.Context, tx *sql.Tx, exam *ExamSession) error {
+	query := `
+INSERT INTO exam_sessions (offering_id, room_id, starts_at, duration_minutes)
+VALUES ($1, $2, $3, $4)
+RETURNING id`
+
+	var id int64
+	err := tx.QueryRowContext(ctx, query, exam.OfferingID, exam.RoomID, exam.StartsAt, exam.DurationMinutes).Scan(&id)
+	if err != nil {
+		switch {
+		case isDuplicateExam(err):
+			return ErrDuplicateExam
+		case isForeignKeyViolation(err):
+			return ErrInvalidReference
+		default:
+			return err
+		}
+	}
+
+	err = setInvigilators(ctx, tx, id, exam.InvigilatorIDs)
+	if err != nil {
+		return err
+	}
+
+	saved, err := getExam(ctx, tx, `exam_sessions.id = $1`, id)
+	if err != nil {
+		return err
+	}
+	*exam = *saved
+	return nil
+}
+
+// Insert books the exam of an offering. It fails with ErrDuplicateExam if the
+// offering already has one, ErrExamRoomTooSmall if the room can't seat its
+// candidates, an *ExamConflictError if it clashes with other exams, and
+// ErrInvalidReference if the offering, room or an invigilator doesn't exist.
+func (m ExamSessionModel) Insert(exam *ExamSession) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkExam(ctx, tx, exam)
+	if err != nil {
+		return err
+	}
+
+	err = insertExam(ctx, tx, exam)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getExam returns the exam picked by the condition on exam_sessions.
+func getExam(ctx context.Context, db rowQuerier, condition string, arg any) (*ExamSession, error) {
+	query := examQuery + `
+INNER JOIN module_info ON module_info.id = module_offerings.module_id
+WHERE ` + condition
+
+	var exam ExamSession
+	err := scanExam(db.QueryRowContext(ctx, query, arg), &exam)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &exam, nil
+}
+
+func (m ExamSessionModel) Get(id int64) (*ExamSession, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getExam(ctx, m.DB, `exam_sessions.id = $1`, id)
+}
+
+// GetForOffering returns the exam of an offering.
+func (m ExamSessionModel) GetForOffering(offeringID int64) (*ExamSession, error) {
+	if offeringID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getExam(ctx, m.DB, `exam_sessions.offering_id = $1`, offeringID)
+}
+
+// Update moves the exam to another time, room or invigilators unless it changed
+// since it was read, which yields ErrEditConflict. It is checked like a new exam.
+func (m ExamSessionModel) Update(exam *ExamSession) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkExam(ctx, tx, exam)
+	if err != nil {
+		return err
+	}
+
+	query := `
+UPDATE exam_sessions
+SET room_id = $1, starts_at = $2, duration_minutes = $3, updated_at = now(), version = version + 1
+WHERE id = $4 AND version = $5
+RETURNING id`
+
+	args := []any{exam.RoomID, exam.StartsAt, exam.DurationMinutes, exam.ID, exam.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&exam.ID)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrInvalidReference
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = setInvigilators(ctx, tx, exam.ID, exam.InvigilatorIDs)
+	if err != nil {
+		return err
+	}
+
+	saved, err := getExam(ctx, tx, `exam_sessions.id = $1`, exam.ID)
+	if err != nil {
+		return err
+	}
+	*exam = *saved
+
+	return tx.Commit()
+}
+
+func (m ExamSessionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM exam_sessions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ExamFilter picks exam sessions. Zero fields don't filter. With PublishedOnly
+// only the exams of modules in effect are included, under the name registered
+// users see.
+type ExamFilter struct {
+	TermID        int64
+	OfferingIDs   []int64
+	RoomID        int64
+	InvigilatorID int64
+	PublishedOnly bool
+}
+
+// GetAll returns the exams picked by the filter in the order they are sat.
+func (m ExamSessionModel) GetAll(f ExamFilter) ([]*ExamSession, error) {
+	source := "module_info"
+	if f.PublishedOnly {
+		source = publishedModules
+	}
+
+	query := examQuery + fmt.Sprintf(`
+INNER JOIN %s ON module_info.id = module_offerings.module_id
+WHERE (module_offerings.term_id = $1 OR $1 = 0)
+AND (exam_sessions.offering_id = ANY($2) OR coalesce(cardinality($2::bigint[]), 0) = 0)
+AND (exam_sessions.room_id = $3 OR $3 = 0)
+AND ($4 = 0 OR EXISTS (SELECT 1 FROM exam_invigilators WHERE exam_id = exam_sessions.id AND user_id = $4))
+ORDER BY exam_sessions.starts_at, exam_rooms.name, exam_sessions.id`, source)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return queryExams(ctx, m.DB, query, f.TermID, pq.Array(f.OfferingIDs), f.RoomID, f.InvigilatorID)
+}
@@ -1,16 +1,28 @@
 package data
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/shynggys9219/greenlight/internal/validator"
 	"math"
 	"strings"
 )
 
+// Filters describes the page of a list to return. Without a Cursor the page is
+// picked by Page and PageSize (offset pagination); with one, PageSize rows are read
+// after or before the row the cursor points at (keyset pagination), which stays
+// fast and stable while rows are added or removed.
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       string
 }
 
 func calculateMetadata(totalRecords int, page, pageSize int) Metadata {
@@ -27,18 +39,28 @@ func calculateMetadata(totalRecords int, page, pageSize int) Metadata {
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
+// In cursor mode one row more than the page size is read, to find out whether
+// there is another page.
 func (f Filters) limit() int {
+	if f.Cursor != "" {
+		return f.PageSize + 1
+	}
 	return f.PageSize
 }
 
 func (f Filters) offset() int {
+	if f.Cursor != "" {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 
 }
@@ -61,10 +83,192 @@ func (f Filters) sortDirection() string {
 
 func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be max of 10 million")
+	v.Check(f.Page <= 10_000, "page", "must be a max of 10000, use cursor pagination to go further")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a max of 100")
 
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
 
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		v.Check(err == nil, "cursor", "invalid cursor")
+		if err == nil {
+			v.Check(c.Sort == f.Sort, "cursor", "was issued for a different sort order")
+		}
+	}
+}
+
+// cursorSecret signs the cursors handed out to clients, so the values in them can
+// be used in queries without further checks.
+var cursorSecret []byte
+
+// SetCursorSecret sets the key used to sign pagination cursors. It must be called
+// before any list is served.
+func SetCursorSecret(secret []byte) {
+	cursorSecret = secret
+}
+
+// cursor points at a row of a sorted list by the row's sort value (as text, nil for
+// NULL) and id. Backward cursors page towards the start of the list.
+type cursor struct {
+	Sort     string  `json:"s"`
+	Value    *string `json:"v"`
+	ID       int64   `json:"i"`
+	Backward bool    `json:"b,omitempty"`
+}
+
+func signCursor(payload string) string {
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	payload := base64.RawURLEncoding.EncodeToString(js)
+	return payload + "." + signCursor(payload)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	payload, signature, found := strings.Cut(s, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(signCursor(payload))) {
+		return nil, errors.New("invalid cursor signature")
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var c cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// cursor returns the decoded cursor, or nil in offset mode. ValidateFilters has
+// already rejected cursors that don't decode.
+func (f Filters) cursor() *cursor {
+	if f.Cursor == "" {
+		return nil
+	}
+	c, _ := decodeCursor(f.Cursor)
+	return c
+}
+
+// descending reports the direction rows are read in, which is reversed when paging
+// backwards.
+func (f Filters) descending() bool {
+	desc := f.sortDirection() == "DESC"
+	if c := f.cursor(); c != nil && c.Backward {
+		return !desc
+	}
+	return desc
+}
+
+// orderBy returns the ORDER BY clause for the list. The id breaks ties in the same
+// direction as the sort column, so that (column, id) is a usable keyset.
+func (f Filters) orderBy() string {
+	if f.descending() {
+		return fmt.Sprintf("%s DESC, id DESC", f.sortColumn())
+	}
+	return fmt.Sprintf("%s ASC, id ASC", f.sortColumn())
+}
+
+// cursorColumn selects the sort value of a row in the form stored in cursors.
+func (f Filters) cursorColumn() string {
+	return f.sortColumn() + "::text"
+}
+
+// keyset returns the WHERE condition that selects the rows after the cursor, using
+// the parameters $n and $n+1 filled from keysetArgs. In offset mode it is always
+// true. NULL sort values come last in ascending order and first in descending
+// order, as in PostgreSQL's ORDER BY.
+func (f Filters) keyset(n int) string {
+	c := f.cursor()
+	if c == nil {
+		return fmt.Sprintf("($%d::text IS NULL AND $%d::bigint IS NULL)", n, n+1)
+	}
+
+	col := f.sortColumn()
+	switch {
+	case c.Value == nil && f.descending():
+		return fmt.Sprintf("($%d::text IS NULL AND ((%s IS NULL AND id < $%d) OR %s IS NOT NULL))", n, col, n+1, col)
+	case c.Value == nil:
+		return fmt.Sprintf("($%d::text IS NULL AND %s IS NULL AND id > $%d)", n, col, n+1)
+	case f.descending():
+		return fmt.Sprintf("(%s, id) < ($%d, $%d)", col, n, n+1)
+	default:
+		return fmt.Sprintf("((%s, id) > ($%d, $%d) OR %s IS NULL)", col, n, n+1, col)
+	}
+}
+
+func (f Filters) keysetArgs() []any {
+	c := f.cursor()
+	if c == nil {
+		return []any{nil, nil}
+	}
+	if c.Value == nil {
+		return []any{nil, c.ID}
+	}
+	return []any{*c.Value, c.ID}
+}
+
+// cursorKey is the sort value and id of a scanned row.
+type cursorKey struct {
+	value sql.NullString
+	id    int64
+}
+
+func (f Filters) cursorAt(key cursorKey, backward bool) string {
+	c := cursor{Sort: f.Sort, ID: key.id, Backward: backward}
+	if key.value.Valid {
+		c.Value = &key.value.String
+	}
+	return c.encode()
+}
+
+// paginate trims the look-ahead row read in cursor mode, restores the list order
+// after paging backwards and builds the page metadata. keys holds the cursorKey of
+// every row in items, in the order they were read.
+func paginate[T any](items []T, keys []cursorKey, totalRecords int, f Filters) ([]T, Metadata) {
+	c := f.cursor()
+
+	if c == nil {
+		metadata := calculateMetadata(totalRecords, f.Page, f.PageSize)
+		if len(keys) > 0 {
+			if f.offset()+len(keys) < totalRecords {
+				metadata.NextCursor = f.cursorAt(keys[len(keys)-1], false)
+			}
+			if f.Page > 1 {
+				metadata.PrevCursor = f.cursorAt(keys[0], true)
+			}
+		}
+		return items, metadata
+	}
+
+	more := len(items) > f.PageSize
+	if more {
+		items, keys = items[:f.PageSize], keys[:f.PageSize]
+	}
+
+	if c.Backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	metadata := Metadata{PageSize: f.PageSize}
+	if len(keys) > 0 {
+		if more || c.Backward {
+			metadata.NextCursor = f.cursorAt(keys[len(keys)-1], false)
+		}
+		if more || !c.Backward {
+			metadata.PrevCursor = f.cursorAt(keys[0], true)
+		}
+	}
+	return items, metadata
 }
@@ -0,0 +1,279 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateGradeScale = errors.New("duplicate grade scale name")
+
+	// ErrDuplicateDefaultScale is returned when a second scale is made the default.
+	ErrDuplicateDefaultScale = errors.New("another grade scale is the default")
+)
+
+// GradeBand maps the final percentages from MinPercent up to the next band to a
+// letter and the grade points it is worth.
+type GradeBand struct {
+	MinPercent float64 `json:"minPercent"`
+	Letter     string  `json:"letter"`
+	Points     float64 `json:"points"`
+}
+
+// GradeScale turns final percentages into letters and points. Its bands are kept
+// from the highest MinPercent down, and the lowest starts at 0 so that every
+// percentage has a grade. The default scale grades offerings that have none.
+type GradeScale struct {
+	ID        int64       `json:"id"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Name      string      `json:"name"`
+	IsDefault bool        `json:"isDefault"`
+	Bands     []GradeBand `json:"bands"`
+	Version   int         `json:"version"`
+}
+
+// Grade returns the band the percentage falls in.
+func (s *GradeScale) Grade(percent float64) GradeBand {
+	for _, band := range s.Bands {
+		if percent >= band.MinPercent {
+			return band
+		}
+	}
+	return s.Bands[len(s.Bands)-1]
+}
+
+func ValidateGradeScale(v *validator.Validator, scale *GradeScale) {
+	scale.Name = strings.TrimSpace(scale.Name)
+	v.Check(scale.Name != "", "name", "must be provided")
+	v.Check(len(scale.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(scale.Bands) > 0, "bands", "must contain at least one band")
+	v.Check(len(scale.Bands) <= 50, "bands", "must not contain more than 50 bands")
+
+	sort.SliceStable(scale.Bands, func(i, j int) bool { return scale.Bands[i].MinPercent > scale.Bands[j].MinPercent })
+
+	percents := make([]float64, len(scale.Bands))
+	for i := range scale.Bands {
+		band := &scale.Bands[i]
+		band.Letter = strings.TrimSpace(band.Letter)
+		band.MinPercent = math.Round(band.MinPercent*100) / 100
+		percents[i] = band.MinPercent
+
+		v.Check(band.Letter != "", "bands", "must all have a letter")
+		v.Check(len(band.Letter) <= 5, "bands", "must have letters of at most 5 bytes")
+		v.Check(band.MinPercent >= 0 && band.MinPercent <= 100, "bands", "must have a minPercent between 0 and 100")
+		v.Check(band.Points >= 0 && band.Points < 100, "bands", "must have points between 0 and 99.99")
+	}
+	v.Check(validator.Unique(percents), "bands", "must not share a minPercent")
+	if len(scale.Bands) > 0 {
+		v.Check(scale.Bands[len(scale.Bands)-1].MinPercent == 0, "bands", "must contain a band starting at 0")
+	}
+}
+
+type GradeScaleModel struct {
+	DB *sql.DB
+}
+
+func isDuplicateGradeScale(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Constraint == "grade_scales_name_key"
+}
+
+func isDuplicateDefaultScale(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Constraint == "grade_scales_is_default_key"
+}
+
+// setBands replaces the bands of a scale.
+func setBands(ctx context.Context, tx *sql.Tx, scale *GradeScale) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM grade_scale_bands WHERE scale_id = $1`, scale.ID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+INSERT INTO grade_scale_bands (scale_id, min_percent, letter, points)
+VALUES ($1, $2, $3, $4)`
+
+	for _, band := range scale.Bands {
+		_, err = tx.ExecContext(ctx, query, scale.ID, band.MinPercent, band.Letter, band.Points)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeGradeScaleError(err error) error {
+	switch {
+	case isDuplicateGradeScale(err):
+		return ErrDuplicateGradeScale
+	case isDuplicateDefaultScale(err):
+		return ErrDuplicateDefaultScale
+	default:
+		return err
+	}
+}
+
+func (m GradeScaleModel) Insert(scale *GradeScale) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+INSERT INTO grade_scales (name, is_default)
+VALUES ($1, $2)
+RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, scale.Name, scale.IsDefault).Scan(&scale.ID, &scale.CreatedAt, &scale.Version)
+	if err != nil {
+		return writeGradeScaleError(err)
+	}
+
+	err = setBands(ctx, tx, scale)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getGradeScales returns the scales picked by the condition on grade_scales, with
+// their bands, by name.
+func getGradeScales(ctx context.Context, db rowsQuerier, condition string, args ...any) ([]*GradeScale, error) {
+	query := `
+SELECT grade_scales.id, grade_scales.created_at, grade_scales.name, grade_scales.is_default, grade_scales.version,
+       grade_scale_bands.min_percent, grade_scale_bands.letter, grade_scale_bands.points
+FROM grade_scales
+INNER JOIN grade_scale_bands ON grade_scale_bands.scale_id = grade_scales.id
+WHERE ` + condition + `
+ORDER BY lower(grade_scales.name), grade_scales.id, grade_scale_bands.min_percent DESC`
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scales := []*GradeScale{}
+	for rows.Next() {
+		var scale GradeScale
+		var band GradeBand
+		err = rows.Scan(&scale.ID, &scale.CreatedAt, &scale.Name, &scale.IsDefault, &scale.Version, &band.MinPercent, &band.Letter, &band.Points)
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(scales); n > 0 && scales[n-1].ID == scale.ID {
+			scales[n-1].Bands = append(scales[n-1].Bands, band)
+			continue
+		}
+		scale.Bands = []GradeBand{band}
+		scales = append(scales, &scale)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return scales, nil
+}
+
+func (m GradeScaleModel) Get(id int64) (*GradeScale, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scales, err := getGradeScales(ctx, m.DB, `grade_scales.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(scales) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return scales[0], nil
+}
+
+func (m GradeScaleModel) GetAll() ([]*GradeScale, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getGradeScales(ctx, m.DB, `true`)
+}
+
+// Update saves the scale and its bands unless it changed since it was read, which
+// yields ErrEditConflict. Final grades are computed when they are read, so they
+// follow the new bands.
+func (m GradeScaleModel) Update(scale *GradeScale) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+UPDATE grade_scales
+SET name = $1, is_default = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, scale.Name, scale.IsDefault, scale.ID, scale.Version).Scan(&scale.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return writeGradeScaleError(err)
+		}
+	}
+
+	err = setBands(ctx, tx, scale)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a scale. Its offerings fall back to the default scale.
+func (m GradeScaleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM grade_scales WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,563 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateComponent = errors.New("duplicate assessment component name for module")
+
+	// ErrComponentInUse is returned when deleting a component that has scores.
+	ErrComponentInUse = errors.New("assessment component has scores")
+
+	// ErrGradesLocked is returned when changing the grades of an offering after
+	// they locked without an override.
+	ErrGradesLocked = errors.New("grades are locked")
+)
+
+// AssessmentComponent is a part of a module's assessment, such as its coursework
+// or exam. Scores run from 0 to MaxScore, and each component counts towards the
+// final grade in proportion to its Weight.
+type AssessmentComponent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	ModuleID  int64     `json:"moduleId"`
+	Name      string    `json:"name"`
+	Weight    float64   `json:"weight"`
+	MaxScore  float64   `json:"maxScore"`
+	Version   int       `json:"version"`
+}
+
+func ValidateAssessmentComponent(v *validator.Validator, component *AssessmentComponent) {
+	component.Name = strings.TrimSpace(component.Name)
+	v.Check(component.Name != "", "name", "must be provided")
+	v.Check(len(component.Name) <= 100, "name", "must not be more than 100 bytes long")
+	// The name heads its column in CSV score uploads.
+	v.Check(!strings.EqualFold(component.Name, "user_id"), "name", "must not be user_id")
+
+	v.Check(component.Weight > 0, "weight", "must be greater than zero")
+	v.Check(component.Weight <= 100, "weight", "must not be more than 100")
+
+	v.Check(component.MaxScore > 0, "maxScore", "must be greater than zero")
+	v.Check(component.MaxScore <= 99999, "maxScore", "must not be more than 99999")
+}
+
+type AssessmentModel struct {
+	DB *sql.DB
+}
+
+func isDuplicateComponent(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Constraint == "assessment_components_module_id_name_key"
+}
+
+func (m AssessmentModel) Insert(component *AssessmentComponent) error {
+	query := `
+INSERT INTO assessment_components (module_id, name, weight, max_score)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{component.ModuleID, component.Name, component.Weight, component.MaxScore}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&component.ID, &component.CreatedAt, &component.UpdatedAt, &component.Version)
+	if err != nil {
+		switch {
+		case isDuplicateComponent(err):
+			return ErrDuplicateComponent
+		case isForeignKeyViolation(err):
+			return ErrInvalidReference
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// getComponents returns the components of the modules in the order they were
+// added.
+func getComponents(ctx context.Context, db rowsQuerier, moduleIDs []int64) ([]*AssessmentComponent, error) {
+	query := `
+SELECT id, created_at, updated_at, module_id, name, weight, max_score, version
+FROM assessment_components
+WHERE module_id = ANY($1)
+ORDER BY module_id, id`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(moduleIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	components := []*AssessmentComponent{}
+	for rows.Next() {
+		var c AssessmentComponent
+		err = rows.Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt, &c.ModuleID, &c.Name, &c.Weight, &c.MaxScore, &c.Version)
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, &c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return components, nil
+}
+
+func (m AssessmentModel) Get(id int64) (*AssessmentComponent, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, updated_at, module_id, name, weight, max_score, version
+FROM assessment_components WHERE id = $1`
+
+	var c AssessmentComponent
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt, &c.ModuleID, &c.Name, &c.Weight, &c.MaxScore, &c.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// GetForModule returns the components of a module in the order they were added.
+func (m AssessmentModel) GetForModule(moduleID int64) ([]*AssessmentComponent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getComponents(ctx, m.DB, []int64{moduleID})
+}
+
+// Update saves the component unless it changed since it was read, which yields
+// ErrEditConflict.
+func (m AssessmentModel) Update(component *AssessmentComponent) error {
+	query := `
+UPDATE assessment_components
+SET name = $1, weight = $2, max_score = $3, updated_at = now(), version = version + 1
+WHERE id = $4 AND version = $5
+RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{component.Name, component.Weight, component.MaxScore, component.ID, component.Version}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&component.UpdatedAt, &component.Version)
+	if err != nil {
+		switch {
+		case isDuplicateComponent(err):
+			return ErrDuplicateComponent
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a component. Components that have been scored yield
+// ErrComponentInUse.
+func (m AssessmentModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM assessment_components WHERE id = $1`, id)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrComponentInUse
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ScoreEntry is the score a user got for a component.
+type ScoreEntry struct {
+	UserID      int64   `json:"userId"`
+	ComponentID int64   `json:"componentId"`
+	Score       float64 `json:"score"`
+}
+
+// ComponentScore is a user's score for a component, nil until it is entered.
+type ComponentScore struct {
+	ComponentID int64    `json:"componentId"`
+	Name        string   `json:"name"`
+	Weight      float64  `json:"weight"`
+	MaxScore    float64  `json:"maxScore"`
+	Score       *float64 `json:"score"`
+}
+
+// GradeReport is a user's grade in an offering. Once every component is scored
+// the grade is Complete and Percent is the weighted average of the scores, which
+// the offering's grade scale turns into a Letter and Points.
+type GradeReport struct {
+	OfferingID int64            `json:"offeringId"`
+	ModuleID   int64            `json:"moduleId"`
+	ModuleName string           `json:"moduleName"`
+	TermID     int64            `json:"termId"`
+	UserID     int64            `json:"userId"`
+	Components []ComponentScore `json:"components"`
+	Complete   bool             `json:"complete"`
+	Percent    *float64         `json:"percent"`
+	Letter     string           `json:"letter,omitempty"`
+	Points     *float64         `json:"points"`
+	Locked     bool             `json:"locked"`
+
+	scaleID *int64
+}
+
+// computeFinalGrade fills in the final grade of the report from its component
+// scores. scale may be nil, in which case only the percentage is given.
+func computeFinalGrade(report *GradeReport, scale *GradeScale) {
+	if len(report.Components) == 0 {
+		return
+	}
+
+	var weighted, weights float64
+	for _, c := range report.Components {
+		if c.Score == nil {
+			return
+		}
+		weighted += *c.Score / c.MaxScore * c.Weight
+		weights += c.Weight
+	}
+
+	percent := math.Round(weighted/weights*100*100) / 100
+	report.Complete = true
+	report.Percent = &percent
+
+	if scale != nil {
+		band := scale.Grade(percent)
+		report.Letter = band.Letter
+		report.Points = &band.Points
+	}
+}
+
+type GradeModel struct {
+	DB *sql.DB
+}
+
+// SaveScores enters the scores of an offering's students, replacing any they
+// had. Once the offering's grades are locked they can only be changed with an
+// override reason, which is recorded with the old and new score of each change
+// and enteredBy as the admin who made it; without one ErrGradesLocked is
+// returned. Scores for missing offerings yield ErrRecordNotFound and for missing
+// users or components ErrInvalidReference.
+func (m GradeModel) SaveScores(offeringID int64, entries []ScoreEntry, enteredBy int64, overrideReason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The offering is locked so that its lock time can't move during the save.
+	var lockAt *time.Time
+	err = tx.QueryRowContext(ctx, `SELECT grades_lock_at FROM module_offerings WHERE id = $1 FOR SHARE`, offeringID).Scan(&lockAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	locked := lockAt != nil && !time.Now().Before(*lockAt)
+	if locked && overrideReason == "" {
+		return ErrGradesLocked
+	}
+
+	for _, entry := range entries {
+		if locked {
+			query := `
+INSERT INTO grade_overrides (offering_id, user_id, component_id, old_score, new_score, admin_id, reason)
+SELECT $1, $2, $3, (SELECT score FROM assessment_scores WHERE offering_id = $1 AND user_id = $2 AND component_id = $3), $4, $5, $6`
+
+			_, err = tx.ExecContext(ctx, query, offeringID, entry.UserID, entry.ComponentID, entry.Score, enteredBy, overrideReason)
+			if err != nil {
+				switch {
+				case isForeignKeyViolation(err):
+					return ErrInvalidReference
+				default:
+					return err
+				}
+			}
+		}
+
+		query := `
+INSERT INTO assessment_scores (component_id, offering_id, user_id, score, entered_by)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (offering_id, user_id, component_id)
+DO UPDATE SET score = EXCLUDED.score, entered_by = EXCLUDED.entered_by, entered_at = now()`
+
+		_, err = tx.ExecContext(ctx, query, entry.ComponentID, offeringID, entry.UserID, entry.Score, enteredBy)
+		if err != nil {
+			switch {
+			case isForeignKeyViolation(err):
+				return ErrInvalidReference
+			default:
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetReports returns the grades of the students enrolled in an offering, or of the
+// offerings a user is enrolled in, where those ids are not zero.
+func (m GradeModel) GetReports(offeringID, userID int64) ([]*GradeReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+SELECT enrollments.offering_id, enrollments.user_id, module_offerings.module_id, module_info.module_name,
+       module_offerings.term_id, module_offerings.grade_scale_id, module_offerings.grades_lock_at
+FROM enrollments
+INNER JOIN module_offerings ON module_offerings.id = enrollments.offering_id
+INNER JOIN module_info ON module_info.id = module_offerings.module_id
+WHERE enrollments.status = 'enrolled'
+AND (enrollments.offering_id = $1 OR $1 = 0)
+AND (enrollments.user_id = $2 OR $2 = 0)
+ORDER BY module_offerings.starts_on DESC, module_info.module_name, enrollments.offering_id, enrollments.user_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, offeringID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	reports := []*GradeReport{}
+	moduleIDs := []int64{}
+	offeringIDs := []int64{}
+
+	for rows.Next() {
+		var report GradeReport
+		var lockAt *time.Time
+		err = rows.Scan(&report.OfferingID, &report.UserID, &report.ModuleID, &report.ModuleName, &report.TermID, &report.scaleID, &lockAt)
+		if err != nil {
+			return nil, err
+		}
+		report.Locked = lockAt != nil && !now.Before(*lockAt)
+		reports = append(reports, &report)
+		moduleIDs = append(moduleIDs, report.ModuleID)
+		offeringIDs = append(offeringIDs, report.OfferingID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(reports) == 0 {
+		return reports, nil
+	}
+
+	components, err := getComponents(ctx, m.DB, moduleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	type scoreKey struct{ offeringID, userID, componentID int64 }
+	scores := map[scoreKey]float64{}
+
+	query = `
+SELECT offering_id, user_id, component_id, score FROM assessment_scores
+WHERE offering_id = ANY($1) AND (user_id = $2 OR $2 = 0)`
+
+	rows, err = m.DB.QueryContext(ctx, query, pq.Array(offeringIDs), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key scoreKey
+		var score float64
+		err = rows.Scan(&key.offeringID, &key.userID, &key.componentID, &score)
+		if err != nil {
+			return nil, err
+		}
+		scores[key] = score
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	scales, err := getGradeScales(ctx, m.DB, `true`)
+	if err != nil {
+		return nil, err
+	}
+
+	var defaultScale *GradeScale
+	byID := map[int64]*GradeScale{}
+	for _, scale := range scales {
+		byID[scale.ID] = scale
+		if scale.IsDefault {
+			defaultScale = scale
+		}
+	}
+
+	for _, report := range reports {
+		report.Components = []ComponentScore{}
+		for _, c := range components {
+			if c.ModuleID != report.ModuleID {
+				continue
+			}
+			cs := ComponentScore{ComponentID: c.ID, Name: c.Name, Weight: c.Weight, MaxScore: c.MaxScore}
+			if score, ok := scores[scoreKey{report.OfferingID, report.UserID, c.ID}]; ok {
+				cs.Score = &score
+			}
+			report.Components = append(report.Components, cs)
+		}
+
+		scale := defaultScale
+		if report.scaleID != nil && byID[*report.scaleID] != nil {
+			scale = byID[*report.scaleID]
+		}
+		computeFinalGrade(report, scale)
+	}
+
+	return reports, nil
+}
+
+// ScoreRecord is a score as stored, for the personal data export. Unlike a
+// GradeReport it includes scores from offerings the user has since dropped.
+type ScoreRecord struct {
+	OfferingID    int64     `json:"offeringId"`
+	ModuleName    string    `json:"moduleName"`
+	ComponentID   int64     `json:"componentId"`
+	ComponentName string    `json:"componentName"`
+	Score         float64   `json:"score"`
+	EnteredAt     time.Time `json:"enteredAt"`
+}
+
+// GradeOverride is a change made to a score after the grades locked.
+type GradeOverride struct {
+	CreatedAt     time.Time `json:"createdAt"`
+	OfferingID    int64     `json:"offeringId"`
+	ModuleName    string    `json:"moduleName"`
+	ComponentID   int64     `json:"componentId"`
+	ComponentName string    `json:"componentName"`
+	OldScore      *float64  `json:"oldScore"`
+	NewScore      float64   `json:"newScore"`
+	Reason        string    `json:"reason"`
+}
+
+// ExportScoresForUser returns every score of the user, for the personal data
+// export.
+func (m GradeModel) ExportScoresForUser(userID int64) ([]*ScoreRecord, error) {
+	query := `
+SELECT assessment_scores.offering_id, module_info.module_name, assessment_scores.component_id,
+       assessment_components.name, assessment_scores.score, assessment_scores.entered_at
+FROM assessment_scores
+INNER JOIN assessment_components ON assessment_components.id = assessment_scores.component_id
+INNER JOIN module_info ON module_info.id = assessment_components.module_id
+WHERE assessment_scores.user_id = $1
+ORDER BY assessment_scores.entered_at, assessment_scores.offering_id, assessment_scores.component_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := []*ScoreRecord{}
+	for rows.Next() {
+		var s ScoreRecord
+		err = rows.Scan(&s.OfferingID, &s.ModuleName, &s.ComponentID, &s.ComponentName, &s.Score, &s.EnteredAt)
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// ExportOverridesForUser returns the overrides of the user's scores, for the
+// personal data export. The admins who made them are left out.
+func (m GradeModel) ExportOverridesForUser(userID int64) ([]*GradeOverride, error) {
+	query := `
+SELECT grade_overrides.created_at, grade_overrides.offering_id, module_info.module_name,
+       grade_overrides.component_id, assessment_components.name, grade_overrides.old_score,
+       grade_overrides.new_score, grade_overrides.reason
+FROM grade_overrides
+INNER JOIN assessment_components ON assessment_components.id = grade_overrides.component_id
+INNER JOIN module_info ON module_info.id = assessment_components.module_id
+WHERE grade_overrides.user_id = $1
+ORDER BY grade_overrides.created_at, grade_overrides.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []*GradeOverride{}
+	for rows.Next() {
+		var o GradeOverride
+		err = rows.Scan(&o.CreatedAt, &o.OfferingID, &o.ModuleName, &o.ComponentID, &o.ComponentName, &o.OldScore, &o.NewScore, &o.Reason)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, &o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
@@ -0,0 +1,380 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrDuplicateGroup = errors.New("duplicate group name for academic year")
+
+	AcademicYearRX = regexp.MustCompile(`^\d{4}-\d{4}$`)
+)
+
+// Group is a cohort of students such as "SE-2204". Names only have to be unique
+// within an academic year, so the same name can be reused the next year.
+type Group struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	Name         string    `json:"name"`
+	AcademicYear string    `json:"academicYear"`
+	Description  string    `json:"description"`
+	Version      int       `json:"version"`
+}
+
+func ValidateGroup(v *validator.Validator, group *Group) {
+	v.Check(group.Name != "", "name", "must be provided")
+	v.Check(len(group.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(group.Description) <= 1000, "description", "must not be more than 1000 bytes long")
+
+	v.Check(group.AcademicYear != "", "academicYear", "must be provided")
+	v.Check(validator.Matches(group.AcademicYear, AcademicYearRX), "academicYear", "must look like 2024-2025")
+	if validator.Matches(group.AcademicYear, AcademicYearRX) {
+		start, _ := strconv.Atoi(group.AcademicYear[:4])
+		end, _ := strconv.Atoi(group.AcademicYear[5:])
+		v.Check(end == start+1, "academicYear", "must span two consecutive years")
+	}
+}
+
+// ValidateIDs checks a list of ids sent for a bulk operation.
+func ValidateIDs(v *validator.Validator, key string, ids []int64) {
+	v.Check(len(ids) > 0, key, "must contain at least 1 id")
+	v.Check(len(ids) <= 500, key, "must not contain more than 500 ids")
+	v.Check(validator.Unique(ids), key, "must not contain duplicate ids")
+	for _, id := range ids {
+		if id < 1 {
+			v.AddError(key, "must contain only positive ids")
+			break
+		}
+	}
+}
+
+type GroupModel struct {
+	DB *sql.DB
+}
+
+func isDuplicateGroup(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Constraint == "groups_name_academic_year_key"
+}
+
+// isForeignKeyViolation reports whether err was caused by referencing a row that
+// does not exist.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+func (m GroupModel) Insert(group *Group) error {
+	query := `
+INSERT INTO groups (name, academic_year, description)
+VALUES ($1, $2, $3)
+RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, group.Name, group.AcademicYear, group.Description).Scan(
+		&group.ID,
+		&group.CreatedAt,
+		&group.UpdatedAt,
+		&group.Version,
+	)
+	if err != nil {
+		switch {
+		case isDuplicateGroup(err):
+			return ErrDuplicateGroup
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m GroupModel) Get(id int64) (*Group, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, updated_at, name, academic_year, description, version FROM groups WHERE id = $1`
+
+	var group Group
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&group.ID,
+		&group.CreatedAt,
+		&group.UpdatedAt,
+		&group.Name,
+		&group.AcademicYear,
+		&group.Description,
+		&group.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &group, nil
+}
+
+func (m GroupModel) GetAll(name, academicYear string, filters Filters) ([]*Group, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s, id, created_at, updated_at, name, academic_year, description, version
+FROM groups
+WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '') AND (academic_year = $2 OR $2 = '')
+AND %s
+ORDER BY %s LIMIT $5 OFFSET $6`, filters.cursorColumn(), filters.keyset(3), filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{name, academicYear}
+	args = append(args, filters.keysetArgs()...)
+	args = append(args, filters.limit(), filters.offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	groups := []*Group{}
+	keys := []cursorKey{}
+
+	for rows.Next() {
+		var group Group
+		var key cursorKey
+		err := rows.Scan(
+			&totalRecords,
+			&key.value,
+			&group.ID,
+			&group.CreatedAt,
+			&group.UpdatedAt,
+			&group.Name,
+			&group.AcademicYear,
+			&group.Description,
+			&group.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		key.id = group.ID
+		groups = append(groups, &group)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	groups, metadata := paginate(groups, keys, totalRecords, filters)
+
+	return groups, metadata, nil
+}
+
+func (m GroupModel) Update(group *Group) error {
+	query := `
+UPDATE groups
+SET name = $1, academic_year = $2, description = $3, updated_at = now(), version = version + 1
+WHERE id = $4 AND version = $5
+RETURNING updated_at, version`
+
+	args := []any{group.Name, group.AcademicYear, group.Description, group.ID, group.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&group.UpdatedAt, &group.Version)
+	if err != nil {
+		switch {
+		case isDuplicateGroup(err):
+			return ErrDuplicateGroup
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m GroupModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM groups WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AddMembers adds the users to the group, ignoring those who already belong to it,
+// and returns how many were added. Unknown user ids yield ErrInvalidReference and
+// nothing is added.
+func (m GroupModel) AddMembers(groupID int64, userIDs []int64) (int64, error) {
+	query := `
+INSERT INTO group_members (group_id, user_id)
+SELECT $1, unnest($2::bigint[])
+ON CONFLICT DO NOTHING`
+
+	return m.execBulk(query, groupID, userIDs)
+}
+
+// RemoveMembers removes the users from the group and returns how many were removed.
+func (m GroupModel) RemoveMembers(groupID int64, userIDs []int64) (int64, error) {
+	query := `DELETE FROM group_members WHERE group_id = $1 AND user_id = ANY($2::bigint[])`
+
+	return m.execBulk(query, groupID, userIDs)
+}
+
+// AssignModules assigns the modules to every member of the group.
+func (m GroupModel) AssignModules(groupID int64, moduleIDs []int64) (int64, error) {
+	query := `
+INSERT INTO group_modules (group_id, module_id)
+SELECT $1, unnest($2::bigint[])
+ON CONFLICT DO NOTHING`
+
+	return m.execBulk(query, groupID, moduleIDs)
+}
+
+func (m GroupModel) UnassignModules(groupID int64, moduleIDs []int64) (int64, error) {
+	query := `DELETE FROM group_modules WHERE group_id = $1 AND module_id = ANY($2::bigint[])`
+
+	return m.execBulk(query, groupID, moduleIDs)
+}
+
+func (m GroupModel) execBulk(query string, groupID int64, ids []int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, groupID, pq.Array(ids))
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return 0, ErrInvalidReference
+		default:
+			return 0, err
+		}
+	}
+
+	return result.RowsAffected()
+}
+
+// GroupMembership is a group a user belongs to, as listed in the personal data
+// export.
+type GroupMembership struct {
+	GroupID      int64     `json:"groupId"`
+	Name         string    `json:"name"`
+	AcademicYear string    `json:"academicYear"`
+	AddedAt      time.Time `json:"addedAt"`
+}
+
+// ExportForUser returns the groups the user belongs to, for the personal data
+// export.
+func (m GroupModel) ExportForUser(userID int64) ([]*GroupMembership, error) {
+	query := `
+SELECT groups.id, groups.name, groups.academic_year, group_members.added_at
+FROM group_members
+INNER JOIN groups ON groups.id = group_members.group_id
+WHERE group_members.user_id = $1
+ORDER BY group_members.added_at, groups.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*GroupMembership{}
+	for rows.Next() {
+		var membership GroupMembership
+		err = rows.Scan(&membership.GroupID, &membership.Name, &membership.AcademicYear, &membership.AddedAt)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, &membership)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+// GetModules returns the published modules assigned to the group.
+func (m GroupModel) GetModules(groupID int64) ([]*ModuleInfo, error) {
+	query := `
+SELECT module_info.id, module_info.created_at, module_info.updated_at, module_info.module_name,
+       module_info.contact_hours, module_info.self_study_hours, module_info.ects_credits, module_info.exam_type, module_info.version
+FROM ` + publishedModules + `
+INNER JOIN group_modules ON group_modules.module_id = module_info.id
+WHERE group_modules.group_id = $1
+ORDER BY module_info.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := []*ModuleInfo{}
+	for rows.Next() {
+		var info ModuleInfo
+		err = rows.Scan(
+			&info.ID,
+			&info.CreatedAt,
+			&info.UpdatedAt,
+			&info.ModuleName,
+			&info.ContactHours,
+			&info.SelfStudyHours,
+			&info.ECTSCredits,
+			&info.ExamType,
+			&info.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, &info)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
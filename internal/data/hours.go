@@ -0,0 +1,120 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidHours is returned when a number of hours can't be parsed.
+var ErrInvalidHours = errors.New(`invalid hours: use a number of hours, a duration such as "45h" or "1h30m", or an ISO 8601 duration such as "PT45H"`)
+
+// Hours is an amount of time in hours, such as the contact hours of a module. It
+// is written to JSON as a number of hours and read from a number of hours, a Go
+// duration ("45h", "1h30m") or an ISO 8601 duration ("PT45H", "P1DT2H").
+type Hours float64
+
+// isoDuration matches the ISO 8601 durations Hours accepts: days and a time part.
+// Years, months and weeks have no fixed length in hours.
+var isoDuration = regexp.MustCompile(`^P(?:(\d+(?:[.,]\d+)?)D)?(?:T(?:(\d+(?:[.,]\d+)?)H)?(?:(\d+(?:[.,]\d+)?)M)?(?:(\d+(?:[.,]\d+)?)S)?)?$`)
+
+// ParseHours reads hours in any of the forms Hours accepts from JSON, rounded to
+// hundredths of an hour.
+func ParseHours(s string) (Hours, error) {
+	s = strings.TrimSpace(s)
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return roundHours(f)
+	}
+
+	if m := isoDuration.FindStringSubmatch(strings.ToUpper(s)); m != nil && s != "P" && !strings.HasSuffix(strings.ToUpper(s), "T") {
+		var hours float64
+		for i, perHour := range []float64{24, 1, 1.0 / 60, 1.0 / 3600} {
+			if m[i+1] == "" {
+				continue
+			}
+			f, err := strconv.ParseFloat(strings.Replace(m[i+1], ",", ".", 1), 64)
+			if err != nil {
+				return 0, ErrInvalidHours
+			}
+			hours += f * perHour
+		}
+		return roundHours(hours)
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, ErrInvalidHours
+	}
+	return roundHours(d.Hours())
+}
+
+func roundHours(f float64) (Hours, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrInvalidHours
+	}
+	return Hours(math.Round(f*100) / 100), nil
+}
+
+func (h Hours) String() string {
+	return strconv.FormatFloat(float64(h), 'f', -1, 64) + "h"
+}
+
+func (h Hours) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(float64(h), 'f', -1, 64)), nil
+}
+
+func (h *Hours) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		// Not a string, so it has to be a plain number of hours.
+		var f float64
+		if err := json.Unmarshal(b, &f); err != nil {
+			return ErrInvalidHours
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	hours, err := ParseHours(s)
+	if err != nil {
+		return err
+	}
+	*h = hours
+	return nil
+}
+
+// Scan implements sql.Scanner for NUMERIC columns.
+func (h *Hours) Scan(src any) error {
+	switch src := src.(type) {
+	case []byte:
+		f, err := strconv.ParseFloat(string(src), 64)
+		if err != nil {
+			return err
+		}
+		*h = Hours(f)
+	case string:
+		f, err := strconv.ParseFloat(src, 64)
+		if err != nil {
+			return err
+		}
+		*h = Hours(f)
+	case float64:
+		*h = Hours(src)
+	case int64:
+		*h = Hours(src)
+	default:
+		return fmt.Errorf("can't scan %T into Hours", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (h Hours) Value() (driver.Value, error) {
+	return float64(h), nil
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"github.com/shynggys9219/greenlight/internal/fieldcrypt"
 	"time"
 )
 
@@ -11,37 +12,87 @@ import (
 var (
 	ErrRecordNotFound = errors.New("record (row, entry) not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	// ErrInvalidReference is returned when a write refers to a row that doesn't exist.
+	ErrInvalidReference = errors.New("referenced record does not exist")
 )
 
 // Create a Models struct which wraps the MovieModel
 // kind of enveloping
 type Models struct {
-	Movies      MovieModel
-	ModuleInfos ModuleInfoModel
-	DepInfos    DepartmentInfoModel
-	UserInfos   UserInfoModel
-	Tokens      TokenModel
+	Movies        MovieModel
+	ModuleInfos   ModuleInfoModel
+	DepInfos      DepartmentInfoModel
+	UserInfos     UserInfoModel
+	Tokens        TokenModel
+	PersonalData  PersonalDataModel
+	Avatars       AvatarModel
+	Groups        GroupModel
+	Notifications NotificationModel
+
+	ModulePrerequisites ModulePrerequisiteModel
+	Terms               TermModel
+	Offerings           OfferingModel
+	Enrollments         EnrollmentModel
+	Sessions            SessionModel
+	ExamRooms           ExamRoomModel
+	ExamSessions        ExamSessionModel
+	GradeScales         GradeScaleModel
+	Assessments         AssessmentModel
+	Grades              GradeModel
+	Transcripts         TranscriptModel
+	Workload            WorkloadModel
 }
 
 // method which returns a Models struct containing the initialized MovieModel.
-func NewModels(db *sql.DB) Models {
+// keys may be nil, in which case user PII is stored unencrypted.
+func NewModels(db *sql.DB, keys *fieldcrypt.Keyring) Models {
+	users := UserInfoModel{DB: db, Keys: keys}
+
 	return Models{
-		Movies:      MovieModel{DB: db},
-		DepInfos:    DepartmentInfoModel{DB: db},
-		ModuleInfos: ModuleInfoModel{DB: db},
-		UserInfos:   UserInfoModel{DB: db},
-		Tokens:      TokenModel{DB: db},
+		Movies:        MovieModel{DB: db},
+		DepInfos:      DepartmentInfoModel{DB: db},
+		ModuleInfos:   ModuleInfoModel{DB: db},
+		UserInfos:     users,
+		Tokens:        TokenModel{DB: db},
+		PersonalData:  PersonalDataModel{DB: db, Users: users},
+		Avatars:       AvatarModel{DB: db},
+		Groups:        GroupModel{DB: db},
+		Notifications: NotificationModel{DB: db},
+
+		ModulePrerequisites: ModulePrerequisiteModel{DB: db},
+		Terms:               TermModel{DB: db},
+		Offerings:           OfferingModel{DB: db},
+		Enrollments:         EnrollmentModel{DB: db},
+		Sessions:            SessionModel{DB: db},
+		ExamRooms:           ExamRoomModel{DB: db},
+		ExamSessions:        ExamSessionModel{DB: db},
+		GradeScales:         GradeScaleModel{DB: db},
+		Assessments:         AssessmentModel{DB: db},
+		Grades:              GradeModel{DB: db},
+		Transcripts:         TranscriptModel{DB: db},
+		Workload:            WorkloadModel{DB: db},
 	}
 }
 
 type ModuleInfo struct {
-	ID             int           `json:"id,omitempty"`
-	CreatedAt      time.Time     `json:"-"`
-	UpdatedAt      time.Time     `json:"updatedAt,omitempty"`
-	ModuleName     string        `json:"moduleName"`
-	ModuleDuration time.Duration `json:"moduleDuration"`
-	ExamType       string        `json:"examType"`
-	Version        string        `json:"version"`
+	ID         int       `json:"id,omitempty"`
+	CreatedAt  time.Time `json:"-"`
+	UpdatedAt  time.Time `json:"updatedAt,omitempty"`
+	ModuleName string    `json:"moduleName"`
+	ExamType   string    `json:"examType"`
+	Version    string    `json:"version"`
+
+	// ContactHours are taught, SelfStudyHours are expected of students on their
+	// own, and ECTSCredits are what completing the module is worth.
+	ContactHours   Hours   `json:"contactHours"`
+	SelfStudyHours Hours   `json:"selfStudyHours"`
+	ECTSCredits    float64 `json:"ectsCredits"`
+
+	// Status is the workflow state of the latest version. PublishedVersion is the
+	// version registered users see, which stays live while a newer one is reviewed.
+	Status           string `json:"status,omitempty"`
+	AuthorID         *int64 `json:"authorId,omitempty"`
+	PublishedVersion *int   `json:"publishedVersion,omitempty"`
 }
 
 type DepartmentInfo struct {
@@ -50,22 +101,48 @@ type DepartmentInfo struct {
 	DepartmentDirector string `json:"departmentDirector"`
 	StaffQuantity      int    `json:"staffQuantity"`
 	ModuleID           int    `json:"moduleId"`
+	Version            int    `json:"version"`
 }
 
+// UserInfo is never marshalled as is: its MarshalJSON only emits the public
+// profile, and handlers pick a wider projection from user_views.go depending on
+// who is asking. The password hash is not part of any projection.
 type UserInfo struct {
-	ID           int       `json:"id"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
-	Name         string    `json:"name"`
-	Surname      string    `json:"surname"`
-	Email        string    `json:"email"`
-	PasswordHash password  `json:"passwordHash"`
-	Role         string    `json:"role"`
-	Activated    bool      `json:"activated"`
-	Version      int       `json:"version"`
+	ID           int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Name         string
+	Surname      string
+	Email        string
+	PasswordHash password
+	Role         string
+	Activated    bool
+	Version      int
+
+	SuspendedAt      *time.Time
+	SuspensionReason string
+	ExpiresAt        *time.Time
 }
 
 const (
 	Admin      = "admin"
 	Registered = "registered"
 )
+
+// AnonymousUser represents a request that carries no Authorization header.
+var AnonymousUser = &UserInfo{}
+
+// IsAnonymous reports whether the UserInfo is the AnonymousUser.
+func (u *UserInfo) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// IsSuspended reports whether an admin has suspended the account.
+func (u *UserInfo) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// IsExpired reports whether the account has passed its expiry date.
+func (u *UserInfo) IsExpired() bool {
+	return u.ExpiresAt != nil && !u.ExpiresAt.After(time.Now())
+}
@@ -1,45 +1,105 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/validator"
 	"log"
+	"math"
+	"time"
 )
 
+// ValidateModuleInfo checks the content of a module.
+func ValidateModuleInfo(v *validator.Validator, info *ModuleInfo) {
+	v.Check(info.ModuleName != "", "moduleName", "must be provided")
+	v.Check(len(info.ModuleName) <= 255, "moduleName", "must not be more than 255 bytes long")
+	v.Check(info.ExamType != "", "examType", "must be provided")
+	v.Check(len(info.ExamType) <= 255, "examType", "must not be more than 255 bytes long")
+
+	ValidateModuleHours(v, info)
+}
+
+// ValidateModuleHours checks the hours and credits of a module.
+func ValidateModuleHours(v *validator.Validator, info *ModuleInfo) {
+	v.Check(info.ContactHours > 0, "contactHours", "must be greater than zero")
+	v.Check(info.ContactHours <= 9999, "contactHours", "must not be more than 9999 hours")
+	v.Check(info.SelfStudyHours >= 0, "selfStudyHours", "must not be negative")
+	v.Check(info.SelfStudyHours <= 9999, "selfStudyHours", "must not be more than 9999 hours")
+	v.Check(info.ECTSCredits >= 0, "ectsCredits", "must not be negative")
+	v.Check(info.ECTSCredits <= 999, "ectsCredits", "must not be more than 999")
+	v.Check(info.ECTSCredits*10 == math.Round(info.ECTSCredits*10), "ectsCredits", "must not have more than one decimal")
+}
+
 type ModuleInfoModel struct {
 	DB *sql.DB
 }
 
+// Insert creates the module as a draft and records its content as revision 1.
 func (m ModuleInfoModel) Insert(info *ModuleInfo) error {
-	query := "INSERT INTO module_info(created_at, module_name, module_duration, exam_type) VALUES (now(), $1,$2,$3) RETURNING id, created_at, version"
+	query := "INSERT INTO module_info(created_at, module_name, contact_hours, self_study_hours, ects_credits, exam_type, author_id) VALUES (now(), $1,$2,$3,$4,$5,$6) RETURNING id, created_at, updated_at, version, status"
 
-	log.Println("inserted to db")
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	return m.DB.QueryRow(
+	err = tx.QueryRowContext(
+		ctx,
 		query,
 		&info.ModuleName,
-		&info.ModuleDuration,
+		&info.ContactHours,
+		&info.SelfStudyHours,
+		&info.ECTSCredits,
 		&info.ExamType,
+		info.AuthorID,
 	).Scan(
 		&info.ID,
 		&info.CreatedAt,
+		&info.UpdatedAt,
 		&info.Version,
+		&info.Status,
 	)
+	if err != nil {
+		return err
+	}
+
+	err = writeRevision(ctx, tx, int64(info.ID), nil)
+	if err != nil {
+		return err
+	}
+
+	log.Println("inserted to db")
+
+	return tx.Commit()
 }
 
 func (m ModuleInfoModel) Get(id int64) (*ModuleInfo, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := "SELECT module_name, module_duration, exam_type, version FROM module_info WHERE id = $1"
+	query := "SELECT id, created_at, updated_at, module_name, contact_hours, self_study_hours, ects_credits, exam_type, version, status, author_id, published_version FROM module_info WHERE id = $1"
 
 	var info ModuleInfo
 
 	err := m.DB.QueryRow(query, id).Scan(
+		&info.ID,
+		&info.CreatedAt,
+		&info.UpdatedAt,
 		&info.ModuleName,
-		&info.ModuleDuration,
+		&info.ContactHours,
+		&info.SelfStudyHours,
+		&info.ECTSCredits,
 		&info.ExamType,
 		&info.Version,
+		&info.Status,
+		&info.AuthorID,
+		&info.PublishedVersion,
 	)
 	if err != nil {
 		switch {
@@ -53,49 +113,197 @@ func (m ModuleInfoModel) Get(id int64) (*ModuleInfo, error) {
 	return &info, nil
 }
 
-func (m ModuleInfoModel) GetLatestFifty() []*ModuleInfo {
-	query := "SELECT * FROM module_info ORDER BY id DESC LIMIT 50"
+// GetEffective returns the module as registered users see it at the given time:
+// the content of the published version with the latest effective date up to then.
+// Modules that weren't in effect yet or are archived yield ErrRecordNotFound.
+func (m ModuleInfoModel) GetEffective(id int64, asOf time.Time) (*ModuleInfo, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := "SELECT id, created_at, updated_at, module_name, contact_hours, self_study_hours, ects_credits, exam_type, version, status, author_id, published_version FROM " + effectiveModules("$2") + " WHERE id = $1"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var info ModuleInfo
 
-	rows, err := m.DB.Query(query)
+	err := m.DB.QueryRowContext(ctx, query, id, asOf).Scan(
+		&info.ID,
+		&info.CreatedAt,
+		&info.UpdatedAt,
+		&info.ModuleName,
+		&info.ContactHours,
+		&info.SelfStudyHours,
+		&info.ECTSCredits,
+		&info.ExamType,
+		&info.Version,
+		&info.Status,
+		&info.AuthorID,
+		&info.PublishedVersion,
+	)
 	if err != nil {
-		return nil
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &info, nil
+}
+
+// effectiveModules stands in for the module_info table in queries that must only
+// see published content. Each module appears with the content and version of the
+// revision in effect at asOf, an SQL expression; updated_at is when that revision
+// took effect.
+func effectiveModules(asOf string) string {
+	return `(
+SELECT module_info.id, module_info.created_at, revision.effective_from AS updated_at,
+       revision.module_name, revision.contact_hours, revision.self_study_hours, revision.ects_credits, revision.exam_type,
+       revision.version, 'published' AS status, module_info.author_id, revision.version AS published_version
+FROM module_info
+CROSS JOIN LATERAL (
+    SELECT version, module_name, contact_hours, self_study_hours, ects_credits, exam_type, effective_from
+    FROM module_info_revisions
+    WHERE module_info_revisions.module_id = module_info.id AND module_info_revisions.effective_from <= ` + asOf + `
+    ORDER BY module_info_revisions.effective_from DESC, module_info_revisions.version DESC
+    LIMIT 1
+) AS revision
+WHERE module_info.status <> 'archived'
+) AS module_info`
+}
+
+// publishedModules is effectiveModules as of now.
+var publishedModules = effectiveModules("now()")
+
+// GetAll lists modules. name matches a substring of the module name, examType the
+// exam type exactly (ignoring case), status the workflow status, and minHours and
+// maxHours bound the contact hours; zero values disable a filter. departmentID limits the list to the modules of that department.
+// With publishedOnly the list shows what registered users see now, as GetEffective.
+func (m ModuleInfoModel) GetAll(name, examType, status string, minHours, maxHours Hours, departmentID int64, publishedOnly bool, filters Filters) ([]*ModuleInfo, Metadata, error) {
+	source := "module_info"
+	if publishedOnly {
+		source = publishedModules
+	}
+
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s, id, created_at, updated_at, module_name, contact_hours, self_study_hours, ects_credits, exam_type, version, status, author_id, published_version
+FROM %s
+WHERE (module_name ILIKE '%%' || $1 || '%%' OR $1 = '')
+AND (LOWER(exam_type) = LOWER($2) OR $2 = '')
+AND ($3 = 0 OR contact_hours >= $3)
+AND ($4 = 0 OR contact_hours <= $4)
+AND ($5 = 0 OR id IN (SELECT module_id FROM department_info WHERE id = $5))
+AND (status = $6 OR $6 = '')
+AND %s
+ORDER BY %s LIMIT $9 OFFSET $10`, filters.cursorColumn(), source, filters.keyset(7), filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{name, examType, minHours, maxHours, departmentID, status}
+	args = append(args, filters.keysetArgs()...)
+	args = append(args, filters.limit(), filters.offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
 	}
+	defer rows.Close()
+
+	totalRecords := 0
+	infos := []*ModuleInfo{}
+	keys := []cursorKey{}
 
-	var infos []*ModuleInfo
 	for rows.Next() {
-		info := &ModuleInfo{}
+		var info ModuleInfo
+		var key cursorKey
 		err = rows.Scan(
+			&totalRecords,
+			&key.value,
 			&info.ID,
 			&info.CreatedAt,
 			&info.UpdatedAt,
 			&info.ModuleName,
-			&info.ModuleDuration,
+			&info.ContactHours,
+			&info.SelfStudyHours,
+			&info.ECTSCredits,
 			&info.ExamType,
 			&info.Version,
+			&info.Status,
+			&info.AuthorID,
+			&info.PublishedVersion,
 		)
 		if err != nil {
-			return nil
+			return nil, Metadata{}, err
 		}
-		infos = append(infos, info)
+		key.id = int64(info.ID)
+		infos = append(infos, &info)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil
+		return nil, Metadata{}, err
 	}
-	return infos
+
+	infos, metadata := paginate(infos, keys, totalRecords, filters)
+
+	return infos, metadata, nil
 }
 
+// Update saves the module as a new version and records that version in the
+// revision history. It only succeeds if info.Version is still the current version,
+// and returns ErrEditConflict otherwise. The new version is a draft that has to go
+// through review again; the published version stays live in the meantime, and a
+// version scheduled to take effect later is cancelled.
 func (m ModuleInfoModel) Update(info *ModuleInfo) error {
-	query := "UPDATE module_info SET updated_at = now(), module_name = $1, module_duration = $2, exam_type = $3, version = version + 1 WHERE id = $4 RETURNING version"
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateModuleInfo(ctx, tx, info, nil)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// updateModuleInfo writes info and its new revision within tx. restoredFrom is the
+// version the content was taken from when rolling back.
+func updateModuleInfo(ctx context.Context, tx *sql.Tx, info *ModuleInfo, restoredFrom *int) error {
+	query := "UPDATE module_info SET updated_at = now(), module_name = $1, contact_hours = $2, self_study_hours = $3, ects_credits = $4, exam_type = $5, status = 'draft', version = version + 1 WHERE id = $6 AND version = $7 RETURNING updated_at, version, status"
 
 	args := []interface{}{
 		info.ModuleName,
-		info.ModuleDuration,
+		info.ContactHours,
+		info.SelfStudyHours,
+		info.ECTSCredits,
 		info.ExamType,
 		info.ID,
+		info.Version,
+	}
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&info.UpdatedAt, &info.Version, &info.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = writeRevision(ctx, tx, int64(info.ID), restoredFrom)
+	if err != nil {
+		return err
 	}
-	return m.DB.QueryRow(query, args...).Scan(&info.Version)
 
+	// A version waiting to take effect is replaced by the new draft.
+	return cancelScheduled(ctx, tx, int64(info.ID), info.Version)
 }
 
 func (m ModuleInfoModel) Delete(id int64) error {
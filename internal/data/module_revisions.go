@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ModuleRevision is the content a module had at one version. RestoredFrom is set
+// when the version was created by rolling back to an older one. EffectiveFrom is
+// set once the version is published and is when it takes or took effect.
+type ModuleRevision struct {
+	ModuleID       int64      `json:"moduleId"`
+	Version        int        `json:"version"`
+	ModuleName     string     `json:"moduleName"`
+	ContactHours   Hours      `json:"contactHours"`
+	SelfStudyHours Hours      `json:"selfStudyHours"`
+	ECTSCredits    float64    `json:"ectsCredits"`
+	ExamType       string     `json:"examType"`
+	RestoredFrom   *int       `json:"restoredFrom,omitempty"`
+	EffectiveFrom  *time.Time `json:"effectiveFrom,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// FieldChange is a field that differs between two revisions, named as in the JSON
+// of a module.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// DiffRevisions lists the fields that changed going from one revision to another.
+func DiffRevisions(from, to *ModuleRevision) []FieldChange {
+	changes := []FieldChange{}
+	if from.ModuleName != to.ModuleName {
+		changes = append(changes, FieldChange{Field: "moduleName", From: from.ModuleName, To: to.ModuleName})
+	}
+	if from.ContactHours != to.ContactHours {
+		changes = append(changes, FieldChange{Field: "contactHours", From: from.ContactHours, To: to.ContactHours})
+	}
+	if from.SelfStudyHours != to.SelfStudyHours {
+		changes = append(changes, FieldChange{Field: "selfStudyHours", From: from.SelfStudyHours, To: to.SelfStudyHours})
+	}
+	if from.ECTSCredits != to.ECTSCredits {
+		changes = append(changes, FieldChange{Field: "ectsCredits", From: from.ECTSCredits, To: to.ECTSCredits})
+	}
+	if from.ExamType != to.ExamType {
+		changes = append(changes, FieldChange{Field: "examType", From: from.ExamType, To: to.ExamType})
+	}
+	return changes
+}
+
+// writeRevision copies the current content of the module into the revision history.
+func writeRevision(ctx context.Context, tx *sql.Tx, moduleID int64, restoredFrom *int) error {
+	query := `
+INSERT INTO module_info_revisions (module_id, version, module_name, contact_hours, self_study_hours, ects_credits, exam_type, restored_from, created_at)
+SELECT id, version, module_name, contact_hours, self_study_hours, ects_credits, exam_type, $2, updated_at
+FROM module_info
+WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, moduleID, restoredFrom)
+	return err
+}
+
+// GetRevisions returns the revision history of the module, newest first.
+func (m ModuleInfoModel) GetRevisions(moduleID int64) ([]*ModuleRevision, error) {
+	query := `
+SELECT module_id, version, module_name, contact_hours, self_study_hours, ects_credits, exam_type, restored_from, effective_from, created_at
+FROM module_info_revisions
+WHERE module_id = $1
+ORDER BY version DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, moduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*ModuleRevision{}
+	for rows.Next() {
+		var revision ModuleRevision
+		err = rows.Scan(
+			&revision.ModuleID,
+			&revision.Version,
+			&revision.ModuleName,
+			&revision.ContactHours,
+			&revision.SelfStudyHours,
+			&revision.ECTSCredits,
+			&revision.ExamType,
+			&revision.RestoredFrom,
+			&revision.EffectiveFrom,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetRevision returns one version of the module, or ErrRecordNotFound.
+func (m ModuleInfoModel) GetRevision(moduleID int64, version int) (*ModuleRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getRevision(ctx, m.DB, moduleID, version)
+}
+
+// rowQuerier is implemented by both *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getRevision(ctx context.Context, db rowQuerier, moduleID int64, version int) (*ModuleRevision, error) {
+	query := `
+SELECT module_id, version, module_name, contact_hours, self_study_hours, ects_credits, exam_type, restored_from, effective_from, created_at
+FROM module_info_revisions
+WHERE module_id = $1 AND version = $2`
+
+	var revision ModuleRevision
+	err := db.QueryRowContext(ctx, query, moduleID, version).Scan(
+		&revision.ModuleID,
+		&revision.Version,
+		&revision.ModuleName,
+		&revision.ContactHours,
+		&revision.SelfStudyHours,
+		&revision.ECTSCredits,
+		&revision.ExamType,
+		&revision.RestoredFrom,
+		&revision.EffectiveFrom,
+		&revision.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &revision, nil
+}
+
+// Restore rolls the module back to the content of an older version. The history
+// is kept: the restored content is saved as a new version. A missing module or
+// version yields ErrRecordNotFound. Unless expectedVersion is 0, the module must
+// still be at that version or ErrEditConflict is returned.
+func (m ModuleInfoModel) Restore(moduleID int64, version, expectedVersion int) (*ModuleInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	revision, err := getRevision(ctx, tx, moduleID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ModuleInfo{
+		ID:             int(moduleID),
+		ModuleName:     revision.ModuleName,
+		ContactHours:   revision.ContactHours,
+		SelfStudyHours: revision.SelfStudyHours,
+		ECTSCredits:    revision.ECTSCredits,
+		ExamType:       revision.ExamType,
+	}
+
+	var current int
+	err = tx.QueryRowContext(ctx, "SELECT created_at, version, author_id, published_version FROM module_info WHERE id = $1 FOR UPDATE", moduleID).Scan(&info.CreatedAt, &current, &info.AuthorID, &info.PublishedVersion)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if expectedVersion != 0 && expectedVersion != current {
+		return nil, ErrEditConflict
+	}
+	info.Version = strconv.Itoa(current)
+
+	err = updateModuleInfo(ctx, tx, info, &revision.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, tx.Commit()
+}
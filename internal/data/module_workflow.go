@@ -0,0 +1,334 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The workflow states of a module. New modules and new versions of a module start
+// as drafts; only published ones are visible to registered users. Scheduled
+// versions have been published with an effective date in the future.
+const (
+	ModuleDraft     = "draft"
+	ModuleInReview  = "in_review"
+	ModuleApproved  = "approved"
+	ModuleScheduled = "scheduled"
+	ModulePublished = "published"
+	ModuleArchived  = "archived"
+)
+
+var ModuleStatuses = []string{ModuleDraft, ModuleInReview, ModuleApproved, ModuleScheduled, ModulePublished, ModuleArchived}
+
+// The actions that move a module through the workflow.
+const (
+	ModuleSubmit  = "submit"
+	ModuleApprove = "approve"
+	ModuleReject  = "reject"
+	ModulePublish = "publish"
+	ModuleArchive = "archive"
+)
+
+type moduleTransition struct {
+	from []string
+	to   string
+}
+
+var moduleTransitions = map[string]moduleTransition{
+	ModuleSubmit:  {from: []string{ModuleDraft}, to: ModuleInReview},
+	ModuleApprove: {from: []string{ModuleInReview}, to: ModuleApproved},
+	ModuleReject:  {from: []string{ModuleInReview, ModuleApproved, ModuleScheduled}, to: ModuleDraft},
+	ModulePublish: {from: []string{ModuleApproved}, to: ModulePublished},
+	ModuleArchive: {from: []string{ModuleDraft, ModuleInReview, ModuleApproved, ModuleScheduled, ModulePublished}, to: ModuleArchived},
+}
+
+// TransitionError is returned when a workflow action isn't allowed in the module's
+// current status.
+type TransitionError struct {
+	Action string
+	Status string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("can't %s a module that is %s", e.Action, strings.ReplaceAll(e.Status, "_", " "))
+}
+
+// ModuleReview records a workflow action taken on a version of a module.
+type ModuleReview struct {
+	ID        int64     `json:"id"`
+	ModuleID  int64     `json:"moduleId"`
+	Version   int       `json:"version"`
+	ActorID   *int64    `json:"actorId"`
+	Action    string    `json:"action"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// IsDirector reports whether the user is named as the director of one of the given
+// departments, by full name or email as in ExportPersonalData.
+func IsDirector(user *UserInfo, directors []string) bool {
+	for _, director := range directors {
+		director = strings.TrimSpace(director)
+		if strings.EqualFold(director, fullName(user)) || strings.EqualFold(director, user.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDirectors returns the directors of the departments the module belongs to, as
+// written in department_info.
+func (m ModuleInfoModel) GetDirectors(moduleID int64) ([]string, error) {
+	query := `
+SELECT DISTINCT department_director FROM department_info
+WHERE module_id = $1 AND department_director IS NOT NULL AND department_director <> ''`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, moduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	directors := []string{}
+	for rows.Next() {
+		var director string
+		err = rows.Scan(&director)
+		if err != nil {
+			return nil, err
+		}
+		directors = append(directors, director)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return directors, nil
+}
+
+// Transition applies a workflow action to the module and records it with the
+// comment. Publishing makes the current version take effect at effectiveFrom, or
+// straight away if that is nil or has passed; until then the module is scheduled
+// and the previously published version stays in effect. Publishing also cancels
+// an older version that is still scheduled, and rejecting a scheduled version
+// cancels it. Unless expectedVersion is 0 the module must still be at that
+// version, so that a reviewer can't approve changes they haven't seen; otherwise
+// ErrEditConflict is returned. An action that isn't allowed in the current status
+// yields a *TransitionError.
+func (m ModuleInfoModel) Transition(moduleID, actorID int64, action, comment string, expectedVersion int, effectiveFrom *time.Time) (*ModuleInfo, error) {
+	transition, ok := moduleTransitions[action]
+	if !ok {
+		return nil, fmt.Errorf("unknown module action %q", action)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var info ModuleInfo
+	query := `
+SELECT id, created_at, updated_at, module_name, contact_hours, self_study_hours, ects_credits, exam_type, version, status, author_id, published_version
+FROM module_info WHERE id = $1 FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, moduleID).Scan(
+		&info.ID,
+		&info.CreatedAt,
+		&info.UpdatedAt,
+		&info.ModuleName,
+		&info.ContactHours,
+		&info.SelfStudyHours,
+		&info.ECTSCredits,
+		&info.ExamType,
+		&info.Version,
+		&info.Status,
+		&info.AuthorID,
+		&info.PublishedVersion,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if expectedVersion != 0 && strconv.Itoa(expectedVersion) != info.Version {
+		return nil, ErrEditConflict
+	}
+	if !validator.PermittedValue(info.Status, transition.from...) {
+		return nil, &TransitionError{Action: action, Status: info.Status}
+	}
+
+	// Backdating isn't possible: the version would take effect before versions
+	// that were in effect since.
+	if effectiveFrom != nil && !effectiveFrom.After(time.Now()) {
+		effectiveFrom = nil
+	}
+
+	status := transition.to
+	if action == ModulePublish && effectiveFrom != nil {
+		status = ModuleScheduled
+	}
+
+	query = `
+UPDATE module_info
+SET status = $1, published_version = CASE WHEN $3 THEN version ELSE published_version END
+WHERE id = $2
+RETURNING status, published_version`
+
+	err = tx.QueryRowContext(ctx, query, status, moduleID, status == ModulePublished).Scan(&info.Status, &info.PublishedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case action == ModulePublish:
+		query = `UPDATE module_info_revisions SET effective_from = COALESCE($3, now()) WHERE module_id = $1 AND version = $2`
+		_, err = tx.ExecContext(ctx, query, moduleID, info.Version, effectiveFrom)
+		if err == nil {
+			err = cancelScheduled(ctx, tx, moduleID, info.Version)
+		}
+	case action == ModuleReject:
+		query = `UPDATE module_info_revisions SET effective_from = NULL WHERE module_id = $1 AND version = $2 AND effective_from > now()`
+		_, err = tx.ExecContext(ctx, query, moduleID, info.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+INSERT INTO module_reviews (module_id, version, actor_id, action, comment)
+VALUES ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, query, moduleID, info.Version, actorID, action, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, tx.Commit()
+}
+
+// cancelScheduled takes back the versions of the module older than version that
+// are scheduled to take effect later. version supersedes them, and left in place
+// they would take effect over it once their date came.
+func cancelScheduled(ctx context.Context, tx *sql.Tx, moduleID int64, version string) error {
+	query := `
+UPDATE module_info_revisions SET effective_from = NULL
+WHERE module_id = $1 AND version < $2 AND effective_from > now()`
+
+	_, err := tx.ExecContext(ctx, query, moduleID, version)
+	return err
+}
+
+// PromoteScheduled marks every module whose scheduled version has taken effect as
+// published and returns them with the content now in effect. A module is only
+// returned by one call, even when several instances run it at the same time.
+func (m ModuleInfoModel) PromoteScheduled() ([]*ModuleInfo, error) {
+	query := `
+UPDATE module_info
+SET published_version = revision.version,
+    status = CASE WHEN module_info.status = 'scheduled' AND module_info.version = revision.version THEN 'published' ELSE module_info.status END
+FROM (
+    SELECT DISTINCT ON (module_id) module_id, version, module_name, contact_hours, self_study_hours, ects_credits, exam_type, effective_from
+    FROM module_info_revisions
+    WHERE effective_from <= now()
+    ORDER BY module_id, effective_from DESC, version DESC
+) AS revision
+WHERE module_info.id = revision.module_id
+AND module_info.status <> 'archived'
+AND module_info.published_version IS DISTINCT FROM revision.version
+RETURNING module_info.id, module_info.created_at, revision.effective_from, revision.module_name,
+          revision.contact_hours, revision.self_study_hours, revision.ects_credits, revision.exam_type, revision.version, module_info.status,
+          module_info.author_id, module_info.published_version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := []*ModuleInfo{}
+	for rows.Next() {
+		var info ModuleInfo
+		err = rows.Scan(
+			&info.ID,
+			&info.CreatedAt,
+			&info.UpdatedAt,
+			&info.ModuleName,
+			&info.ContactHours,
+			&info.SelfStudyHours,
+			&info.ECTSCredits,
+			&info.ExamType,
+			&info.Version,
+			&info.Status,
+			&info.AuthorID,
+			&info.PublishedVersion,
+		)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, &info)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// GetReviews returns the workflow history of the module, oldest first.
+func (m ModuleInfoModel) GetReviews(moduleID int64) ([]*ModuleReview, error) {
+	query := `
+SELECT id, module_id, version, actor_id, action, comment, created_at
+FROM module_reviews
+WHERE module_id = $1
+ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, moduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*ModuleReview{}
+	for rows.Next() {
+		var review ModuleReview
+		err = rows.Scan(
+			&review.ID,
+			&review.ModuleID,
+			&review.Version,
+			&review.ActorID,
+			&review.Action,
+			&review.Comment,
+			&review.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
@@ -0,0 +1,293 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"time"
+)
+
+const (
+	CategoryAccount        = "account"
+	CategorySecurity       = "security"
+	CategoryModuleChanges  = "module_changes"
+	CategoryModuleReviews  = "module_reviews"
+	CategoryDepartmentNews = "department_news"
+	CategoryEnrollment     = "enrollment"
+
+	ChannelEmail = "email"
+	ChannelInApp = "in_app"
+	ChannelNone  = "none"
+)
+
+var Channels = []string{ChannelEmail, ChannelInApp, ChannelNone}
+
+// NotificationCategory describes a kind of event users can be notified about.
+// Mandatory categories are always emailed and can't be changed or unsubscribed
+// from; they may carry secrets such as activation tokens, so they are never stored
+// in the in-app inbox either.
+type NotificationCategory struct {
+	Name           string `json:"category"`
+	Mandatory      bool   `json:"mandatory"`
+	DefaultChannel string `json:"defaultChannel"`
+}
+
+var NotificationCategories = []NotificationCategory{
+	{Name: CategoryAccount, Mandatory: true, DefaultChannel: ChannelEmail},
+	{Name: CategorySecurity, Mandatory: true, DefaultChannel: ChannelEmail},
+	{Name: CategoryModuleChanges, DefaultChannel: ChannelEmail},
+	{Name: CategoryModuleReviews, DefaultChannel: ChannelEmail},
+	{Name: CategoryDepartmentNews, DefaultChannel: ChannelInApp},
+	{Name: CategoryEnrollment, DefaultChannel: ChannelEmail},
+}
+
+// LookupCategory returns the category with the given name.
+func LookupCategory(name string) (NotificationCategory, bool) {
+	for _, c := range NotificationCategories {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return NotificationCategory{}, false
+}
+
+type Notification struct {
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"-"`
+	Category  string     `json:"category"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ReadAt    *time.Time `json:"readAt"`
+}
+
+type NotificationPreference struct {
+	Category  string `json:"category"`
+	Channel   string `json:"channel"`
+	Mandatory bool   `json:"mandatory"`
+}
+
+func ValidateNotificationPreference(v *validator.Validator, pref *NotificationPreference) {
+	category, ok := LookupCategory(pref.Category)
+	v.Check(ok, "category", "unknown notification category")
+	v.Check(!category.Mandatory, "category", "notifications of this category are mandatory")
+	v.Check(validator.PermittedValue(pref.Channel, Channels...), "channel", "must be one of email, in_app or none")
+}
+
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+func (m NotificationModel) Insert(n *Notification) error {
+	query := `
+INSERT INTO notifications (user_id, category, subject, body)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, n.UserID, n.Category, n.Subject, n.Body).Scan(&n.ID, &n.CreatedAt)
+}
+
+// GetAllForUser lists the user's inbox, newest first unless sorted otherwise.
+func (m NotificationModel) GetAllForUser(userID int64, unreadOnly bool, filters Filters) ([]*Notification, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s, id, user_id, category, subject, body, created_at, read_at
+FROM notifications
+WHERE user_id = $1 AND (read_at IS NULL OR NOT $2)
+AND %s
+ORDER BY %s LIMIT $5 OFFSET $6`, filters.cursorColumn(), filters.keyset(3), filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{userID, unreadOnly}
+	args = append(args, filters.keysetArgs()...)
+	args = append(args, filters.limit(), filters.offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	notifications := []*Notification{}
+	keys := []cursorKey{}
+
+	for rows.Next() {
+		var n Notification
+		var key cursorKey
+		err = rows.Scan(&totalRecords, &key.value, &n.ID, &n.UserID, &n.Category, &n.Subject, &n.Body, &n.CreatedAt, &n.ReadAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		key.id = n.ID
+		notifications = append(notifications, &n)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	notifications, metadata := paginate(notifications, keys, totalRecords, filters)
+
+	return notifications, metadata, nil
+}
+
+// ExportForUser returns the whole inbox of the user, for the personal data export.
+func (m NotificationModel) ExportForUser(userID int64) ([]*Notification, error) {
+	query := `
+SELECT id, user_id, category, subject, body, created_at, read_at
+FROM notifications
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*Notification{}
+	for rows.Next() {
+		var n Notification
+		err = rows.Scan(&n.ID, &n.UserID, &n.Category, &n.Subject, &n.Body, &n.CreatedAt, &n.ReadAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, &n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// MarkRead marks one notification of the user as read. Marking an already read
+// notification again is not an error.
+func (m NotificationModel) MarkRead(userID, id int64) error {
+	query := `UPDATE notifications SET read_at = coalesce(read_at, now()) WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// MarkAllRead marks every unread notification of the user as read.
+func (m NotificationModel) MarkAllRead(userID int64) (int64, error) {
+	query := `UPDATE notifications SET read_at = now() WHERE user_id = $1 AND read_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetPreferences returns the user's channel for every category, falling back to the
+// category default where the user hasn't chosen one.
+func (m NotificationModel) GetPreferences(userID int64) ([]*NotificationPreference, error) {
+	query := `SELECT category, channel FROM notification_preferences WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chosen := make(map[string]string)
+	for rows.Next() {
+		var category, channel string
+		err = rows.Scan(&category, &channel)
+		if err != nil {
+			return nil, err
+		}
+		chosen[category] = channel
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	prefs := []*NotificationPreference{}
+	for _, c := range NotificationCategories {
+		pref := &NotificationPreference{Category: c.Name, Channel: c.DefaultChannel, Mandatory: c.Mandatory}
+		if channel, ok := chosen[c.Name]; ok && !c.Mandatory {
+			pref.Channel = channel
+		}
+		prefs = append(prefs, pref)
+	}
+
+	return prefs, nil
+}
+
+// GetChannel returns the channel the user wants for a category.
+func (m NotificationModel) GetChannel(userID int64, category string) (string, error) {
+	c, ok := LookupCategory(category)
+	if !ok {
+		return "", fmt.Errorf("unknown notification category %q", category)
+	}
+	if c.Mandatory {
+		return ChannelEmail, nil
+	}
+
+	query := `SELECT channel FROM notification_preferences WHERE user_id = $1 AND category = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var channel string
+	err := m.DB.QueryRowContext(ctx, query, userID, category).Scan(&channel)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return c.DefaultChannel, nil
+		default:
+			return "", err
+		}
+	}
+	return channel, nil
+}
+
+func (m NotificationModel) SetPreference(userID int64, pref *NotificationPreference) error {
+	query := `
+INSERT INTO notification_preferences (user_id, category, channel)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, category) DO UPDATE SET channel = EXCLUDED.channel, updated_at = now()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pref.Category, pref.Channel)
+	return err
+}
@@ -0,0 +1,444 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"time"
+)
+
+var (
+	ErrDuplicateOffering = errors.New("module is already offered in the term")
+
+	// ErrOfferingOutsideTerm is returned when an offering's dates don't fall within
+	// its term.
+	ErrOfferingOutsideTerm = errors.New("offering dates are outside of the term")
+)
+
+// Offering is a module running in an academic term, with its own teacher and
+// capacity. A module is offered at most once per term. ModuleName is the name of
+// the module's version in effect, or of its latest version for admins. Enrollment
+// is open until EnrollmentDeadline, or until the offering starts if it is nil.
+// Final grades are given on the grade scale GradeScaleID, or on the default one
+// if it is nil, and can only be changed by admins from GradesLockAt on.
+type Offering struct {
+	ID                 int64      `json:"id"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	UpdatedAt          time.Time  `json:"updatedAt"`
+	ModuleID           int64      `json:"moduleId"`
+	ModuleName         string     `json:"moduleName"`
+	ExamType           string     `json:"examType"`
+	TermID             int64      `json:"termId"`
+	TeacherID          *int64     `json:"teacherId"`
+	Capacity           int        `json:"capacity"`
+	Enrolled           int        `json:"enrolled"`
+	Waitlisted         int        `json:"waitlisted"`
+	StartsOn           Date       `json:"startsOn"`
+	EndsOn             Date       `json:"endsOn"`
+	EnrollmentDeadline *time.Time `json:"enrollmentDeadline"`
+	Schedule           string     `json:"schedule"`
+	GradeScaleID       *int64     `json:"gradeScaleId"`
+	GradesLockAt       *time.Time `json:"gradesLockAt"`
+	Version            int        `json:"version"`
+}
+
+// EnrollmentCloses returns the time enrollment in the offering closes.
+func (o *Offering) EnrollmentCloses() time.Time {
+	if o.EnrollmentDeadline != nil {
+		return *o.EnrollmentDeadline
+	}
+	return o.StartsOn.Time
+}
+
+// GradesLocked reports whether the offering's grades are locked at time t.
+func (o *Offering) GradesLocked(t time.Time) bool {
+	return o.GradesLockAt != nil && !t.Before(*o.GradesLockAt)
+}
+
+// ValidateOffering checks the offering on its own. That its dates fall within its
+// term is checked against the term when it is written.
+func ValidateOffering(v *validator.Validator, offering *Offering) {
+	v.Check(offering.ModuleID > 0, "moduleId", "must be a positive module id")
+	v.Check(offering.TermID > 0, "termId", "must be a positive term id")
+	if offering.TeacherID != nil {
+		v.Check(*offering.TeacherID > 0, "teacherId", "must be a positive user id")
+	}
+
+	v.Check(offering.Capacity > 0, "capacity", "must be greater than zero")
+	v.Check(offering.Capacity <= 10_000, "capacity", "must not be more than 10000")
+
+	v.Check(!offering.EndsOn.Before(offering.StartsOn.Time), "endsOn", "must not be before startsOn")
+	if offering.EnrollmentDeadline != nil && !offering.EndsOn.IsZero() {
+		v.Check(offering.EnrollmentDeadline.Before(offering.EndsOn.AddDate(0, 0, 1)), "enrollmentDeadline", "must not be after the offering ends")
+	}
+	v.Check(len(offering.Schedule) <= 1000, "schedule", "must not be more than 1000 bytes long")
+
+	if offering.GradeScaleID != nil {
+		v.Check(*offering.GradeScaleID > 0, "gradeScaleId", "must be a positive grade scale id")
+	}
+	if offering.GradesLockAt != nil && !offering.StartsOn.IsZero() {
+		v.Check(!offering.GradesLockAt.Before(offering.StartsOn.Time), "gradesLockAt", "must not be before the offering starts")
+	}
+}
+
+type OfferingModel struct {
+	DB *sql.DB
+}
+
+func isDuplicateOffering(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Constraint == "module_offerings_module_id_term_id_key"
+}
+
+// checkTerm locks the offering's term against changes until tx ends and checks
+// that the offering's dates fall within it.
+func checkTerm(ctx context.Context, tx *sql.Tx, offering *Offering) error {
+	query := `SELECT starts_on, ends_on FROM academic_terms WHERE id = $1 FOR SHARE`
+
+	var startsOn, endsOn Date
+	err := tx.QueryRowContext(ctx, query, offering.TermID).Scan(&startsOn, &endsOn)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrInvalidReference
+		default:
+			return err
+		}
+	}
+
+	if offering.StartsOn.Before(startsOn.Time) || offering.EndsOn.After(endsOn.Time) {
+		return ErrOfferingOutsideTerm
+	}
+	return nil
+}
+
+// Insert adds the offering. Its module, term and teacher must exist, otherwise
+// ErrInvalidReference is returned, and its dates must fall within the term.
+func (m OfferingModel) Insert(offering *Offering) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkTerm(ctx, tx, offering)
+	if err != nil {
+		return err
+	}
+
+	query := `
+INSERT INTO module_offerings (module_id, term_id, teacher_id, capacity, starts_on, ends_on, enrollment_deadline, schedule,
+                              grade_scale_id, grades_lock_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id, created_at, updated_at, version`
+
+	args := []any{
+		offering.ModuleID,
+		offering.TermID,
+		offering.TeacherID,
+		offering.Capacity,
+		offering.StartsOn,
+		offering.EndsOn,
+		offering.EnrollmentDeadline,
+		offering.Schedule,
+		offering.GradeScaleID,
+		offering.GradesLockAt,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
+		&offering.ID,
+		&offering.CreatedAt,
+		&offering.UpdatedAt,
+		&offering.Version,
+	)
+	if err != nil {
+		switch {
+		case isDuplicateOffering(err):
+			return ErrDuplicateOffering
+		case isForeignKeyViolation(err):
+			return ErrInvalidReference
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// offeringColumns selects an offering from module_offerings joined with the
+// module_info it belongs to.
+const offeringColumns = `
+module_offerings.id, module_offerings.created_at, module_offerings.updated_at, module_offerings.module_id,
+module_info.module_name, module_info.exam_type, module_offerings.term_id, module_offerings.teacher_id,
+module_offerings.capacity, (SELECT count(*) FROM enrollments WHERE offering_id = module_offerings.id AND status = 'enrolled') AS enrolled,
+(SELECT count(*) FROM enrollments WHERE offering_id = module_offerings.id AND status = 'waitlisted') AS waitlisted,
+module_offerings.starts_on, module_offerings.ends_on, module_offerings.enrollment_deadline,
+module_offerings.schedule, module_offerings.grade_scale_id, module_offerings.grades_lock_at, module_offerings.version`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanOffering scans a row of offeringColumns into offering, after any dest.
+func scanOffering(row rowScanner, offering *Offering, dest ...any) error {
+	return row.Scan(append(dest,
+		&offering.ID,
+		&offering.CreatedAt,
+		&offering.UpdatedAt,
+		&offering.ModuleID,
+		&offering.ModuleName,
+		&offering.ExamType,
+		&offering.TermID,
+		&offering.TeacherID,
+		&offering.Capacity,
+		&offering.Enrolled,
+		&offering.Waitlisted,
+		&offering.StartsOn,
+		&offering.EndsOn,
+		&offering.EnrollmentDeadline,
+		&offering.Schedule,
+		&offering.GradeScaleID,
+		&offering.GradesLockAt,
+		&offering.Version,
+	)...)
+}
+
+func (m OfferingModel) Get(id int64) (*Offering, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + offeringColumns + `
+FROM module_offerings
+INNER JOIN module_info ON module_info.id = module_offerings.module_id
+WHERE module_offerings.id = $1`
+
+	var offering Offering
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanOffering(m.DB.QueryRowContext(ctx, query, id), &offering)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &offering, nil
+}
+
+// GetAll lists offerings, filtered by term, module and teacher where those ids are
+// not zero. With publishedOnly only the offerings of modules in effect are listed,
+// under the name registered users see.
+func (m OfferingModel) GetAll(termID, moduleID, teacherID int64, publishedOnly bool, filters Filters) ([]*Offering, Metadata, error) {
+	source := "module_info"
+	if publishedOnly {
+		source = publishedModules
+	}
+
+	// The list is read from a derived table so that the sort columns and the id
+	// used by the keyset are unambiguous.
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s, id, created_at, updated_at, module_id, module_name, exam_type, term_id, teacher_id,
+       capacity, enrolled, waitlisted, starts_on, ends_on, enrollment_deadline, schedule, grade_scale_id,
+       grades_lock_at, version
+FROM (
+    SELECT %s
+    FROM module_offerings
+    INNER JOIN %s ON module_info.id = module_offerings.module_id
+    WHERE (module_offerings.term_id = $1 OR $1 = 0)
+    AND (module_offerings.module_id = $2 OR $2 = 0)
+    AND (module_offerings.teacher_id = $3 OR $3 = 0)
+) AS module_offerings
+WHERE %s
+ORDER BY %s LIMIT $6 OFFSET $7`, filters.cursorColumn(), offeringColumns, source, filters.keyset(4), filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{termID, moduleID, teacherID}
+	args = append(args, filters.keysetArgs()...)
+	args = append(args, filters.limit(), filters.offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	offerings := []*Offering{}
+	keys := []cursorKey{}
+
+	for rows.Next() {
+		var offering Offering
+		var key cursorKey
+		err := scanOffering(rows, &offering, &totalRecords, &key.value)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		key.id = offering.ID
+		offerings = append(offerings, &offering)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	offerings, metadata := paginate(offerings, keys, totalRecords, filters)
+
+	return offerings, metadata, nil
+}
+
+// GetForTimetable returns the offerings whose sessions the filter picks, or would
+// pick if they had any, ordered by their first day.
+func (m OfferingModel) GetForTimetable(f TimetableFilter) ([]*Offering, error) {
+	source := "module_info"
+	if f.PublishedOnly {
+		source = publishedModules
+	}
+
+	query := fmt.Sprintf(`
+SELECT %s
+FROM module_offerings
+INNER JOIN %s ON module_info.id = module_offerings.module_id
+WHERE (module_offerings.id = $1 OR $1 = 0)
+AND (module_offerings.module_id = $2 OR $2 = 0)
+AND (module_offerings.term_id = $3 OR ($3 = 0 AND ($1 <> 0 OR module_offerings.ends_on >= current_date)))
+AND ($4 = 0 OR EXISTS (SELECT 1 FROM module_sessions WHERE offering_id = module_offerings.id AND teacher_id = $4))
+AND ($5 = '' OR EXISTS (SELECT 1 FROM module_sessions WHERE offering_id = module_offerings.id AND lower(room) = lower($5)))
+AND ($6 = 0 OR %s)
+ORDER BY module_offerings.starts_on, module_offerings.id`, offeringColumns, source, attendsOffering("$6"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.OfferingID, f.ModuleID, f.TermID, f.TeacherID, f.Room, f.AttendeeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offerings := []*Offering{}
+	for rows.Next() {
+		var offering Offering
+		err = scanOffering(rows, &offering)
+		if err != nil {
+			return nil, err
+		}
+		offerings = append(offerings, &offering)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return offerings, nil
+}
+
+// attendsOffering returns the condition that the user with the id in the given
+// parameter teaches the offering, or one of its sessions, or is enrolled in it.
+func attendsOffering(param string) string {
+	return `(module_offerings.teacher_id = ` + param + `
+    OR EXISTS (SELECT 1 FROM module_sessions WHERE offering_id = module_offerings.id AND teacher_id = ` + param + `)
+    OR EXISTS (SELECT 1 FROM enrollments WHERE offering_id = module_offerings.id AND user_id = ` + param + ` AND status = 'enrolled'))`
+}
+
+// Update saves the offering unless it changed since it was read, which yields
+// ErrEditConflict. Like Insert it checks the references and the term's dates, and
+// new dates must not make its sessions clash with others, which yields a
+// *SessionConflictError.
+func (m OfferingModel) Update(offering *Offering) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkTerm(ctx, tx, offering)
+	if err != nil {
+		return err
+	}
+
+	err = checkOfferingSessions(ctx, tx, offering.ID, offering.StartsOn, offering.EndsOn)
+	if err != nil {
+		return err
+	}
+
+	query := `
+UPDATE module_offerings
+SET module_id = $1, term_id = $2, teacher_id = $3, capacity = $4, starts_on = $5, ends_on = $6,
+    enrollment_deadline = $7, schedule = $8, grade_scale_id = $9, grades_lock_at = $10, updated_at = now(),
+    version = version + 1
+WHERE id = $11 AND version = $12
+RETURNING updated_at, version`
+
+	args := []any{
+		offering.ModuleID,
+		offering.TermID,
+		offering.TeacherID,
+		offering.Capacity,
+		offering.StartsOn,
+		offering.EndsOn,
+		offering.EnrollmentDeadline,
+		offering.Schedule,
+		offering.GradeScaleID,
+		offering.GradesLockAt,
+		offering.ID,
+		offering.Version,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&offering.UpdatedAt, &offering.Version)
+	if err != nil {
+		switch {
+		case isDuplicateOffering(err):
+			return ErrDuplicateOffering
+		case isForeignKeyViolation(err):
+			return ErrInvalidReference
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m OfferingModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM module_offerings WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
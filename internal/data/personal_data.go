@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PersonalData is everything stored about a single user, as handed out for a
+// data-subject access request.
+type PersonalData struct {
+	User          *UserAdminView            `json:"user"`
+	Tokens        []*TokenMetadata          `json:"tokens"`
+	Departments   []*DepartmentInfo         `json:"departments"`
+	Notifications []*Notification           `json:"notifications"`
+	Preferences   []*NotificationPreference `json:"notificationPreferences"`
+	AccountEvents []*AccountEvent           `json:"accountEvents"`
+	Groups        []*GroupMembership        `json:"groups"`
+	Enrollments   []*Enrollment             `json:"enrollments"`
+	Scores        []*ScoreRecord            `json:"scores"`
+	Overrides     []*GradeOverride          `json:"gradeOverrides"`
+	Transcript    *Transcript               `json:"transcript"`
+	Verifications []*TranscriptVerification `json:"transcriptVerifications"`
+}
+
+// TokenMetadata describes a token without exposing its hash.
+type TokenMetadata struct {
+	Scope  string    `json:"scope"`
+	Expiry time.Time `json:"expiry"`
+}
+
+type PersonalDataModel struct {
+	DB    *sql.DB
+	Users UserInfoModel
+}
+
+// Export collects the user row, the metadata of their tokens (the calendar feed
+// among them), the departments that name them as director, their notifications and
+// preferences, their account history, the groups they belong to, their
+// enrollments, their scores and the overrides made to them, their transcript with
+// every module completion on it, and the verification codes issued for it.
+func (m PersonalDataModel) Export(userID int64) (*PersonalData, error) {
+	user, err := m.Users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	pd := &PersonalData{
+		User:        user.AdminView(),
+		Tokens:      []*TokenMetadata{},
+		Departments: []*DepartmentInfo{},
+	}
+
+	notifications := NotificationModel{DB: m.DB}
+
+	pd.Notifications, err = notifications.ExportForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pd.Preferences, err = notifications.GetPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pd.AccountEvents, err = m.Users.GetAccountEvents(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pd.Groups, err = GroupModel{DB: m.DB}.ExportForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pd.Enrollments, err = EnrollmentModel{DB: m.DB}.GetForUser(userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	grades := GradeModel{DB: m.DB}
+
+	pd.Scores, err = grades.ExportScoresForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pd.Overrides, err = grades.ExportOverridesForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	transcripts := TranscriptModel{DB: m.DB}
+
+	pd.Transcript, err = transcripts.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	pd.Transcript.Name = user.Name
+	pd.Transcript.Surname = user.Surname
+
+	pd.Verifications, err = transcripts.GetVerificationsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT scope, expiry FROM tokens WHERE user_id = $1 ORDER BY expiry`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t TokenMetadata
+		err = rows.Scan(&t.Scope, &t.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		pd.Tokens = append(pd.Tokens, &t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query := `
+SELECT id, department_name, department_director, staff_quantity, module_id, version
+FROM department_info
+WHERE LOWER(department_director) IN (LOWER($1), LOWER($2))
+ORDER BY id`
+
+	rows, err = m.DB.QueryContext(ctx, query, fullName(user), user.Email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var info DepartmentInfo
+		err = rows.Scan(
+			&info.ID,
+			&info.DepartmentName,
+			&info.DepartmentDirector,
+			&info.StaffQuantity,
+			&info.ModuleID,
+			&info.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		pd.Departments = append(pd.Departments, &info)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pd, nil
+}
+
+// Erase anonymises the user_info row instead of deleting it, so rows referencing
+// the user stay valid. Tokens (the calendar feed among them), the avatar record,
+// notifications, group memberships and transcript verification codes are deleted
+// and the user's name is removed from departments they direct. Enrollments,
+// scores, grade overrides and module completions are kept as part of the academic
+// record, pointing at the anonymised row. The account status history and the
+// grade overrides are kept as the audit trails they are, with only their
+// free-text reasons scrubbed, and the erasure is added to the history with
+// actorID as the one who asked for it. Everything runs in one transaction. The
+// placeholder values are stored unsealed since they carry no personal data. The
+// avatar files themselves are removed by the caller.
+func (m PersonalDataModel) Erase(userID, actorID int64) error {
+	user, err := m.Users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// DepartmentInfo.DepartmentDirector is a plain string, so the name is blanked
+	// rather than set to NULL.
+	query := `
+UPDATE department_info SET department_director = '', version = version + 1
+WHERE LOWER(department_director) IN (LOWER($1), LOWER($2))`
+
+	_, err = tx.ExecContext(ctx, query, fullName(user), user.Email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM user_avatars WHERE user_id = $1`,
+		`DELETE FROM notifications WHERE user_id = $1`,
+		`DELETE FROM notification_preferences WHERE user_id = $1`,
+		`DELETE FROM group_members WHERE user_id = $1`,
+		`UPDATE account_events SET reason = '' WHERE user_id = $1`,
+		`UPDATE grade_overrides SET reason = '' WHERE user_id = $1`,
+		`DELETE FROM transcript_verifications WHERE user_id = $1`,
+	} {
+		_, err = tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// An empty password hash never matches bcrypt, so the account can't log in again.
+	query = `
+UPDATE user_info
+SET name = 'Erased', surname = 'User', email = $1, password_hash = '\x', activated = false,
+    data_key = NULL, email_index = NULL, name_index = NULL, surname_index = NULL,
+    search_words = NULL, search_trigrams = NULL, suspension_reason = '', expiry_warned_at = NULL,
+    erased_at = now(), updated_at = now(), version = version + 1
+WHERE id = $2`
+
+	result, err := tx.ExecContext(ctx, query, fmt.Sprintf("erased-%d@invalid", userID), userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO account_events (user_id, actor_id, action) VALUES ($1, $2, $3)`, userID, actorID, ActionErased)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func fullName(user *UserInfo) string {
+	if user.Surname == "" {
+		return user.Name
+	}
+	return user.Name + " " + user.Surname
+}
@@ -0,0 +1,371 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrerequisiteCycleError is returned when adding a prerequisite would make a module
+// depend on itself. Path lists the module ids of the cycle, starting and ending
+// with the same module.
+type PrerequisiteCycleError struct {
+	Path []int64
+}
+
+func (e *PrerequisiteCycleError) Error() string {
+	ids := make([]string, len(e.Path))
+	for i, id := range e.Path {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	return "would create a prerequisite cycle: " + strings.Join(ids, " -> ")
+}
+
+// PrerequisiteEdge says that ModuleID can only be taken after PrerequisiteID.
+type PrerequisiteEdge struct {
+	ModuleID       int64 `json:"moduleId"`
+	PrerequisiteID int64 `json:"prerequisiteId"`
+}
+
+// Prerequisite is a module that has to be completed before another one. Depth is 1
+// for direct prerequisites and counts the shortest chain for transitive ones.
+type Prerequisite struct {
+	*ModuleInfo
+	Depth int `json:"depth"`
+}
+
+// PlannedModule is a module in a recommended order. Modules of the same level
+// don't depend on each other and can be taken side by side. Requested is false for
+// prerequisites that were added because a requested module needs them.
+type PlannedModule struct {
+	*ModuleInfo
+	Level     int  `json:"level"`
+	Requested bool `json:"requested"`
+}
+
+type ModulePrerequisiteModel struct {
+	DB *sql.DB
+}
+
+// Add makes the modules in prerequisiteIDs prerequisites of moduleID and returns
+// how many were new. The table is locked for the duration so that two concurrent
+// additions can't close a cycle together. Unknown ids yield ErrInvalidReference, a
+// cycle a *PrerequisiteCycleError; in both cases nothing is added.
+func (m ModulePrerequisiteModel) Add(moduleID int64, prerequisiteIDs []int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `LOCK TABLE module_prerequisites IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT module_id, prerequisite_id FROM module_prerequisites`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	graph := make(map[int64][]int64)
+	for rows.Next() {
+		var edge PrerequisiteEdge
+		err = rows.Scan(&edge.ModuleID, &edge.PrerequisiteID)
+		if err != nil {
+			return 0, err
+		}
+		graph[edge.ModuleID] = append(graph[edge.ModuleID], edge.PrerequisiteID)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range prerequisiteIDs {
+		if path := prerequisitePath(graph, id, moduleID); path != nil {
+			return 0, &PrerequisiteCycleError{Path: append([]int64{moduleID}, path...)}
+		}
+		graph[moduleID] = append(graph[moduleID], id)
+	}
+
+	query := `
+INSERT INTO module_prerequisites (module_id, prerequisite_id)
+SELECT $1, unnest($2::bigint[])
+ON CONFLICT DO NOTHING`
+
+	result, err := tx.ExecContext(ctx, query, moduleID, pq.Array(prerequisiteIDs))
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return 0, ErrInvalidReference
+		default:
+			return 0, err
+		}
+	}
+
+	added, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return added, tx.Commit()
+}
+
+// prerequisitePath returns a chain of prerequisites leading from one module to
+// another, both included, or nil if to isn't reachable from from.
+func prerequisitePath(graph map[int64][]int64, from, to int64) []int64 {
+	parent := map[int64]int64{from: from}
+	queue := []int64{from}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		if id == to {
+			path := []int64{id}
+			for id != from {
+				id = parent[id]
+				path = append([]int64{id}, path...)
+			}
+			return path
+		}
+
+		for _, next := range graph[id] {
+			if _, seen := parent[next]; !seen {
+				parent[next] = id
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
+
+// Remove drops the given prerequisites of moduleID and returns how many existed.
+func (m ModulePrerequisiteModel) Remove(moduleID int64, prerequisiteIDs []int64) (int64, error) {
+	query := `DELETE FROM module_prerequisites WHERE module_id = $1 AND prerequisite_id = ANY($2::bigint[])`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, moduleID, pq.Array(prerequisiteIDs))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetAll returns the published prerequisites of the module, ordered by depth. Without
+// transitive only the direct ones are returned.
+func (m ModulePrerequisiteModel) GetAll(moduleID int64, transitive bool) ([]*Prerequisite, error) {
+	query := `
+WITH RECURSIVE prerequisites (id, depth) AS (
+    SELECT prerequisite_id, 1 FROM module_prerequisites WHERE module_id = $1
+    UNION
+    SELECT module_prerequisites.prerequisite_id, prerequisites.depth + 1
+    FROM module_prerequisites
+    INNER JOIN prerequisites ON module_prerequisites.module_id = prerequisites.id
+    WHERE $2
+)
+SELECT module_info.id, module_info.created_at, module_info.updated_at, module_info.module_name,
+       module_info.contact_hours, module_info.self_study_hours, module_info.ects_credits, module_info.exam_type, module_info.version, min(prerequisites.depth) AS depth
+FROM prerequisites
+INNER JOIN ` + publishedModules + ` ON module_info.id = prerequisites.id
+GROUP BY module_info.id, module_info.created_at, module_info.updated_at, module_info.module_name,
+         module_info.contact_hours, module_info.self_study_hours, module_info.ects_credits, module_info.exam_type, module_info.version
+ORDER BY depth, module_info.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, moduleID, transitive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prerequisites := []*Prerequisite{}
+	for rows.Next() {
+		p := Prerequisite{ModuleInfo: &ModuleInfo{}}
+		err = rows.Scan(
+			&p.ID,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&p.ModuleName,
+			&p.ContactHours,
+			&p.SelfStudyHours,
+			&p.ECTSCredits,
+			&p.ExamType,
+			&p.Version,
+			&p.Depth,
+		)
+		if err != nil {
+			return nil, err
+		}
+		prerequisites = append(prerequisites, &p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return prerequisites, nil
+}
+
+// GetPlan returns the requested modules together with all their transitive
+// prerequisites in a recommended order, and the prerequisite edges between them.
+// Only published modules are planned; other ids yield ErrInvalidReference.
+func (m ModulePrerequisiteModel) GetPlan(moduleIDs []int64) ([]*PlannedModule, []PrerequisiteEdge, error) {
+	query := `
+WITH RECURSIVE closure (id) AS (
+    SELECT unnest($1::bigint[])
+    UNION
+    SELECT module_prerequisites.prerequisite_id
+    FROM module_prerequisites
+    INNER JOIN closure ON module_prerequisites.module_id = closure.id
+)
+SELECT module_info.id, module_info.created_at, module_info.updated_at, module_info.module_name,
+       module_info.contact_hours, module_info.self_study_hours, module_info.ects_credits, module_info.exam_type, module_info.version
+FROM closure
+INNER JOIN ` + publishedModules + ` ON module_info.id = closure.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(moduleIDs))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	requested := make(map[int64]bool, len(moduleIDs))
+	for _, id := range moduleIDs {
+		requested[id] = true
+	}
+
+	modules := []*PlannedModule{}
+	ids := []int64{}
+	for rows.Next() {
+		pm := PlannedModule{ModuleInfo: &ModuleInfo{}}
+		err = rows.Scan(
+			&pm.ID,
+			&pm.CreatedAt,
+			&pm.UpdatedAt,
+			&pm.ModuleName,
+			&pm.ContactHours,
+			&pm.SelfStudyHours,
+			&pm.ECTSCredits,
+			&pm.ExamType,
+			&pm.Version,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		pm.Requested = requested[int64(pm.ID)]
+		if pm.Requested {
+			delete(requested, int64(pm.ID))
+		}
+		modules = append(modules, &pm)
+		ids = append(ids, int64(pm.ID))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(requested) > 0 {
+		return nil, nil, ErrInvalidReference
+	}
+
+	query = `
+SELECT module_id, prerequisite_id FROM module_prerequisites
+WHERE module_id = ANY($1::bigint[]) AND prerequisite_id = ANY($1::bigint[])
+ORDER BY module_id, prerequisite_id`
+
+	rows, err = m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	edges := []PrerequisiteEdge{}
+	for rows.Next() {
+		var edge PrerequisiteEdge
+		err = rows.Scan(&edge.ModuleID, &edge.PrerequisiteID)
+		if err != nil {
+			return nil, nil, err
+		}
+		edges = append(edges, edge)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	err = orderPlan(modules, edges)
+	if err != nil {
+		return nil, nil, err
+	}
+	return modules, edges, nil
+}
+
+// orderPlan sorts the modules topologically. A module's level is one more than the
+// highest level of its prerequisites, and modules are ordered by level and id so
+// that the order is stable.
+func orderPlan(modules []*PlannedModule, edges []PrerequisiteEdge) error {
+	byID := make(map[int64]*PlannedModule, len(modules))
+	for _, pm := range modules {
+		byID[int64(pm.ID)] = pm
+	}
+
+	dependants := make(map[int64][]int64)
+	pending := make(map[int64]int)
+	for _, edge := range edges {
+		dependants[edge.PrerequisiteID] = append(dependants[edge.PrerequisiteID], edge.ModuleID)
+		pending[edge.ModuleID]++
+	}
+
+	var ready []int64
+	for _, pm := range modules {
+		if pending[int64(pm.ID)] == 0 {
+			pm.Level = 1
+			ready = append(ready, int64(pm.ID))
+		}
+	}
+
+	done := 0
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		done++
+
+		for _, dependant := range dependants[id] {
+			if level := byID[id].Level + 1; level > byID[dependant].Level {
+				byID[dependant].Level = level
+			}
+			pending[dependant]--
+			if pending[dependant] == 0 {
+				ready = append(ready, dependant)
+			}
+		}
+	}
+
+	// The cycle check on insert keeps the graph acyclic, so this only happens if
+	// the table was edited by hand.
+	if done != len(modules) {
+		return fmt.Errorf("module prerequisites contain a cycle")
+	}
+
+	sort.Slice(modules, func(i, j int) bool {
+		if modules[i].Level != modules[j].Level {
+			return modules[i].Level < modules[j].Level
+		}
+		return modules[i].ID < modules[j].ID
+	})
+	return nil
+}
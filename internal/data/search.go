@@ -0,0 +1,162 @@
+package data
+
+import (
+	"encoding/hex"
+	"github.com/lib/pq"
+	"github.com/shynggys9219/greenlight/internal/fieldcrypt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// highlight wraps every word of text that matches one of the terms in q with <mark>
+// tags. A word matches when it contains the term, or, for terms of four or more
+// characters, when its prefix is within one edit of the term. The second rule
+// mirrors the typo tolerance of the pg_trgm lookup, so fuzzy hits are marked too.
+// The words come from user input and are HTML-escaped, so the <mark> tags are the
+// only markup in the result.
+func highlight(text, q string) string {
+	terms := strings.Fields(strings.ToLower(q))
+	words := strings.Fields(text)
+
+	for i, word := range words {
+		lower := strings.ToLower(word)
+		words[i] = html.EscapeString(word)
+		for _, term := range terms {
+			if termMatches(lower, term) {
+				words[i] = "<mark>" + words[i] + "</mark>"
+				break
+			}
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+func termMatches(word, term string) bool {
+	if strings.Contains(word, term) {
+		return true
+	}
+	if len(term) < 4 {
+		return false
+	}
+
+	for _, n := range []int{len(term) - 1, len(term), len(term) + 1} {
+		if n <= len(word) && editDistance(word[:n], term) <= 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// Sealed rows can't be searched by the database, so they carry blind tokens
+// instead: keyed hashes of their words and trigrams, which Search compares with the
+// tokens of the query. Only equality survives hashing, so a query word has to match
+// a whole word, and fuzzy matches are scored by the share of trigrams in common, as
+// pg_trgm's similarity does.
+
+// searchWords returns the lowercase words of text the blind search matches on:
+// every whitespace-separated word with punctuation trimmed from its ends, and the
+// runs of letters and digits within it, so that "john.smith@example.com" is found
+// both by the whole address and by "smith".
+func searchWords(text string) []string {
+	seen := map[string]bool{}
+	words := []string{}
+	add := func(word string) {
+		if word != "" && !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		add(strings.TrimFunc(word, isPunctuation))
+		for _, part := range strings.FieldsFunc(word, isPunctuation) {
+			add(part)
+		}
+	}
+	return words
+}
+
+// queryWords returns the words of a search query, all of which have to match.
+// Search operators such as quotes and a leading minus are not understood by the
+// blind search and are trimmed off like other punctuation.
+func queryWords(q string) []string {
+	seen := map[string]bool{}
+	words := []string{}
+	for _, word := range strings.Fields(strings.ToLower(q)) {
+		word = strings.TrimFunc(word, isPunctuation)
+		if word != "" && !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// trigrams returns the trigrams of text the way pg_trgm extracts them: every run
+// of letters and digits is lowercased and padded with two spaces in front and one
+// behind.
+func trigrams(text string) []string {
+	seen := map[string]bool{}
+	trigrams := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isPunctuation) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			trigram := string(padded[i : i+3])
+			if !seen[trigram] {
+				seen[trigram] = true
+				trigrams = append(trigrams, trigram)
+			}
+		}
+	}
+	return trigrams
+}
+
+func isPunctuation(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// blindTokens hashes values with keys for storage in a TEXT[] column. The hashes
+// are cut to 8 bytes, which keeps the arrays small and collisions rare enough for
+// a search filter. Without keys there are no tokens and the column stays NULL.
+func blindTokens(keys *fieldcrypt.Keyring, domain string, values []string) pq.StringArray {
+	if keys == nil || len(values) == 0 {
+		return nil
+	}
+
+	tokens := make(pq.StringArray, len(values))
+	for i, value := range values {
+		tokens[i] = hex.EncodeToString(keys.BlindToken(domain, value)[:8])
+	}
+	return tokens
+}
@@ -0,0 +1,461 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ClockRX = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+
+	// Weekdays are the days sessions are held on, in ISO order from Monday.
+	Weekdays = []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+)
+
+// Session is a weekly class of a module offering, held on the same day, at the
+// same time and in the same room every week from the offering's first to its last
+// day, which are StartsOn and EndsOn. Times are written as HH:MM.
+type Session struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	OfferingID int64     `json:"offeringId"`
+	ModuleID   int64     `json:"moduleId"`
+	ModuleName string    `json:"moduleName"`
+	TermID     int64     `json:"termId"`
+	Day        string    `json:"day"`
+	StartsAt   string    `json:"startsAt"`
+	EndsAt     string    `json:"endsAt"`
+	Room       string    `json:"room"`
+	TeacherID  *int64    `json:"teacherId"`
+	StartsOn   Date      `json:"startsOn"`
+	EndsOn     Date      `json:"endsOn"`
+	Version    int       `json:"version"`
+}
+
+// dayNumber returns the ISO number of the session's day, 1 for Monday.
+func (s *Session) dayNumber() int {
+	for i, day := range Weekdays {
+		if day == s.Day {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func ValidateSession(v *validator.Validator, session *Session) {
+	v.Check(validator.PermittedValue(session.Day, Weekdays...), "day", "must be a day of the week, such as monday")
+
+	v.Check(validator.Matches(session.StartsAt, ClockRX), "startsAt", "must be a time written as HH:MM")
+	v.Check(validator.Matches(session.EndsAt, ClockRX), "endsAt", "must be a time written as HH:MM")
+	// Times in HH:MM sort as strings.
+	v.Check(session.EndsAt > session.StartsAt, "endsAt", "must be after startsAt")
+
+	session.Room = strings.TrimSpace(session.Room)
+	v.Check(session.Room != "", "room", "must be provided")
+	v.Check(len(session.Room) <= 50, "room", "must not be more than 50 bytes long")
+
+	if session.TeacherID != nil {
+		v.Check(*session.TeacherID > 0, "teacherId", "must be a positive user id")
+	}
+}
+
+// SessionConflictError is returned when a session would be held in the same room,
+// or by the same teacher, at the same time as other sessions.
+type SessionConflictError struct {
+	Conflicts []*Session
+}
+
+func (e *SessionConflictError) Error() string {
+	ids := make([]string, len(e.Conflicts))
+	for i, conflict := range e.Conflicts {
+		ids[i] = strconv.FormatInt(conflict.ID, 10)
+	}
+	return "session conflicts with sessions " + strings.Join(ids, ", ")
+}
+
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// sessionQuery selects sessions with their offering's module, term and dates.
+// Conditions on the sessions are added to it.
+const sessionQuery = `
+SELECT module_sessions.id, module_sessions.created_at, module_sessions.updated_at, module_sessions.offering_id,
+       module_offerings.module_id, module_info.module_name, module_offerings.term_id, module_sessions.day_of_week,
+       to_char(module_sessions.starts_at, 'HH24:MI'), to_char(module_sessions.ends_at, 'HH24:MI'),
+       module_sessions.room, module_sessions.teacher_id, module_offerings.starts_on, module_offerings.ends_on,
+       module_sessions.version
+FROM module_sessions
+INNER JOIN module_offerings ON module_offerings.id = module_sessions.offering_id`
+
+func scanSession(row rowScanner, session *Session) error {
+	var day int
+	err := row.Scan(
+		&session.ID,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.OfferingID,
+		&session.ModuleID,
+		&session.ModuleName,
+		&session.TermID,
+		&day,
+		&session.StartsAt,
+		&session.EndsAt,
+		&session.Room,
+		&session.TeacherID,
+		&session.StartsOn,
+		&session.EndsOn,
+		&session.Version,
+	)
+	if err != nil {
+		return err
+	}
+	session.Day = Weekdays[day-1]
+	return nil
+}
+
+// rowsQuerier is implemented by both *sql.DB and *sql.Tx.
+type rowsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func querySessions(ctx context.Context, db rowsQuerier, query string, args ...any) ([]*Session, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err = scanSession(rows, &session)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// lockSchedules takes transaction-level advisory locks on the timetables of the
+// rooms and teachers, so that sessions booking any of them are written one at a
+// time. Rooms are locked before teachers, each in sorted order, so that two
+// transactions can't wait for each other.
+func lockSchedules(ctx context.Context, tx *sql.Tx, rooms []string, teacherIDs []int64) error {
+	keys := []string{}
+
+	sorted := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		sorted = append(sorted, strings.ToLower(room))
+	}
+	sort.Strings(sorted)
+	for _, room := range sorted {
+		keys = append(keys, "room:"+room)
+	}
+
+	sort.Slice(teacherIDs, func(i, j int) bool { return teacherIDs[i] < teacherIDs[j] })
+	for _, id := range teacherIDs {
+		keys = append(keys, "teacher:"+strconv.FormatInt(id, 10))
+	}
+
+	for _, key := range keys {
+		_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findConflicts returns the sessions held in the session's room or by its teacher
+// at an overlapping time on the same day, in offerings whose dates overlap
+// startsOn to endsOn. Sessions of the offering excludeOffering are ignored, as is
+// the session itself.
+func findConflicts(ctx context.Context, tx *sql.Tx, session *Session, startsOn, endsOn Date, excludeOffering int64) ([]*Session, error) {
+	query := sessionQuery + `
+INNER JOIN module_info ON module_info.id = module_offerings.module_id
+WHERE module_sessions.id <> $1 AND module_sessions.offering_id <> $2
+AND module_sessions.day_of_week = $3
+AND module_sessions.starts_at < $5::time AND module_sessions.ends_at > $4::time
+AND module_offerings.starts_on <= $7 AND module_offerings.ends_on >= $6
+AND (lower(module_sessions.room) = lower($8) OR module_sessions.teacher_id = $9)
+ORDER BY module_sessions.id`
+
+	args := []any{session.ID, excludeOffering, session.dayNumber(), session.StartsAt, session.EndsAt, startsOn, endsOn, session.Room, session.TeacherID}
+
+	return querySessions(ctx, tx, query, args...)
+}
+
+// checkSession locks the session's offering, room and teacher and returns a
+// *SessionConflictError if the session clashes with another one.
+func checkSession(ctx context.Context, tx *sql.Tx, session *Session) error {
+	query := `SELECT starts_on, ends_on FROM module_offerings WHERE id = $1 FOR SHARE`
+
+	var startsOn, endsOn Date
+	err := tx.QueryRowContext(ctx, query, session.OfferingID).Scan(&startsOn, &endsOn)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrInvalidReference
+		default:
+			return err
+		}
+	}
+
+	teacherIDs := []int64{}
+	if session.TeacherID != nil {
+		teacherIDs = append(teacherIDs, *session.TeacherID)
+	}
+
+	err = lockSchedules(ctx, tx, []string{session.Room}, teacherIDs)
+	if err != nil {
+		return err
+	}
+
+	conflicts, err := findConflicts(ctx, tx, session, startsOn, endsOn, 0)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return &SessionConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+// checkOfferingSessions returns a *SessionConflictError if moving the offering to
+// the dates startsOn to endsOn would make its sessions clash with others.
+func checkOfferingSessions(ctx context.Context, tx *sql.Tx, offeringID int64, startsOn, endsOn Date) error {
+	// Sessions being written lock their offering before the schedules, so the
+	// offering is locked first here too.
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM module_offerings WHERE id = $1 FOR UPDATE`, offeringID)
+	if err != nil {
+		return err
+	}
+
+	query := sessionQuery + `
+INNER JOIN module_info ON module_info.id = module_offerings.module_id
+WHERE module_sessions.offering_id = $1`
+
+	sessions, err := querySessions(ctx, tx, query, offeringID)
+	if err != nil || len(sessions) == 0 {
+		return err
+	}
+
+	rooms := []string{}
+	teacherIDs := []int64{}
+	for _, session := range sessions {
+		rooms = append(rooms, session.Room)
+		if session.TeacherID != nil {
+			teacherIDs = append(teacherIDs, *session.TeacherID)
+		}
+	}
+
+	err = lockSchedules(ctx, tx, rooms, teacherIDs)
+	if err != nil {
+		return err
+	}
+
+	conflicts := []*Session{}
+	for _, session := range sessions {
+		found, err := findConflicts(ctx, tx, session, startsOn, endsOn, offeringID)
+		if err != nil {
+			return err
+		}
+		conflicts = append(conflicts, found...)
+	}
+	if len(conflicts) > 0 {
+		return &SessionConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+// Insert adds the session unless it clashes with another session, which yields a
+// *SessionConflictError. Its offering and teacher must exist, otherwise
+// ErrInvalidReference is returned.
+func (m SessionModel) Insert(session *Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkSession(ctx, tx, session)
+	if err != nil {
+		return err
+	}
+
+	query := `
+INSERT INTO module_sessions (offering_id, day_of_week, starts_at, ends_at, room, teacher_id)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id`
+
+	args := []any{session.OfferingID, session.dayNumber(), session.StartsAt, session.EndsAt, session.Room, session.TeacherID}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&id)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrInvalidReference
+		default:
+			return err
+		}
+	}
+
+	saved, err := getSession(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	*session = *saved
+
+	return tx.Commit()
+}
+
+func getSession(ctx context.Context, db rowQuerier, id int64) (*Session, error) {
+	query := sessionQuery + `
+INNER JOIN module_info ON module_info.id = module_offerings.module_id
+WHERE module_sessions.id = $1`
+
+	var session Session
+	err := scanSession(db.QueryRowContext(ctx, query, id), &session)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &session, nil
+}
+
+func (m SessionModel) Get(id int64) (*Session, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getSession(ctx, m.DB, id)
+}
+
+// Update moves the session to another day, time, room or teacher unless it changed
+// since it was read, which yields ErrEditConflict, or clashes with another session.
+func (m SessionModel) Update(session *Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkSession(ctx, tx, session)
+	if err != nil {
+		return err
+	}
+
+	query := `
+UPDATE module_sessions
+SET day_of_week = $1, starts_at = $2, ends_at = $3, room = $4, teacher_id = $5, updated_at = now(), version = version + 1
+WHERE id = $6 AND version = $7
+RETURNING updated_at, version`
+
+	args := []any{session.dayNumber(), session.StartsAt, session.EndsAt, session.Room, session.TeacherID, session.ID, session.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&session.UpdatedAt, &session.Version)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrInvalidReference
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m SessionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM module_sessions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// TimetableFilter picks the sessions of a timetable. Zero fields don't filter.
+// Without a TermID or OfferingID only the sessions of offerings that haven't ended
+// are listed. AttendeeID picks the offerings the user teaches or is enrolled in.
+// With PublishedOnly only modules in effect are included, under the name
+// registered users see.
+type TimetableFilter struct {
+	OfferingID    int64
+	ModuleID      int64
+	TermID        int64
+	TeacherID     int64
+	Room          string
+	AttendeeID    int64
+	PublishedOnly bool
+}
+
+// GetTimetable returns the sessions picked by the filter in weekly order.
+func (m SessionModel) GetTimetable(f TimetableFilter) ([]*Session, error) {
+	source := "module_info"
+	if f.PublishedOnly {
+		source = publishedModules
+	}
+
+	query := sessionQuery + fmt.Sprintf(`
+INNER JOIN %s ON module_info.id = module_offerings.module_id
+WHERE (module_sessions.offering_id = $1 OR $1 = 0)
+AND (module_offerings.module_id = $2 OR $2 = 0)
+AND (module_offerings.term_id = $3 OR ($3 = 0 AND ($1 <> 0 OR module_offerings.ends_on >= current_date)))
+AND (module_sessions.teacher_id = $4 OR $4 = 0)
+AND (lower(module_sessions.room) = lower($5) OR $5 = '')
+AND ($6 = 0 OR %s)
+ORDER BY module_sessions.day_of_week, module_sessions.starts_at, module_sessions.room, module_sessions.id`, source, attendsOffering("$6"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return querySessions(ctx, m.DB, query, f.OfferingID, f.ModuleID, f.TermID, f.TeacherID, f.Room, f.AttendeeID)
+}
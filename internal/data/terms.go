@@ -0,0 +1,269 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/shynggys9219/greenlight/internal/validator"
+	"time"
+)
+
+var (
+	ErrDuplicateTerm = errors.New("duplicate term name for academic year")
+
+	// ErrTermInUse is returned when deleting a term that still has offerings.
+	ErrTermInUse = errors.New("term has module offerings")
+
+	// ErrOfferingsOutsideTerm is returned when changing the dates of a term would
+	// leave some of its offerings outside of it.
+	ErrOfferingsOutsideTerm = errors.New("term has module offerings outside of its dates")
+)
+
+// Term is an academic term such as the fall semester of an academic year. Module
+// offerings run within its dates.
+type Term struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	Name         string    `json:"name"`
+	AcademicYear string    `json:"academicYear"`
+	StartsOn     Date      `json:"startsOn"`
+	EndsOn       Date      `json:"endsOn"`
+	Version      int       `json:"version"`
+}
+
+func ValidateTerm(v *validator.Validator, term *Term) {
+	v.Check(term.Name != "", "name", "must be provided")
+	v.Check(len(term.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(term.AcademicYear != "", "academicYear", "must be provided")
+	v.Check(validator.Matches(term.AcademicYear, AcademicYearRX), "academicYear", "must look like 2024-2025")
+
+	v.Check(!term.StartsOn.IsZero(), "startsOn", "must be provided")
+	v.Check(!term.EndsOn.IsZero(), "endsOn", "must be provided")
+	v.Check(term.EndsOn.After(term.StartsOn.Time), "endsOn", "must be after startsOn")
+	v.Check(term.EndsOn.Sub(term.StartsOn.Time) <= 366*24*time.Hour, "endsOn", "must be at most a year after startsOn")
+}
+
+type TermModel struct {
+	DB *sql.DB
+}
+
+func isDuplicateTerm(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Constraint == "academic_terms_name_academic_year_key"
+}
+
+func (m TermModel) Insert(term *Term) error {
+	query := `
+INSERT INTO academic_terms (name, academic_year, starts_on, ends_on)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, term.Name, term.AcademicYear, term.StartsOn, term.EndsOn).Scan(
+		&term.ID,
+		&term.CreatedAt,
+		&term.UpdatedAt,
+		&term.Version,
+	)
+	if err != nil {
+		switch {
+		case isDuplicateTerm(err):
+			return ErrDuplicateTerm
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m TermModel) Get(id int64) (*Term, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT id, created_at, updated_at, name, academic_year, starts_on, ends_on, version
+FROM academic_terms WHERE id = $1`
+
+	var term Term
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&term.ID,
+		&term.CreatedAt,
+		&term.UpdatedAt,
+		&term.Name,
+		&term.AcademicYear,
+		&term.StartsOn,
+		&term.EndsOn,
+		&term.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &term, nil
+}
+
+// GetAll lists the terms of an academic year, or of every year if academicYear is
+// empty. A non-zero date limits the list to the terms running on that day.
+func (m TermModel) GetAll(academicYear string, on time.Time, filters Filters) ([]*Term, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s, id, created_at, updated_at, name, academic_year, starts_on, ends_on, version
+FROM academic_terms
+WHERE (academic_year = $1 OR $1 = '')
+AND ($2::date IS NULL OR $2::date BETWEEN starts_on AND ends_on)
+AND %s
+ORDER BY %s LIMIT $5 OFFSET $6`, filters.cursorColumn(), filters.keyset(3), filters.orderBy())
+
+	var day *Date
+	if !on.IsZero() {
+		d := NewDate(on)
+		day = &d
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{academicYear, day}
+	args = append(args, filters.keysetArgs()...)
+	args = append(args, filters.limit(), filters.offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	terms := []*Term{}
+	keys := []cursorKey{}
+
+	for rows.Next() {
+		var term Term
+		var key cursorKey
+		err := rows.Scan(
+			&totalRecords,
+			&key.value,
+			&term.ID,
+			&term.CreatedAt,
+			&term.UpdatedAt,
+			&term.Name,
+			&term.AcademicYear,
+			&term.StartsOn,
+			&term.EndsOn,
+			&term.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		key.id = term.ID
+		terms = append(terms, &term)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	terms, metadata := paginate(terms, keys, totalRecords, filters)
+
+	return terms, metadata, nil
+}
+
+// Update saves the term unless it changed since it was read, which yields
+// ErrEditConflict. The term's offerings must still fit within its new dates,
+// otherwise ErrOfferingsOutsideTerm is returned and nothing is changed.
+func (m TermModel) Update(term *Term) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+UPDATE academic_terms
+SET name = $1, academic_year = $2, starts_on = $3, ends_on = $4, updated_at = now(), version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING updated_at, version`
+
+	args := []any{term.Name, term.AcademicYear, term.StartsOn, term.EndsOn, term.ID, term.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&term.UpdatedAt, &term.Version)
+	if err != nil {
+		switch {
+		case isDuplicateTerm(err):
+			return ErrDuplicateTerm
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	// Offerings lock their term while they are written, so none can slip outside
+	// the new dates between this check and the commit.
+	query = `
+SELECT EXISTS (
+    SELECT 1 FROM module_offerings
+    WHERE term_id = $1 AND (starts_on < $2 OR ends_on > $3)
+)`
+
+	var outside bool
+	err = tx.QueryRowContext(ctx, query, term.ID, term.StartsOn, term.EndsOn).Scan(&outside)
+	if err != nil {
+		return err
+	}
+	if outside {
+		return ErrOfferingsOutsideTerm
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a term. Terms that still have offerings can't be deleted and
+// yield ErrTermInUse.
+func (m TermModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM academic_terms WHERE id = $1`, id)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrTermInUse
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
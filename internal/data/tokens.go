@@ -13,6 +13,9 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	// ScopeCalendar tokens are part of the URL of a user's calendar feed, which
+	// calendar clients fetch without an Authorization header.
+	ScopeCalendar = "calendar"
 )
 
 type Token struct {
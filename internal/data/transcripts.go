@@ -0,0 +1,364 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrNoGradeScale is returned when recording completions of an offering that has
+// no grade scale while no scale is the default either.
+var ErrNoGradeScale = errors.New("no grade scale applies to the offering")
+
+// ModuleCompletion records that a user completed a module in a term, with their
+// final grade and the credits the module was worth.
+type ModuleCompletion struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"userId"`
+	OfferingID *int64    `json:"offeringId"`
+	ModuleID   *int64    `json:"moduleId"`
+	ModuleName string    `json:"moduleName"`
+	TermID     int64     `json:"termId"`
+	Percent    float64   `json:"percent"`
+	Letter     string    `json:"letter"`
+	Points     float64   `json:"points"`
+	Credits    float64   `json:"credits"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
+// TranscriptTerm is a term of a transcript: the modules completed in it and their
+// credit-weighted GPA, nil when they are worth no credits.
+type TranscriptTerm struct {
+	TermID       int64               `json:"termId"`
+	Name         string              `json:"name"`
+	AcademicYear string              `json:"academicYear"`
+	StartsOn     Date                `json:"startsOn"`
+	Modules      []*ModuleCompletion `json:"modules"`
+	Credits      float64             `json:"credits"`
+	GPA          *float64            `json:"gpa"`
+}
+
+// Transcript is a user's academic record, term by term. The cumulative GPA and
+// credits count only the latest attempt at each module; credits are earned by
+// attempts worth more than 0 points.
+type Transcript struct {
+	UserID           int64             `json:"userId"`
+	Name             string            `json:"name"`
+	Surname          string            `json:"surname"`
+	Terms            []*TranscriptTerm `json:"terms"`
+	CreditsAttempted float64           `json:"creditsAttempted"`
+	CreditsEarned    float64           `json:"creditsEarned"`
+	GPA              *float64          `json:"gpa"`
+}
+
+// TranscriptVerification identifies an issued transcript. Only a hash of the
+// transcript is kept, so the code tells when it was issued and can check a
+// transcript someone presents, but can't show what it said.
+type TranscriptVerification struct {
+	Code     string    `json:"code"`
+	IssuedAt time.Time `json:"issuedAt"`
+
+	documentHash []byte
+}
+
+// transcriptHash returns the SHA-256 of the transcript as it is handed out in JSON.
+func transcriptHash(transcript *Transcript) ([]byte, error) {
+	document, err := json.Marshal(transcript)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(document)
+	return sum[:], nil
+}
+
+// Matches reports whether the transcript is the one that was issued with the code.
+func (v *TranscriptVerification) Matches(transcript *Transcript) (bool, error) {
+	sum, err := transcriptHash(transcript)
+	if err != nil {
+		return false, err
+	}
+
+	// Transcripts issued before the hash was stored only have their code, which
+	// is the start of the hash.
+	if v.documentHash == nil {
+		return subtle.ConstantTimeCompare([]byte(verificationCode(sum)), []byte(v.Code)) == 1, nil
+	}
+	return subtle.ConstantTimeCompare(sum, v.documentHash) == 1, nil
+}
+
+func verificationCode(sum []byte) string {
+	return strings.ToUpper(hex.EncodeToString(sum[:8]))
+}
+
+// FormatVerificationCode groups a verification code into blocks of four to make
+// it easier to read and type.
+func FormatVerificationCode(code string) string {
+	var blocks []string
+	for len(code) > 4 {
+		blocks = append(blocks, code[:4])
+		code = code[4:]
+	}
+	return strings.Join(append(blocks, code), "-")
+}
+
+// NormalizeVerificationCode undoes FormatVerificationCode and any change of case.
+func NormalizeVerificationCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// gpa returns the credit-weighted average points of the completions, rounded to
+// two decimals, or nil if they are worth no credits.
+func gpa(completions []*ModuleCompletion) (credits float64, average *float64) {
+	var weighted float64
+	for _, c := range completions {
+		weighted += c.Points * c.Credits
+		credits += c.Credits
+	}
+	if credits == 0 {
+		return 0, nil
+	}
+	value := math.Round(weighted/credits*100) / 100
+	return credits, &value
+}
+
+type TranscriptModel struct {
+	DB *sql.DB
+}
+
+// RecordCompletions records the final grades of the offering's students as
+// completed modules worth the given credits, replacing those recorded before.
+// Students whose grades are not complete yet are skipped and returned.
+func (m TranscriptModel) RecordCompletions(offeringID int64, credits float64, recordedBy int64) ([]*ModuleCompletion, []int64, error) {
+	reports, err := GradeModel{DB: m.DB}.GetReports(offeringID, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+INSERT INTO module_completions (user_id, offering_id, module_id, module_name, term_id, percent, letter, points, credits, recorded_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (offering_id, user_id)
+DO UPDATE SET module_name = EXCLUDED.module_name, percent = EXCLUDED.percent, letter = EXCLUDED.letter,
+              points = EXCLUDED.points, credits = EXCLUDED.credits, recorded_by = EXCLUDED.recorded_by, updated_at = now()
+RETURNING id, updated_at`
+
+	completions := []*ModuleCompletion{}
+	incomplete := []int64{}
+
+	for _, report := range reports {
+		if !report.Complete {
+			incomplete = append(incomplete, report.UserID)
+			continue
+		}
+		if report.Points == nil {
+			return nil, nil, ErrNoGradeScale
+		}
+
+		offeringID, moduleID := report.OfferingID, report.ModuleID
+		c := &ModuleCompletion{
+			UserID:     report.UserID,
+			OfferingID: &offeringID,
+			ModuleID:   &moduleID,
+			ModuleName: report.ModuleName,
+			TermID:     report.TermID,
+			Percent:    *report.Percent,
+			Letter:     report.Letter,
+			Points:     *report.Points,
+			Credits:    credits,
+		}
+
+		args := []any{c.UserID, c.OfferingID, c.ModuleID, c.ModuleName, c.TermID, c.Percent, c.Letter, c.Points, c.Credits, recordedBy}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&c.ID, &c.RecordedAt)
+		if err != nil {
+			switch {
+			case isForeignKeyViolation(err):
+				return nil, nil, ErrInvalidReference
+			default:
+				return nil, nil, err
+			}
+		}
+		completions = append(completions, c)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return completions, incomplete, nil
+}
+
+// Get returns the transcript of a user from their completed modules. Their name
+// is left for the caller to fill in.
+func (m TranscriptModel) Get(userID int64) (*Transcript, error) {
+	query := `
+SELECT module_completions.id, module_completions.user_id, module_completions.offering_id, module_completions.module_id,
+       module_completions.module_name, module_completions.term_id, academic_terms.name, academic_terms.academic_year,
+       academic_terms.starts_on, module_completions.percent, module_completions.letter, module_completions.points,
+       module_completions.credits, module_completions.updated_at
+FROM module_completions
+INNER JOIN academic_terms ON academic_terms.id = module_completions.term_id
+WHERE module_completions.user_id = $1
+ORDER BY academic_terms.starts_on, academic_terms.id, lower(module_completions.module_name), module_completions.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transcript := &Transcript{UserID: userID, Terms: []*TranscriptTerm{}}
+
+	var completions []*ModuleCompletion
+	// Terms are read in order, so a later attempt at a module replaces an earlier one.
+	latest := map[int64]*ModuleCompletion{}
+
+	for rows.Next() {
+		var c ModuleCompletion
+		var term TranscriptTerm
+		err = rows.Scan(&c.ID, &c.UserID, &c.OfferingID, &c.ModuleID, &c.ModuleName, &c.TermID, &term.Name, &term.AcademicYear,
+			&term.StartsOn, &c.Percent, &c.Letter, &c.Points, &c.Credits, &c.RecordedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(transcript.Terms); n == 0 || transcript.Terms[n-1].TermID != c.TermID {
+			term.TermID = c.TermID
+			transcript.Terms = append(transcript.Terms, &term)
+		}
+		t := transcript.Terms[len(transcript.Terms)-1]
+		t.Modules = append(t.Modules, &c)
+
+		completions = append(completions, &c)
+		if c.ModuleID != nil {
+			latest[*c.ModuleID] = &c
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, term := range transcript.Terms {
+		term.Credits, term.GPA = gpa(term.Modules)
+	}
+
+	// The completions keep their order, so the totals and with them the
+	// verification code don't change from one read to the next.
+	var counted []*ModuleCompletion
+	for _, c := range completions {
+		if c.ModuleID != nil && latest[*c.ModuleID] != c {
+			continue
+		}
+		counted = append(counted, c)
+		if c.Points > 0 {
+			transcript.CreditsEarned += c.Credits
+		}
+	}
+	transcript.CreditsAttempted, transcript.GPA = gpa(counted)
+
+	return transcript, nil
+}
+
+// Issue records a hash of the transcript as handed out and returns the code that
+// verifies it. The code is derived from the transcript, so issuing the same
+// transcript again returns the same code and the time it was first issued.
+func (m TranscriptModel) Issue(transcript *Transcript) (*TranscriptVerification, error) {
+	sum, err := transcriptHash(transcript)
+	if err != nil {
+		return nil, err
+	}
+
+	verification := &TranscriptVerification{Code: verificationCode(sum), documentHash: sum}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+INSERT INTO transcript_verifications (code, user_id, document_hash)
+VALUES ($1, $2, $3)
+ON CONFLICT (code) DO NOTHING`
+
+	_, err = m.DB.ExecContext(ctx, query, verification.Code, transcript.UserID, sum)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.DB.QueryRowContext(ctx, `SELECT issued_at FROM transcript_verifications WHERE code = $1`, verification.Code).Scan(&verification.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+	return verification, nil
+}
+
+// GetVerification returns the verification issued with the code.
+func (m TranscriptModel) GetVerification(code string) (*TranscriptVerification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	verification := &TranscriptVerification{}
+
+	query := `SELECT code, issued_at, document_hash FROM transcript_verifications WHERE code = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, NormalizeVerificationCode(code)).Scan(&verification.Code, &verification.IssuedAt, &verification.documentHash)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return verification, nil
+}
+
+// GetVerificationsForUser returns the verifications issued for a user's
+// transcripts, oldest first.
+func (m TranscriptModel) GetVerificationsForUser(userID int64) ([]*TranscriptVerification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+SELECT code, issued_at
+FROM transcript_verifications
+WHERE user_id = $1
+ORDER BY issued_at, code`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verifications := []*TranscriptVerification{}
+	for rows.Next() {
+		var v TranscriptVerification
+		err = rows.Scan(&v.Code, &v.IssuedAt)
+		if err != nil {
+			return nil, err
+		}
+		verifications = append(verifications, &v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return verifications, nil
+}
@@ -6,9 +6,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/lib/pq"
+	"github.com/shynggys9219/greenlight/internal/fieldcrypt"
 	"github.com/shynggys9219/greenlight/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -16,8 +19,134 @@ var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+// UserInfoModel stores name, surname and email encrypted when Keys is set. Rows
+// written before that (or with Keys nil) hold plaintext and are read as-is until the
+// key rotation command seals them. Sealed rows are found through blind indexes and
+// search tokens (see search.go), plaintext rows through the columns themselves.
 type UserInfoModel struct {
-	DB *sql.DB
+	DB   *sql.DB
+	Keys *fieldcrypt.Keyring
+}
+
+// sealedPII holds the column values for the encrypted fields of a user row and
+// the blind values they are looked up by.
+type sealedPII struct {
+	name       string
+	surname    string
+	email      string
+	dataKey    sql.NullString
+	emailIndex []byte
+
+	nameIndex      []byte
+	surnameIndex   []byte
+	searchWords    pq.StringArray
+	searchTrigrams pq.StringArray
+}
+
+// seal encrypts the PII of info with a fresh data key.
+func (m UserInfoModel) seal(info *UserInfo) (*sealedPII, error) {
+	if m.Keys == nil {
+		return &sealedPII{name: info.Name, surname: info.Surname, email: info.Email}, nil
+	}
+
+	dek, wrapped, err := m.Keys.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	text := info.Name + " " + info.Surname + " " + info.Email
+	pii := &sealedPII{
+		dataKey:    sql.NullString{String: wrapped, Valid: true},
+		emailIndex: m.Keys.BlindIndex(info.Email),
+
+		nameIndex:      m.nameIndex(info.Name),
+		surnameIndex:   m.surnameIndex(info.Surname),
+		searchWords:    blindTokens(m.Keys, "word", searchWords(text)),
+		searchTrigrams: blindTokens(m.Keys, "trigram", trigrams(text)),
+	}
+
+	pii.name, err = fieldcrypt.Seal(dek, "name", info.Name)
+	if err != nil {
+		return nil, err
+	}
+	pii.surname, err = fieldcrypt.Seal(dek, "surname", info.Surname)
+	if err != nil {
+		return nil, err
+	}
+	pii.email, err = fieldcrypt.Seal(dek, "email", info.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return pii, nil
+}
+
+// open decrypts the PII fields of info in place using the row's wrapped data key.
+func (m UserInfoModel) open(info *UserInfo, dataKey sql.NullString) error {
+	if !dataKey.Valid {
+		return nil
+	}
+	if m.Keys == nil {
+		return errors.New("user_info row is encrypted but no master key is configured")
+	}
+
+	dek, err := m.Keys.UnwrapDataKey(dataKey.String)
+	if err != nil {
+		return err
+	}
+
+	info.Name, err = fieldcrypt.Open(dek, "name", info.Name)
+	if err != nil {
+		return err
+	}
+	info.Surname, err = fieldcrypt.Open(dek, "surname", info.Surname)
+	if err != nil {
+		return err
+	}
+	info.Email, err = fieldcrypt.Open(dek, "email", info.Email)
+	return err
+}
+
+// blindIndex returns the lookup value for email, or nil when encryption is off.
+func (m UserInfoModel) blindIndex(email string) []byte {
+	if m.Keys == nil {
+		return nil
+	}
+	return m.Keys.BlindIndex(email)
+}
+
+// nameIndex and surnameIndex return the lookup values for the exact name and
+// surname filters, or nil when encryption is off or there is nothing to look up.
+func (m UserInfoModel) nameIndex(name string) []byte {
+	return m.blindName("name", name)
+}
+
+func (m UserInfoModel) surnameIndex(surname string) []byte {
+	return m.blindName("surname", surname)
+}
+
+func (m UserInfoModel) blindName(domain, name string) []byte {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if m.Keys == nil || name == "" {
+		return nil
+	}
+	return m.Keys.BlindToken(domain, name)
+}
+
+// Encrypted reports whether user PII is sealed. Sealed columns can't be sorted on,
+// since the database only sees the order of the ciphertext.
+func (m UserInfoModel) Encrypted() bool {
+	return m.Keys != nil
+}
+
+// isDuplicateEmail reports whether err is a unique violation on either the email
+// column or its blind index.
+func isDuplicateEmail(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" && strings.Contains(pqErr.Constraint, "email")
+	}
+	return false
 }
 
 type password struct {
@@ -50,18 +179,28 @@ func (p *password) Matches(plaintext string) (bool, error) {
 }
 
 func (m UserInfoModel) Insert(info *UserInfo) error {
-	query := "INSERT INTO user_info( name, surname, email, password_hash, role, activated) VALUES ($1,$2,$3, $4, $5, $6) RETURNING id, created_at, version"
+	query := `
+INSERT INTO user_info (name, surname, email, password_hash, role, activated, data_key, email_index,
+                       name_index, surname_index, search_words, search_trigrams)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+RETURNING id, created_at, version`
 
 	log.Println("inserted to db")
 
-	args := []any{info.Name, info.Surname, info.Email, info.PasswordHash, info.Role, info.Activated}
+	pii, err := m.seal(info)
+	if err != nil {
+		return err
+	}
+
+	args := []any{pii.name, pii.surname, pii.email, info.PasswordHash.hash, info.Role, info.Activated, pii.dataKey, pii.emailIndex,
+		pii.nameIndex, pii.surnameIndex, pii.searchWords, pii.searchTrigrams}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&info.ID, &info.CreatedAt, &info.Version)
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&info.ID, &info.CreatedAt, &info.Version)
 	if err != nil {
 		switch {
-		case err.Error() == "pq: duplicate key value violates unique constraint 'users_email_key'":
+		case isDuplicateEmail(err):
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -71,23 +210,31 @@ func (m UserInfoModel) Insert(info *UserInfo) error {
 }
 
 func (m UserInfoModel) GetByEmail(email string) (*UserInfo, error) {
-	query := `SELECT id, created_at, name, surname, email, password_hash, role, activated, version FROM user_info WHERE email = $1`
+	// Sealed rows are found through the blind index, legacy plaintext rows through
+	// the email column itself.
+	query := `SELECT id, created_at, name, surname, email, password_hash, role, activated, version, data_key,
+       suspended_at, suspension_reason, expires_at FROM user_info WHERE email_index = $1 OR (email_index IS NULL AND email = $2)`
 
 	var info UserInfo
+	var dataKey sql.NullString
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+	err := m.DB.QueryRowContext(ctx, query, m.blindIndex(email), email).Scan(
 		&info.ID,
 		&info.CreatedAt,
 		&info.Name,
 		&info.Surname,
 		&info.Email,
-		&info.PasswordHash,
+		&info.PasswordHash.hash,
 		&info.Role,
 		&info.Activated,
-		&info.Version)
+		&info.Version,
+		&dataKey,
+		&info.SuspendedAt,
+		&info.SuspensionReason,
+		&info.ExpiresAt)
 
 	if err != nil {
 		switch {
@@ -97,6 +244,10 @@ func (m UserInfoModel) GetByEmail(email string) (*UserInfo, error) {
 			return nil, err
 		}
 	}
+	err = m.open(&info, dataKey)
+	if err != nil {
+		return nil, err
+	}
 	return &info, nil
 }
 
@@ -104,9 +255,11 @@ func (m UserInfoModel) GetByID(id int64) (*UserInfo, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT id, created_at, name, surname, email, password_hash, role, activated, version FROM user_info WHERE id = $1`
+	query := `SELECT id, created_at, name, surname, email, password_hash, role, activated, version, data_key,
+       suspended_at, suspension_reason, expires_at FROM user_info WHERE id = $1`
 
 	var info UserInfo
+	var dataKey sql.NullString
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -117,10 +270,14 @@ func (m UserInfoModel) GetByID(id int64) (*UserInfo, error) {
 		&info.Name,
 		&info.Surname,
 		&info.Email,
-		&info.PasswordHash,
+		&info.PasswordHash.hash,
 		&info.Role,
 		&info.Activated,
-		&info.Version)
+		&info.Version,
+		&dataKey,
+		&info.SuspendedAt,
+		&info.SuspensionReason,
+		&info.ExpiresAt)
 
 	if err != nil {
 		switch {
@@ -130,16 +287,33 @@ func (m UserInfoModel) GetByID(id int64) (*UserInfo, error) {
 			return nil, err
 		}
 	}
+	err = m.open(&info, dataKey)
+	if err != nil {
+		return nil, err
+	}
 	return &info, nil
 }
 
-func (m UserInfoModel) GetAll(name, surname string, filters Filters) ([]*UserInfo, Metadata, error) {
-	query := fmt.Sprintf("SELECT * FROM  WHERE (LOWER(name) = LOWER($1) OR $1 = '') AND (LOWER(surname) = LOWER($2) OR $2 = '') ORDER BY %s %s, id ASC LIMIT $3 OFFSET $4", filters.sortColumn(), filters.sortDirection())
+// GetAll lists users, optionally filtered by exact name, surname and membership of
+// a group. A groupID of 0 disables the group filter. Name and surname are compared
+// case-insensitively, for sealed rows through their blind indexes.
+func (m UserInfoModel) GetAll(name, surname string, groupID int64, filters Filters) ([]*UserInfo, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s, id, created_at, updated_at, name, surname, email, role, activated, version, data_key,
+       suspended_at, suspension_reason, expires_at
+FROM user_info
+WHERE ($1 = '' OR (data_key IS NULL AND LOWER(name) = LOWER($1)) OR name_index = $8)
+AND ($2 = '' OR (data_key IS NULL AND LOWER(surname) = LOWER($2)) OR surname_index = $9)
+AND ($3 = 0 OR id IN (SELECT user_id FROM group_members WHERE group_id = $3))
+AND %s
+ORDER BY %s LIMIT $6 OFFSET $7`, filters.cursorColumn(), filters.keyset(4), filters.orderBy())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{name, surname, filters.limit(), filters.offset()}
+	args := []any{name, surname, groupID}
+	args = append(args, filters.keysetArgs()...)
+	args = append(args, filters.limit(), filters.offset(), m.nameIndex(name), m.surnameIndex(surname))
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -151,11 +325,15 @@ func (m UserInfoModel) GetAll(name, surname string, filters Filters) ([]*UserInf
 	totalRecords := 0
 
 	infos := []*UserInfo{}
+	keys := []cursorKey{}
 
 	for rows.Next() {
 		var info UserInfo
+		var dataKey sql.NullString
+		var key cursorKey
 		err := rows.Scan(
 			&totalRecords,
+			&key.value,
 			&info.ID,
 			&info.CreatedAt,
 			&info.UpdatedAt,
@@ -163,42 +341,287 @@ func (m UserInfoModel) GetAll(name, surname string, filters Filters) ([]*UserInf
 			&info.Surname,
 			&info.Email,
 			&info.Role,
-			&info.Activated)
+			&info.Activated,
+			&info.Version,
+			&dataKey,
+			&info.SuspendedAt,
+			&info.SuspensionReason,
+			&info.ExpiresAt)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
+		err = m.open(&info, dataKey)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		key.id = int64(info.ID)
 		infos = append(infos, &info)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	infos, metadata := paginate(infos, keys, totalRecords, filters)
 
 	return infos, metadata, nil
 }
 
+// UserSearchResult is a user matched by Search together with its relevance and the
+// matched fragment wrapped in <mark> tags. Like UserInfo it has to be turned into a
+// view before being sent to a client.
+type UserSearchResult struct {
+	*UserInfo
+	Rank      float64
+	Highlight string
+}
+
+// Search looks for q across name, surname and email. Plaintext rows match either
+// through the full-text search_vector or through pg_trgm similarity, so small typos
+// still find the user. Sealed rows match the same way through their blind tokens:
+// every word of q has to be one of the row's words, or the trigrams of q have to be
+// similar enough to the row's. Search operators only work on plaintext rows. The
+// rank combines both scores and is available as the "rank" sort key.
+func (m UserInfoModel) Search(q, name, surname string, groupID int64, filters Filters) ([]*UserSearchResult, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, updated_at, name, surname, email, role, activated, version, data_key,
+       suspended_at, suspension_reason, expires_at,
+       CASE WHEN data_key IS NULL THEN
+           ts_rank(search_vector, websearch_to_tsquery('simple', $1))
+               + similarity(coalesce(name, '') || ' ' || coalesce(surname, '') || ' ' || coalesce(email, ''), $1)
+       ELSE
+           CASE WHEN search_words @> $7 THEN 0.1 ELSE 0 END + blind.similarity
+       END AS rank
+FROM user_info
+CROSS JOIN LATERAL (
+    SELECT cardinality(ARRAY(SELECT unnest(user_info.search_trigrams) INTERSECT SELECT unnest($8::text[])))::real
+           / greatest(cardinality(ARRAY(SELECT unnest(user_info.search_trigrams) UNION SELECT unnest($8::text[]))), 1) AS similarity
+) AS blind
+WHERE ((data_key IS NULL
+        AND (search_vector @@ websearch_to_tsquery('simple', $1)
+             OR (coalesce(name, '') || ' ' || coalesce(surname, '') || ' ' || coalesce(email, '')) %% $1))
+       OR (data_key IS NOT NULL
+           AND (search_words @> $7 OR (search_trigrams && $8 AND blind.similarity >= 0.3))))
+AND ($2 = '' OR (data_key IS NULL AND LOWER(name) = LOWER($2)) OR name_index = $9)
+AND ($3 = '' OR (data_key IS NULL AND LOWER(surname) = LOWER($3)) OR surname_index = $10)
+AND ($4 = 0 OR id IN (SELECT user_id FROM group_members WHERE group_id = $4))
+ORDER BY %s %s, id ASC LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// A query of only punctuation has no words, and must not match every row.
+	words := blindTokens(m.Keys, "word", queryWords(q))
+	if words == nil && m.Keys != nil {
+		words = pq.StringArray{""}
+	}
+
+	args := []any{q, name, surname, groupID, filters.limit(), filters.offset(),
+		words, blindTokens(m.Keys, "trigram", trigrams(q)), m.nameIndex(name), m.surnameIndex(surname)}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+
+	results := []*UserSearchResult{}
+
+	for rows.Next() {
+		result := UserSearchResult{UserInfo: &UserInfo{}}
+		var dataKey sql.NullString
+		err := rows.Scan(
+			&totalRecords,
+			&result.ID,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.Name,
+			&result.Surname,
+			&result.Email,
+			&result.Role,
+			&result.Activated,
+			&result.Version,
+			&dataKey,
+			&result.SuspendedAt,
+			&result.SuspensionReason,
+			&result.ExpiresAt,
+			&result.Rank)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = m.open(result.UserInfo, dataKey)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		result.Highlight = highlight(result.Name+" "+result.Surname+" "+result.Email, q)
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return results, metadata, nil
+}
+
+// GetGroupMembers returns every member of the group, unpaginated, for fan-out work
+// such as sending announcements.
+func (m UserInfoModel) GetGroupMembers(groupID int64) ([]*UserInfo, error) {
+	query := `
+SELECT user_info.id, user_info.created_at, user_info.name, user_info.surname, user_info.email,
+       user_info.role, user_info.activated, user_info.version, user_info.data_key
+FROM user_info
+INNER JOIN group_members ON group_members.user_id = user_info.id
+WHERE group_members.group_id = $1
+ORDER BY user_info.id`
+
+	return m.getRecipients(query, groupID)
+}
+
+// GetModuleAudience returns the users who belong to a group the module is assigned
+// to. Users in several such groups are only returned once.
+func (m UserInfoModel) GetModuleAudience(moduleID int64) ([]*UserInfo, error) {
+	query := `
+SELECT DISTINCT user_info.id, user_info.created_at, user_info.name, user_info.surname, user_info.email,
+       user_info.role, user_info.activated, user_info.version, user_info.data_key
+FROM user_info
+INNER JOIN group_members ON group_members.user_id = user_info.id
+INNER JOIN group_modules ON group_modules.group_id = group_members.group_id
+WHERE group_modules.module_id = $1 AND user_info.erased_at IS NULL
+ORDER BY user_info.id`
+
+	return m.getRecipients(query, moduleID)
+}
+
+// GetModuleReviewers returns the users who may review a module: the admins and
+// those of the module's directors who are named by email address. Directors named
+// by their full name can't be looked up, since names are stored encrypted.
+func (m UserInfoModel) GetModuleReviewers(directors []string) ([]*UserInfo, error) {
+	query := `
+SELECT user_info.id, user_info.created_at, user_info.name, user_info.surname, user_info.email,
+       user_info.role, user_info.activated, user_info.version, user_info.data_key
+FROM user_info
+WHERE user_info.role = $1 AND user_info.activated AND user_info.erased_at IS NULL
+ORDER BY user_info.id`
+
+	reviewers, err := m.getRecipients(query, Admin)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, director := range directors {
+		if !validator.Matches(strings.TrimSpace(director), validator.EmailRX) {
+			continue
+		}
+
+		user, err := m.GetByEmail(strings.TrimSpace(director))
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		known := false
+		for _, reviewer := range reviewers {
+			known = known || reviewer.ID == user.ID
+		}
+		if !known {
+			reviewers = append(reviewers, user)
+		}
+	}
+	return reviewers, nil
+}
+
+func (m UserInfoModel) getRecipients(query string, args ...any) ([]*UserInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := []*UserInfo{}
+	for rows.Next() {
+		var info UserInfo
+		var dataKey sql.NullString
+		err = rows.Scan(
+			&info.ID,
+			&info.CreatedAt,
+			&info.Name,
+			&info.Surname,
+			&info.Email,
+			&info.Role,
+			&info.Activated,
+			&info.Version,
+			&dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		err = m.open(&info, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, &info)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// Update saves the user. It returns ErrEditConflict unless info.Version is still
+// the version stored in the database.
 func (m UserInfoModel) Update(info *UserInfo) error {
-	query := "UPDATE user_info SET updated_at = now(), name = $1, surname = $2, email = $3, role = $4, activated = $5, version = version + 1 WHERE id = $6 RETURNING version"
+	query := `
+UPDATE user_info
+SET updated_at = now(), name = $1, surname = $2, email = $3, role = $4, activated = $5, data_key = $6, email_index = $7,
+    name_index = $10, surname_index = $11, search_words = $12, search_trigrams = $13, version = version + 1
+WHERE id = $8 AND version = $9
+RETURNING version`
+
+	pii, err := m.seal(info)
+	if err != nil {
+		return err
+	}
 
 	args := []any{
-		info.Name,
-		info.Surname,
-		info.Email,
+		pii.name,
+		pii.surname,
+		pii.email,
 		info.Role,
 		info.Activated,
+		pii.dataKey,
+		pii.emailIndex,
 		info.ID,
+		info.Version,
+		pii.nameIndex,
+		pii.surnameIndex,
+		pii.searchWords,
+		pii.searchTrigrams,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&info.Version)
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&info.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isDuplicateEmail(err):
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -239,6 +662,16 @@ func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be valid email address")
 }
 
+// ValidateEmailDomain checks that the domain part of the email is on the allowlist
+// used for self-registration. The comparison is case-insensitive.
+func ValidateEmailDomain(v *validator.Validator, email string, allowedDomains []string) {
+	domain := ""
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		domain = strings.ToLower(email[at+1:])
+	}
+	v.Check(validator.PermittedValue(domain, allowedDomains...), "email", "email domain is not allowed to self-register")
+}
+
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
@@ -264,10 +697,12 @@ func (m UserInfoModel) GetForToken(tokenScope, tokenPlaintext string) (*UserInfo
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	// Set up the SQL query.
 	query := `
-SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
-FROM users
+SELECT user_info.id, user_info.created_at, user_info.name, user_info.surname, user_info.email, user_info.password_hash,
+       user_info.role, user_info.activated, user_info.version, user_info.data_key,
+       user_info.suspended_at, user_info.suspension_reason, user_info.expires_at
+FROM user_info
 INNER JOIN tokens
-ON users.id = tokens.user_id
+ON user_info.id = tokens.user_id
 WHERE tokens.hash = $1
 AND tokens.scope = $2
 AND tokens.expiry > $3`
@@ -277,6 +712,7 @@ AND tokens.expiry > $3`
 	// value to check against the token expiry.
 	args := []any{tokenHash[:], tokenScope, time.Now()}
 	var user UserInfo
+	var dataKey sql.NullString
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	// Execute the query, scanning the return values into a User struct. If no matching
@@ -285,10 +721,16 @@ AND tokens.expiry > $3`
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
+		&user.Surname,
 		&user.Email,
 		&user.PasswordHash.hash,
+		&user.Role,
 		&user.Activated,
 		&user.Version,
+		&dataKey,
+		&user.SuspendedAt,
+		&user.SuspensionReason,
+		&user.ExpiresAt,
 	)
 	if err != nil {
 		switch {
@@ -299,5 +741,85 @@ AND tokens.expiry > $3`
 		}
 	}
 	// Return the matching user.
+	err = m.open(&user, dataKey)
+	if err != nil {
+		return nil, err
+	}
 	return &user, nil
 }
+
+// RotateKeys re-seals one batch of rows that are still plaintext, whose data key
+// is wrapped by an older master key or that were sealed before they had search
+// tokens, and returns how many rows it processed. Erased rows are left alone, as
+// their placeholders stay unsealed. Rows are locked with SKIP LOCKED so several
+// rotations can run side by side; call it until it returns 0.
+func (m UserInfoModel) RotateKeys(batchSize int) (int, error) {
+	if m.Keys == nil {
+		return 0, errors.New("key rotation requires a master key")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+SELECT id, name, surname, email, data_key
+FROM user_info
+WHERE (data_key IS NULL OR data_key NOT LIKE $1 OR search_words IS NULL)
+AND erased_at IS NULL
+ORDER BY id
+LIMIT $2
+FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, m.Keys.CurrentID()+":%", batchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var infos []*UserInfo
+	for rows.Next() {
+		var info UserInfo
+		var dataKey sql.NullString
+		err = rows.Scan(&info.ID, &info.Name, &info.Surname, &info.Email, &dataKey)
+		if err != nil {
+			return 0, err
+		}
+
+		err = m.open(&info, dataKey)
+		if err != nil {
+			return 0, fmt.Errorf("user %d: %w", info.ID, err)
+		}
+		infos = append(infos, &info)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Rotation changes only how the row is stored, so version is left alone.
+	query = `
+UPDATE user_info
+SET name = $1, surname = $2, email = $3, data_key = $4, email_index = $5,
+    name_index = $7, surname_index = $8, search_words = $9, search_trigrams = $10
+WHERE id = $6`
+
+	for _, info := range infos {
+		pii, err := m.seal(info)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, query, pii.name, pii.surname, pii.email, pii.dataKey, pii.emailIndex, info.ID,
+			pii.nameIndex, pii.surnameIndex, pii.searchWords, pii.searchTrigrams)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(infos), tx.Commit()
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// UserProfile holds the fields of a user that any signed-in user may see.
+type UserProfile struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Surname string `json:"surname"`
+}
+
+// UserAccount is what users see about their own account.
+type UserAccount struct {
+	UserProfile
+	Email     string     `json:"email"`
+	Activated bool       `json:"activated"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// UserAdminView adds the role, status and audit fields that only admins see.
+type UserAdminView struct {
+	UserAccount
+	Role             string     `json:"role"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+	Version          int        `json:"version"`
+	SuspendedAt      *time.Time `json:"suspendedAt,omitempty"`
+	SuspensionReason string     `json:"suspensionReason,omitempty"`
+}
+
+func (u *UserInfo) Profile() *UserProfile {
+	return &UserProfile{ID: u.ID, Name: u.Name, Surname: u.Surname}
+}
+
+func (u *UserInfo) Account() *UserAccount {
+	return &UserAccount{
+		UserProfile: *u.Profile(),
+		Email:       u.Email,
+		Activated:   u.Activated,
+		CreatedAt:   u.CreatedAt,
+		ExpiresAt:   u.ExpiresAt,
+	}
+}
+
+func (u *UserInfo) AdminView() *UserAdminView {
+	return &UserAdminView{
+		UserAccount:      *u.Account(),
+		Role:             u.Role,
+		UpdatedAt:        u.UpdatedAt,
+		Version:          u.Version,
+		SuspendedAt:      u.SuspendedAt,
+		SuspensionReason: u.SuspensionReason,
+	}
+}
+
+// ViewFor returns the projection of u that viewer is allowed to see: admins get
+// the admin view, users looking at themselves their account, everyone else the
+// public profile.
+func (u *UserInfo) ViewFor(viewer *UserInfo) any {
+	switch {
+	case viewer.Role == Admin:
+		return u.AdminView()
+	case !viewer.IsAnonymous() && viewer.ID == u.ID:
+		return u.Account()
+	default:
+		return u.Profile()
+	}
+}
+
+// MarshalJSON falls back to the public profile, so a UserInfo that reaches an
+// encoder without going through ViewFor can't leak anything else.
+func (u UserInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.Profile())
+}
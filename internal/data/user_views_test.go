@@ -0,0 +1,39 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUserViewsOmitPassword(t *testing.T) {
+	const plaintext = "pa55word-in-test"
+
+	user := &UserInfo{ID: 1, Name: "Alice", Surname: "Liddell", Email: "alice@example.com", Role: Admin, Activated: true, Version: 1}
+	err := user.PasswordHash.Set(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	views := map[string]interface{}{
+		"UserInfo":  user,
+		"Profile":   user.Profile(),
+		"Account":   user.Account(),
+		"AdminView": user.AdminView(),
+		"ViewFor":   user.ViewFor(user),
+	}
+
+	for name, view := range views {
+		js, err := json.Marshal(view)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if strings.Contains(strings.ToLower(string(js)), "password") {
+			t.Errorf("%s: has a password member: %s", name, js)
+		}
+		if bytes.Contains(js, []byte(plaintext)) || bytes.Contains(js, user.PasswordHash.hash) {
+			t.Errorf("%s: contains the password or its hash: %s", name, js)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// WorkloadEntry sums up the hours a teacher is due in a term for the modules of a
+// department: the contact hours of the offerings they teach and the hours of the
+// exams they invigilate. Modules in no department are reported without one.
+// Offerings counts the offerings the teacher has a share of the teaching in.
+type WorkloadEntry struct {
+	TeacherID         int64   `json:"teacherId"`
+	TermID            int64   `json:"termId"`
+	TermName          string  `json:"termName"`
+	AcademicYear      string  `json:"academicYear"`
+	DepartmentID      *int64  `json:"departmentId"`
+	DepartmentName    *string `json:"departmentName"`
+	Offerings         int     `json:"offerings"`
+	ContactHours      Hours   `json:"contactHours"`
+	Exams             int     `json:"exams"`
+	InvigilationHours Hours   `json:"invigilationHours"`
+	TotalHours        Hours   `json:"totalHours"`
+}
+
+// WorkloadFilter picks the workload reported. Zero values disable a filter.
+type WorkloadFilter struct {
+	TermID       int64
+	DepartmentID int64
+	TeacherID    int64
+}
+
+type WorkloadModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns the workload per teacher, term and department, by term and then
+// teacher.
+//
+// An offering's contact hours are those of the module revision published at the
+// start of its term, or of the first one published if the module only came out
+// during the term; offerings of modules never published count no hours. They are
+// shared out among the offering's weekly sessions by length, each share going to
+// the session's teacher, and all of them to the offering's teacher if it has no
+// sessions. Sessions without a teacher of their own fall back to the offering's.
+func (m WorkloadModel) GetAll(f WorkloadFilter) ([]*WorkloadEntry, error) {
+	query := `
+WITH duties (user_id, offering_id, teaching, contact_hours, exams, invigilation_hours) AS (
+    SELECT coalesce(module_sessions.teacher_id, module_offerings.teacher_id), module_offerings.id, true,
+           revision.contact_hours * coalesce(
+               extract(epoch FROM module_sessions.ends_at - module_sessions.starts_at)::numeric
+               / sum(extract(epoch FROM module_sessions.ends_at - module_sessions.starts_at)::numeric) OVER (PARTITION BY module_offerings.id),
+               1),
+           0, 0::numeric
+    FROM module_offerings
+    INNER JOIN academic_terms ON academic_terms.id = module_offerings.term_id
+    CROSS JOIN LATERAL (
+        SELECT contact_hours
+        FROM module_info_revisions
+        WHERE module_info_revisions.module_id = module_offerings.module_id
+        AND module_info_revisions.effective_from IS NOT NULL
+        ORDER BY module_info_revisions.effective_from > academic_terms.starts_on,
+                 CASE WHEN module_info_revisions.effective_from <= academic_terms.starts_on THEN module_info_revisions.effective_from END DESC,
+                 module_info_revisions.effective_from, module_info_revisions.version DESC
+        LIMIT 1
+    ) AS revision
+    LEFT JOIN module_sessions ON module_sessions.offering_id = module_offerings.id
+    UNION ALL
+    SELECT exam_invigilators.user_id, exam_sessions.offering_id, false, 0, 1, exam_sessions.duration_minutes / 60.0
+    FROM exam_invigilators
+    INNER JOIN exam_sessions ON exam_sessions.id = exam_invigilators.exam_id
+)
+SELECT duties.user_id, academic_terms.id, academic_terms.name, academic_terms.academic_year,
+       department_info.id, department_info.department_name,
+       count(DISTINCT duties.offering_id) FILTER (WHERE duties.teaching), round(sum(duties.contact_hours), 2),
+       sum(duties.exams), round(sum(duties.invigilation_hours), 2)
+FROM duties
+INNER JOIN module_offerings ON module_offerings.id = duties.offering_id
+INNER JOIN academic_terms ON academic_terms.id = module_offerings.term_id
+LEFT JOIN department_info ON department_info.module_id = module_offerings.module_id
+WHERE duties.user_id IS NOT NULL
+AND ($1 = 0 OR academic_terms.id = $1)
+AND ($2 = 0 OR department_info.id = $2)
+AND ($3 = 0 OR duties.user_id = $3)
+GROUP BY duties.user_id, academic_terms.id, academic_terms.name, academic_terms.academic_year, academic_terms.starts_on,
+         department_info.id, department_info.department_name
+ORDER BY academic_terms.starts_on, academic_terms.id, duties.user_id, department_info.id NULLS LAST`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.TermID, f.DepartmentID, f.TeacherID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*WorkloadEntry{}
+	for rows.Next() {
+		var e WorkloadEntry
+		err = rows.Scan(&e.TeacherID, &e.TermID, &e.TermName, &e.AcademicYear, &e.DepartmentID, &e.DepartmentName,
+			&e.Offerings, &e.ContactHours, &e.Exams, &e.InvigilationHours)
+		if err != nil {
+			return nil, err
+		}
+		e.TotalHours = Hours(math.Round(float64(e.ContactHours+e.InvigilationHours)*100) / 100)
+		entries = append(entries, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,253 @@
+// Package fieldcrypt implements envelope encryption for individual database columns.
+// Every row gets its own random AES-256-GCM data key, which is stored next to the row
+// wrapped (encrypted) by a master key. Rotating the master key therefore only needs
+// the rows to be re-sealed, never a change to the schema.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Environment variables that take precedence over the key files.
+const (
+	MasterKeyEnv = "GREENLIGHT_MASTER_KEY"
+	IndexKeyEnv  = "GREENLIGHT_PII_INDEX_KEY"
+)
+
+// sealedPrefix marks a column value produced by Seal. Values without it are legacy
+// plaintext written before encryption was enabled.
+const sealedPrefix = "enc:"
+
+var (
+	ErrUnknownKey = errors.New("fieldcrypt: data key wrapped with an unknown master key")
+	ErrMalformed  = errors.New("fieldcrypt: malformed ciphertext")
+)
+
+// Keyring holds the current master key, any previous master keys that may still
+// have wrapped data keys in the database, and the key used for blind indexes.
+type Keyring struct {
+	currentID string
+	keys      map[string][]byte
+	indexKey  []byte
+}
+
+// NewKeyring builds a Keyring. New data keys are always wrapped with current; the
+// previous keys are only used to unwrap. All keys must be 32 bytes long.
+func NewKeyring(current, indexKey []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte), indexKey: indexKey}
+
+	if len(indexKey) != 32 {
+		return nil, errors.New("fieldcrypt: blind index key must be 32 bytes")
+	}
+
+	for _, key := range append([][]byte{current}, previous...) {
+		if len(key) != 32 {
+			return nil, errors.New("fieldcrypt: master key must be 32 bytes")
+		}
+		k.keys[keyID(key)] = key
+	}
+	k.currentID = keyID(current)
+
+	return k, nil
+}
+
+// LoadKey reads a base64 encoded key from the named environment variable or, if it is
+// empty, from path. It returns nil with no error if neither is set.
+func LoadKey(path, env string) ([]byte, error) {
+	var encoded string
+	if env != "" {
+		encoded = os.Getenv(env)
+	}
+	if encoded == "" && path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(b)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+}
+
+// LoadKeyring loads the master key, the blind index key and any previous master
+// keys. It returns a nil Keyring with no error when no master key is configured.
+func LoadKeyring(masterPath, indexPath string, previousPaths []string) (*Keyring, error) {
+	current, err := LoadKey(masterPath, MasterKeyEnv)
+	if err != nil || current == nil {
+		return nil, err
+	}
+
+	indexKey, err := LoadKey(indexPath, IndexKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous [][]byte
+	for _, path := range previousPaths {
+		key, err := LoadKey(path, "")
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+
+	return NewKeyring(current, indexKey, previous...)
+}
+
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// CurrentID returns the identifier that prefixes data keys wrapped by the current
+// master key.
+func (k *Keyring) CurrentID() string {
+	return k.currentID
+}
+
+// NewDataKey returns a fresh data key and its wrapped form for storage.
+func (k *Keyring) NewDataKey() ([]byte, string, error) {
+	dek := make([]byte, 32)
+	_, err := rand.Read(dek)
+	if err != nil {
+		return nil, "", err
+	}
+
+	wrapped, err := encrypt(k.keys[k.currentID], dek, []byte(k.currentID))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return dek, k.currentID + ":" + base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// UnwrapDataKey recovers the data key from its stored form using whichever master
+// key wrapped it.
+func (k *Keyring) UnwrapDataKey(wrapped string) ([]byte, error) {
+	id, encoded, found := strings.Cut(wrapped, ":")
+	if !found {
+		return nil, ErrMalformed
+	}
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return decrypt(key, ciphertext, []byte(id))
+}
+
+// BlindIndex returns a deterministic keyed hash of the normalised email so that rows
+// can be looked up and kept unique without storing the plaintext.
+func (k *Keyring) BlindIndex(email string) []byte {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return mac.Sum(nil)
+}
+
+// BlindToken returns a keyed hash of value for searching sealed columns. The
+// domain is hashed in first, so that the same text hashed for different purposes,
+// such as a whole word and a trigram, gives unrelated tokens. value is used as
+// given; callers normalise it first.
+func (k *Keyring) BlindToken(domain, value string) []byte {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(domain))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// Seal encrypts a column value with a data key. The column name is bound as
+// additional data so that values can't be swapped between columns.
+func Seal(dek []byte, column, plaintext string) (string, error) {
+	ciphertext, err := encrypt(dek, []byte(plaintext), []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open reverses Seal. Values that were never sealed are returned unchanged.
+func Open(dek []byte, column, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	if dek == nil {
+		return "", ErrMalformed
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	plaintext, err := decrypt(dek, ciphertext, []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether a column value was produced by Seal.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+func encrypt(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func decrypt(key, ciphertext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,140 @@
+// Package ical writes iCalendar (RFC 5545) calendars of events.
+//
+// Times are written as floating local times, which calendar clients show at the
+// same wall-clock time in any time zone. That matches how sessions are stored and
+// spares each calendar a VTIMEZONE definition.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ContentType = "text/calendar; charset=utf-8"
+
+	dateLayout     = "20060102"
+	localLayout    = "20060102T150405"
+	utcLayout      = "20060102T150405Z"
+	maxLineOctets  = 75
+	contentLineEnd = "\r\n"
+)
+
+// Calendar is a VCALENDAR object.
+type Calendar struct {
+	ProdID string // identifies the product that created the calendar
+	Name   string // shown by clients as the calendar's name
+	Events []Event
+}
+
+// Event is a VEVENT. UID must stay the same across versions of the event, and
+// Sequence must grow whenever it changes, so that clients replace the event they
+// have instead of adding another one. With AllDay Start and End are dates, End
+// being the day after the event; with UTC they are instants; otherwise they are
+// local times.
+type Event struct {
+	UID          string
+	Sequence     int
+	LastModified time.Time
+	Summary      string
+	Description  string
+	Location     string
+	Start        time.Time
+	End          time.Time
+	AllDay       bool
+	UTC          bool
+	RRule        string
+}
+
+var byDay = map[time.Weekday]string{
+	time.Monday:    "MO",
+	time.Tuesday:   "TU",
+	time.Wednesday: "WE",
+	time.Thursday:  "TH",
+	time.Friday:    "FR",
+	time.Saturday:  "SA",
+	time.Sunday:    "SU",
+}
+
+// Weekly returns the recurrence rule of an event repeating every week on the
+// weekday until the local time until, inclusive.
+func Weekly(day time.Weekday, until time.Time) string {
+	return fmt.Sprintf("FREQ=WEEKLY;BYDAY=%s;UNTIL=%s", byDay[day], until.Format(localLayout))
+}
+
+// Encode returns the calendar in iCalendar format.
+func (c *Calendar) Encode() []byte {
+	var b bytes.Buffer
+
+	writeLine(&b, "BEGIN:VCALENDAR")
+	writeLine(&b, "VERSION:2.0")
+	writeLine(&b, "PRODID:"+c.ProdID)
+	writeLine(&b, "CALSCALE:GREGORIAN")
+	writeLine(&b, "METHOD:PUBLISH")
+	if c.Name != "" {
+		writeLine(&b, "X-WR-CALNAME:"+escapeText(c.Name))
+	}
+
+	stamp := time.Now().UTC().Format(utcLayout)
+	for _, e := range c.Events {
+		writeLine(&b, "BEGIN:VEVENT")
+		writeLine(&b, "UID:"+e.UID)
+		writeLine(&b, "DTSTAMP:"+stamp)
+		if !e.LastModified.IsZero() {
+			writeLine(&b, "LAST-MODIFIED:"+e.LastModified.UTC().Format(utcLayout))
+		}
+		writeLine(&b, fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+		if e.AllDay {
+			writeLine(&b, "DTSTART;VALUE=DATE:"+e.Start.Format(dateLayout))
+			writeLine(&b, "DTEND;VALUE=DATE:"+e.End.Format(dateLayout))
+		} else if e.UTC {
+			writeLine(&b, "DTSTART:"+e.Start.UTC().Format(utcLayout))
+			writeLine(&b, "DTEND:"+e.End.UTC().Format(utcLayout))
+		} else {
+			writeLine(&b, "DTSTART:"+e.Start.Format(localLayout))
+			writeLine(&b, "DTEND:"+e.End.Format(localLayout))
+		}
+		if e.RRule != "" {
+			writeLine(&b, "RRULE:"+e.RRule)
+		}
+		writeLine(&b, "SUMMARY:"+escapeText(e.Summary))
+		if e.Description != "" {
+			writeLine(&b, "DESCRIPTION:"+escapeText(e.Description))
+		}
+		if e.Location != "" {
+			writeLine(&b, "LOCATION:"+escapeText(e.Location))
+		}
+		writeLine(&b, "END:VEVENT")
+	}
+
+	writeLine(&b, "END:VCALENDAR")
+	return b.Bytes()
+}
+
+// escapeText escapes a TEXT property value.
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// writeLine writes a content line, folded so that no line is longer than 75
+// octets without splitting a UTF-8 sequence.
+func writeLine(b *bytes.Buffer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString(contentLineEnd)
+		// Continuation lines start with a space, which counts towards their length.
+		b.WriteByte(' ')
+		line = line[cut:]
+		limit = maxLineOctets - 1
+	}
+	b.WriteString(line)
+	b.WriteString(contentLineEnd)
+}
@@ -20,13 +20,17 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
-func (m Mailer) Send(recipient, subject, plainBody, htmlBody, token string) error {
+// Send emails a plain text body with an HTML alternative. Any extra headers, such
+// as List-Unsubscribe, are added to the message as-is.
+func (m Mailer) Send(recipient, subject, plainBody, htmlBody string, headers map[string]string) error {
 	msg := mail.NewMessage()
 	msg.SetHeader("To", recipient)
 	msg.SetHeader("From", m.sender)
 	msg.SetHeader("Subject", subject)
+	for key, value := range headers {
+		msg.SetHeader(key, value)
+	}
 	msg.SetBody("text/plain", plainBody)
-	msg.SetBody("token", token)
 	msg.AddAlternative("text/html", htmlBody)
 
 	err := m.dialer.DialAndSend(msg)
@@ -0,0 +1,334 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// face is a TrueType font with the metrics a PDF font descriptor needs, in
+// thousandths of the font size.
+type face struct {
+	name string
+	ttf  []byte
+	font *sfnt.Font
+	upem int
+
+	bbox                       string
+	ascent, descent, capHeight int
+	stemV                      int
+}
+
+// faces are indexed by Font.
+var faces = []*face{
+	mustParse("GoRegular", goregular.TTF, 80),
+	mustParse("GoBold", gobold.TTF, 140),
+}
+
+func mustParse(name string, ttf []byte, stemV int) *face {
+	f, err := sfnt.Parse(ttf)
+	if err != nil {
+		panic(err)
+	}
+
+	fc := &face{name: name, ttf: ttf, font: f, upem: int(f.UnitsPerEm()), stemV: stemV}
+
+	// At a size of one em per unit, the 26.6 metrics are font units times 64.
+	var b sfnt.Buffer
+	ppem := fixed.I(fc.upem)
+	bounds, err := f.Bounds(&b, ppem, font.HintingNone)
+	if err != nil {
+		panic(err)
+	}
+	metrics, err := f.Metrics(&b, ppem, font.HintingNone)
+	if err != nil {
+		panic(err)
+	}
+
+	// sfnt puts y downwards, PDF upwards.
+	fc.bbox = fmt.Sprintf("%d %d %d %d", fc.scale(bounds.Min.X), -fc.scale(bounds.Max.Y), fc.scale(bounds.Max.X), -fc.scale(bounds.Min.Y))
+	fc.ascent = fc.scale(metrics.Ascent)
+	fc.descent = -fc.scale(metrics.Descent)
+	fc.capHeight = fc.scale(metrics.CapHeight)
+	return fc
+}
+
+// scale converts a 26.6 value in font units to thousandths of an em.
+func (f *face) scale(v fixed.Int26_6) int {
+	return int(int64(v) * 1000 / 64 / int64(f.upem))
+}
+
+// glyph returns the glyph for r, and false if the font has none.
+func (f *face) glyph(r rune) (uint16, bool) {
+	var b sfnt.Buffer
+	g, err := f.font.GlyphIndex(&b, r)
+	if err != nil || g == 0 {
+		return 0, false
+	}
+	return uint16(g), true
+}
+
+// widths returns the W array of a CIDFont for the glyphs.
+func (f *face) widths(glyphs map[uint16]rune) string {
+	var b sfnt.Buffer
+	var w []string
+	for _, g := range sortedGlyphs(glyphs) {
+		advance, err := f.font.GlyphAdvance(&b, sfnt.GlyphIndex(g), fixed.I(f.upem), font.HintingNone)
+		if err != nil {
+			advance = 0
+		}
+		w = append(w, fmt.Sprintf("%d [%d]", g, f.scale(advance)))
+	}
+	return strings.Join(w, " ")
+}
+
+func sortedGlyphs(glyphs map[uint16]rune) []uint16 {
+	ids := make([]uint16, 0, len(glyphs))
+	for g := range glyphs {
+		ids = append(ids, g)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// subsetTag returns the six capital letters that prefix the name of a font
+// subset. It is derived from the glyphs so the same text gives the same tag.
+func subsetTag(glyphs map[uint16]rune) string {
+	h := fnv.New32a()
+	for _, g := range sortedGlyphs(glyphs) {
+		binary.Write(h, binary.BigEndian, g)
+	}
+	sum := h.Sum32()
+
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + byte(sum%26)
+		sum /= 26
+	}
+	return string(tag)
+}
+
+// subsetTables are the TrueType tables a PDF reader uses from an embedded font.
+// The character map isn't among them since text is written as glyph IDs.
+var subsetTables = []string{"cvt ", "fpgm", "glyf", "head", "hhea", "hmtx", "loca", "maxp", "prep"}
+
+var errBadFont = errors.New("pdf: malformed TrueType font")
+
+// subset returns the font with only the outlines of the glyphs, those the glyphs
+// are composed of, and the .notdef glyph. The other glyphs keep their IDs and
+// metrics but have no outline, so glyph IDs stay valid.
+func (f *face) subset(glyphs map[uint16]rune) ([]byte, error) {
+	tables := map[string][]byte{}
+	if len(f.ttf) < 12 {
+		return nil, errBadFont
+	}
+	numTables := int(binary.BigEndian.Uint16(f.ttf[4:]))
+	for i := 0; i < numTables; i++ {
+		rec := 12 + 16*i
+		if rec+16 > len(f.ttf) {
+			return nil, errBadFont
+		}
+		tag := string(f.ttf[rec : rec+4])
+		offset := int(binary.BigEndian.Uint32(f.ttf[rec+8:]))
+		length := int(binary.BigEndian.Uint32(f.ttf[rec+12:]))
+		if offset+length > len(f.ttf) {
+			return nil, errBadFont
+		}
+		tables[tag] = f.ttf[offset : offset+length]
+	}
+
+	head, loca, glyf, maxp := tables["head"], tables["loca"], tables["glyf"], tables["maxp"]
+	if len(head) < 54 || len(maxp) < 6 || glyf == nil {
+		return nil, errBadFont
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	longLoca := binary.BigEndian.Uint16(head[50:]) == 1
+
+	outline := func(g int) ([]byte, error) {
+		var start, end int
+		if longLoca {
+			if 4*(g+2) > len(loca) {
+				return nil, errBadFont
+			}
+			start = int(binary.BigEndian.Uint32(loca[4*g:]))
+			end = int(binary.BigEndian.Uint32(loca[4*(g+1):]))
+		} else {
+			if 2*(g+2) > len(loca) {
+				return nil, errBadFont
+			}
+			start = 2 * int(binary.BigEndian.Uint16(loca[2*g:]))
+			end = 2 * int(binary.BigEndian.Uint16(loca[2*(g+1):]))
+		}
+		if start > end || end > len(glyf) {
+			return nil, errBadFont
+		}
+		return glyf[start:end], nil
+	}
+
+	// Keep .notdef and the glyphs used, then add the components of composite
+	// glyphs until there are no more.
+	keep := map[int]bool{0: true}
+	queue := []int{0}
+	for g := range glyphs {
+		if !keep[int(g)] {
+			keep[int(g)] = true
+			queue = append(queue, int(g))
+		}
+	}
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		if g >= numGlyphs {
+			return nil, errBadFont
+		}
+		data, err := outline(g)
+		if err != nil {
+			return nil, err
+		}
+		components, err := glyphComponents(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range components {
+			if !keep[c] {
+				keep[c] = true
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	// The new glyf table holds the kept outlines, each padded to four bytes, and
+	// the new loca table is always in the long format.
+	var newGlyf []byte
+	newLoca := make([]byte, 4*(numGlyphs+1))
+	for g := 0; g < numGlyphs; g++ {
+		binary.BigEndian.PutUint32(newLoca[4*g:], uint32(len(newGlyf)))
+		if !keep[g] {
+			continue
+		}
+		data, err := outline(g)
+		if err != nil {
+			return nil, err
+		}
+		newGlyf = append(newGlyf, data...)
+		for len(newGlyf)%4 != 0 {
+			newGlyf = append(newGlyf, 0)
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[4*numGlyphs:], uint32(len(newGlyf)))
+
+	newHead := append([]byte(nil), head...)
+	binary.BigEndian.PutUint16(newHead[50:], 1)
+	// checkSumAdjustment is set once the whole font is written.
+	binary.BigEndian.PutUint32(newHead[8:], 0)
+
+	tables["glyf"], tables["loca"], tables["head"] = newGlyf, newLoca, newHead
+
+	var tags []string
+	for _, tag := range subsetTables {
+		if tables[tag] != nil {
+			tags = append(tags, tag)
+		}
+	}
+
+	// The offset table, with the binary search fields the format asks for.
+	n := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := 16 << entrySelector
+
+	out := make([]byte, 12+16*n)
+	binary.BigEndian.PutUint32(out, 0x00010000)
+	binary.BigEndian.PutUint16(out[4:], uint16(n))
+	binary.BigEndian.PutUint16(out[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(out[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[10:], uint16(16*n-searchRange))
+
+	headOffset := 0
+	for i, tag := range tags {
+		data := tables[tag]
+		rec := 12 + 16*i
+		copy(out[rec:], tag)
+		binary.BigEndian.PutUint32(out[rec+4:], checksum(data))
+		binary.BigEndian.PutUint32(out[rec+8:], uint32(len(out)))
+		binary.BigEndian.PutUint32(out[rec+12:], uint32(len(data)))
+		if tag == "head" {
+			headOffset = len(out)
+		}
+		out = append(out, data...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	binary.BigEndian.PutUint32(out[headOffset+8:], 0xB1B0AFBA-checksum(out))
+
+	return out, nil
+}
+
+// glyphComponents returns the glyphs a composite glyph is made of, and nothing
+// for a simple one.
+func glyphComponents(data []byte) ([]int, error) {
+	if len(data) < 10 || int16(binary.BigEndian.Uint16(data)) >= 0 {
+		return nil, nil
+	}
+
+	const (
+		argsAreWords   = 0x0001
+		haveScale      = 0x0008
+		moreComponents = 0x0020
+		haveXYScale    = 0x0040
+		haveTwoByTwo   = 0x0080
+	)
+
+	var components []int
+	p := 10
+	for {
+		if p+4 > len(data) {
+			return nil, errBadFont
+		}
+		flags := binary.BigEndian.Uint16(data[p:])
+		components = append(components, int(binary.BigEndian.Uint16(data[p+2:])))
+		p += 4
+
+		if flags&argsAreWords != 0 {
+			p += 4
+		} else {
+			p += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			p += 2
+		case flags&haveXYScale != 0:
+			p += 4
+		case flags&haveTwoByTwo != 0:
+			p += 8
+		}
+
+		if flags&moreComponents == 0 {
+			return components, nil
+		}
+	}
+}
+
+// checksum is the TrueType table checksum: the sum of the data as big-endian
+// 32-bit words, padded with zeros.
+func checksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
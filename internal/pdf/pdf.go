@@ -0,0 +1,244 @@
+// Package pdf writes simple PDF 1.4 documents of text and lines.
+//
+// Text is set in the Go fonts, embedded as TrueType subsets with Identity-H
+// encoding, so any character the fonts have a glyph for comes out as written and
+// can be copied back out of the document. A document with text the fonts can't
+// show isn't written at all: Encode returns ErrMissingGlyph instead.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	ContentType = "application/pdf"
+
+	// PageWidth and PageHeight are the size of an A4 page in points. The origin
+	// is the bottom left corner.
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+// ErrMissingGlyph is returned by Encode when text was written with a character
+// the font has no glyph for.
+var ErrMissingGlyph = errors.New("pdf: font has no glyph for character")
+
+// Font is one of the fonts text can be set in.
+type Font int
+
+const (
+	Regular Font = iota
+	Bold
+)
+
+// Document is a PDF document. Text and lines go on the page last added.
+type Document struct {
+	Title string
+
+	pages []*bytes.Buffer
+	// used holds, for each font, the glyphs written in it and the characters
+	// they stand for.
+	used [2]map[uint16]rune
+	err  error
+}
+
+// AddPage starts a new page.
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *Document) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	return d.pages[len(d.pages)-1]
+}
+
+// Text writes s with its baseline starting at x, y. A character the font has no
+// glyph for makes Encode fail.
+func (d *Document) Text(x, y float64, font Font, size float64, s string) {
+	f := faces[font]
+	if d.used[font] == nil {
+		d.used[font] = map[uint16]rune{}
+	}
+
+	var glyphs strings.Builder
+	glyphs.WriteByte('<')
+	for _, r := range s {
+		g, ok := f.glyph(r)
+		if !ok {
+			if d.err == nil {
+				d.err = fmt.Errorf("%w %q in %s", ErrMissingGlyph, r, f.name)
+			}
+			continue
+		}
+		d.used[font][g] = r
+		fmt.Fprintf(&glyphs, "%04X", g)
+	}
+	glyphs.WriteByte('>')
+
+	fmt.Fprintf(d.page(), "BT /F%d %s Tf %s %s Td %s Tj ET\n", font+1, number(size), number(x), number(y), glyphs.String())
+}
+
+// Line draws a line of the given width from x1, y1 to x2, y2.
+func (d *Document) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page(), "%s w %s %s m %s %s l S\n", number(width), number(x1), number(y1), number(x2), number(y2))
+}
+
+// Encode returns the document in PDF format.
+func (d *Document) Encode() ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	var b bytes.Buffer
+	var offsets []int
+
+	// Objects are numbered from 1 in the order they are written.
+	object := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	stream := func(dict string, data []byte) {
+		object(fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data))
+	}
+
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// The catalog, page tree and info come first, then five objects for each
+	// font used and two for each page.
+	const fontObjects = 5
+	var fonts []Font
+	var resources []string
+	for font, glyphs := range d.used {
+		if len(glyphs) > 0 {
+			resources = append(resources, fmt.Sprintf("/F%d %d 0 R", font+1, 4+fontObjects*len(fonts)))
+			fonts = append(fonts, Font(font))
+		}
+	}
+
+	firstPage := 4 + fontObjects*len(fonts)
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object(fmt.Sprintf("<< /Title %s /Producer (greenlight) >>", textString(d.Title)))
+
+	for _, font := range fonts {
+		f := faces[font]
+		glyphs := d.used[font]
+		n := len(offsets) + 1
+		name := subsetTag(glyphs) + "+" + f.name
+
+		file, err := f.subset(glyphs)
+		if err != nil {
+			return nil, err
+		}
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(file)
+		if err = zw.Close(); err != nil {
+			return nil, err
+		}
+
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+			name, n+1, n+4))
+		object(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /CIDToGIDMap /Identity /W [%s] >>",
+			name, n+2, f.widths(glyphs)))
+		object(fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%s] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV %d /FontFile2 %d 0 R >>",
+			name, f.bbox, f.ascent, f.descent, f.capHeight, f.stemV, n+3))
+		stream(fmt.Sprintf("/Length1 %d /Filter /FlateDecode", len(file)), compressed.Bytes())
+		stream("", toUnicode(glyphs))
+	}
+
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			number(PageWidth), number(PageHeight), strings.Join(resources, " "), firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.Bytes()))
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return b.Bytes(), nil
+}
+
+// number formats a coordinate or size without needless digits.
+func number(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// textString returns s as a PDF text string: a literal if it is plain ASCII, and
+// UTF-16 with a byte order mark otherwise.
+func textString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		default:
+			return utf16String(s)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+func utf16String(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// toUnicode returns the CMap that maps the glyphs back to the characters they
+// were written for, so text can be searched and copied.
+func toUnicode(glyphs map[uint16]rune) []byte {
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+
+	// A bfchar section holds at most 100 entries.
+	ids := sortedGlyphs(glyphs)
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > 100 {
+			n = 100
+		}
+		fmt.Fprintf(&b, "%d beginbfchar\n", n)
+		for _, g := range ids[:n] {
+			u := utf16String(string(glyphs[g]))
+			fmt.Fprintf(&b, "<%04X> <%s\n", g, strings.TrimPrefix(u, "<FEFF"))
+		}
+		b.WriteString("endbfchar\n")
+		ids = ids[n:]
+	}
+
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend")
+	return b.Bytes()
+}
@@ -0,0 +1,103 @@
+// Package storage abstracts where uploaded files live so that handlers don't depend
+// on the local filesystem.
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage stores opaque objects under slash separated keys such as
+// "avatars/42/small".
+type Storage interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	// DeletePrefix removes every object below prefix, e.g. "avatars/42" removes
+	// "avatars/42/small" and "avatars/42/large".
+	DeletePrefix(prefix string) error
+}
+
+// LocalDisk keeps objects as files below a root directory.
+type LocalDisk struct {
+	root string
+}
+
+func NewLocalDisk(root string) (*LocalDisk, error) {
+	err := os.MkdirAll(root, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalDisk{root: root}, nil
+}
+
+func (d *LocalDisk) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", errors.New("storage: invalid key " + key)
+	}
+	return filepath.Join(d.root, filepath.FromSlash(clean)), nil
+}
+
+// Put writes to a temporary file first and renames it into place, so readers never
+// see a partially written object.
+func (d *LocalDisk) Put(key string, r io.Reader) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (d *LocalDisk) Get(key string) (io.ReadCloser, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (d *LocalDisk) DeletePrefix(prefix string) error {
+	path, err := d.path(prefix)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS user_info_search_trgm_idx;
+DROP INDEX IF EXISTS user_info_search_vector_idx;
+ALTER TABLE user_info DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,10 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE user_info ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(surname, '') || ' ' || coalesce(email, ''))
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS user_info_search_vector_idx ON user_info USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS user_info_search_trgm_idx ON user_info
+    USING GIN ((coalesce(name, '') || ' ' || coalesce(surname, '') || ' ' || coalesce(email, '')) gin_trgm_ops);
@@ -0,0 +1 @@
+ALTER TABLE user_info ADD COLUMN IF NOT EXISTS erased_at TIMESTAMP(0) WITH TIME ZONE;
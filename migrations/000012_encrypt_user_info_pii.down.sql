@@ -0,0 +1,5 @@
+-- Sealed rows can't be read without data_key, so they must be decrypted before this
+-- migration is reverted.
+ALTER TABLE user_info DROP CONSTRAINT IF EXISTS user_info_email_index_key;
+ALTER TABLE user_info DROP COLUMN IF EXISTS email_index;
+ALTER TABLE user_info DROP COLUMN IF EXISTS data_key;
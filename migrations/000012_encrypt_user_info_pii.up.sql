@@ -0,0 +1,23 @@
+-- Sealed values are longer than the plaintext, so the PII columns become TEXT. The
+-- generated search column depends on them and has to be rebuilt around the change.
+DROP INDEX IF EXISTS user_info_search_trgm_idx;
+DROP INDEX IF EXISTS user_info_search_vector_idx;
+ALTER TABLE user_info DROP COLUMN IF EXISTS search_vector;
+
+ALTER TABLE user_info
+    ALTER COLUMN name TYPE TEXT,
+    ALTER COLUMN surname TYPE TEXT,
+    ALTER COLUMN email TYPE TEXT;
+
+ALTER TABLE user_info ADD COLUMN IF NOT EXISTS data_key TEXT;
+ALTER TABLE user_info ADD COLUMN IF NOT EXISTS email_index BYTEA;
+ALTER TABLE user_info ADD CONSTRAINT user_info_email_index_key UNIQUE (email_index);
+
+ALTER TABLE user_info ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (
+        to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(surname, '') || ' ' || coalesce(email, ''))
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS user_info_search_vector_idx ON user_info USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS user_info_search_trgm_idx ON user_info
+    USING GIN ((coalesce(name, '') || ' ' || coalesce(surname, '') || ' ' || coalesce(email, '')) gin_trgm_ops);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS user_avatars (
+    user_id BIGINT PRIMARY KEY REFERENCES user_info(id) ON DELETE CASCADE,
+    content_type VARCHAR(50) NOT NULL,
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS groups (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    name VARCHAR(100) NOT NULL,
+    academic_year VARCHAR(9) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT groups_name_academic_year_key UNIQUE (name, academic_year)
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES user_info(id) ON DELETE CASCADE,
+    added_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS group_members_user_id_idx ON group_members(user_id);
+
+CREATE TABLE IF NOT EXISTS group_modules (
+    group_id BIGINT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    module_id BIGINT NOT NULL REFERENCES module_info(id) ON DELETE CASCADE,
+    assigned_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, module_id)
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id BIGINT NOT NULL REFERENCES user_info(id) ON DELETE CASCADE,
+    category VARCHAR(50) NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, category)
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES user_info(id) ON DELETE CASCADE,
+    category VARCHAR(50) NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    read_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_id_created_at_idx ON notifications(user_id, created_at DESC);
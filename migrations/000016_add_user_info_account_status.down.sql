@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS account_events;
+DROP INDEX IF EXISTS user_info_expires_at_idx;
+ALTER TABLE user_info DROP COLUMN IF EXISTS expiry_warned_at;
+ALTER TABLE user_info DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE user_info DROP COLUMN IF EXISTS suspension_reason;
+ALTER TABLE user_info DROP COLUMN IF EXISTS suspended_at;
@@ -0,0 +1,18 @@
+ALTER TABLE user_info ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE user_info ADD COLUMN IF NOT EXISTS suspension_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_info ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE user_info ADD COLUMN IF NOT EXISTS expiry_warned_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS user_info_expires_at_idx ON user_info(expires_at) WHERE expires_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS account_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES user_info(id) ON DELETE CASCADE,
+    actor_id BIGINT REFERENCES user_info(id) ON DELETE SET NULL,
+    action VARCHAR(50) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP(0) WITH TIME ZONE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS account_events_user_id_idx ON account_events(user_id, created_at DESC);
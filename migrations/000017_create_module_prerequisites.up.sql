@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS module_prerequisites (
+    module_id BIGINT NOT NULL REFERENCES module_info(id) ON DELETE CASCADE,
+    prerequisite_id BIGINT NOT NULL REFERENCES module_info(id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (module_id, prerequisite_id),
+    CONSTRAINT module_prerequisites_not_self CHECK (module_id <> prerequisite_id)
+);
+
+CREATE INDEX IF NOT EXISTS module_prerequisites_prerequisite_id_idx ON module_prerequisites(prerequisite_id);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS module_info_revisions (
+    module_id BIGINT NOT NULL REFERENCES module_info(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    module_name VARCHAR(255) NOT NULL,
+    module_duration INTEGER NOT NULL,
+    exam_type VARCHAR(255) NOT NULL,
+    restored_from INTEGER,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (module_id, version)
+);
+
+-- Existing modules start their history with the content they have now.
+INSERT INTO module_info_revisions (module_id, version, module_name, module_duration, exam_type, created_at)
+SELECT id, version, module_name, module_duration, exam_type, updated_at FROM module_info
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS module_reviews;
+
+DROP INDEX IF EXISTS module_info_status_idx;
+ALTER TABLE module_info DROP CONSTRAINT IF EXISTS module_info_status_check;
+ALTER TABLE module_info DROP COLUMN IF EXISTS published_version;
+ALTER TABLE module_info DROP COLUMN IF EXISTS author_id;
+ALTER TABLE module_info DROP COLUMN IF EXISTS status;
@@ -0,0 +1,23 @@
+ALTER TABLE module_info ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'draft';
+ALTER TABLE module_info ADD COLUMN IF NOT EXISTS author_id BIGINT REFERENCES user_info(id) ON DELETE SET NULL;
+ALTER TABLE module_info ADD COLUMN IF NOT EXISTS published_version INTEGER;
+
+ALTER TABLE module_info ADD CONSTRAINT module_info_status_check
+    CHECK (status IN ('draft', 'in_review', 'approved', 'published', 'archived'));
+
+-- Modules created before the workflow existed were live straight away.
+UPDATE module_info SET status = 'published', published_version = version;
+
+CREATE INDEX IF NOT EXISTS module_info_status_idx ON module_info(status);
+
+CREATE TABLE IF NOT EXISTS module_reviews (
+    id BIGSERIAL PRIMARY KEY,
+    module_id BIGINT NOT NULL REFERENCES module_info(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    actor_id BIGINT REFERENCES user_info(id) ON DELETE SET NULL,
+    action VARCHAR(20) NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS module_reviews_module_id_idx ON module_reviews(module_id, created_at);
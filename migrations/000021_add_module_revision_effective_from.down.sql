@@ -0,0 +1,8 @@
+UPDATE module_info SET status = 'approved' WHERE status = 'scheduled';
+
+ALTER TABLE module_info DROP CONSTRAINT IF EXISTS module_info_status_check;
+ALTER TABLE module_info ADD CONSTRAINT module_info_status_check
+    CHECK (status IN ('draft', 'in_review', 'approved', 'published', 'archived'));
+
+DROP INDEX IF EXISTS module_info_revisions_effective_from_idx;
+ALTER TABLE module_info_revisions DROP COLUMN IF EXISTS effective_from;
@@ -0,0 +1,22 @@
+ALTER TABLE module_info_revisions ADD COLUMN IF NOT EXISTS effective_from TIMESTAMP(0) WITH TIME ZONE;
+
+-- Versions that were published took effect when they were published, and those
+-- published before the workflow existed when they were written.
+UPDATE module_info_revisions SET effective_from = module_reviews.created_at
+FROM module_reviews
+WHERE module_reviews.module_id = module_info_revisions.module_id
+AND module_reviews.version = module_info_revisions.version
+AND module_reviews.action = 'publish';
+
+UPDATE module_info_revisions SET effective_from = module_info_revisions.created_at
+FROM module_info
+WHERE module_info.id = module_info_revisions.module_id
+AND module_info.published_version = module_info_revisions.version
+AND module_info_revisions.effective_from IS NULL;
+
+CREATE INDEX IF NOT EXISTS module_info_revisions_effective_from_idx
+    ON module_info_revisions(module_id, effective_from DESC) WHERE effective_from IS NOT NULL;
+
+ALTER TABLE module_info DROP CONSTRAINT IF EXISTS module_info_status_check;
+ALTER TABLE module_info ADD CONSTRAINT module_info_status_check
+    CHECK (status IN ('draft', 'in_review', 'approved', 'scheduled', 'published', 'archived'));
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS module_offerings;
+DROP TABLE IF EXISTS academic_terms;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS academic_terms (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    name VARCHAR(100) NOT NULL,
+    academic_year VARCHAR(9) NOT NULL,
+    starts_on DATE NOT NULL,
+    ends_on DATE NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT academic_terms_name_academic_year_key UNIQUE (name, academic_year),
+    CONSTRAINT academic_terms_dates_check CHECK (starts_on < ends_on)
+);
+
+CREATE TABLE IF NOT EXISTS module_offerings (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    module_id BIGINT NOT NULL REFERENCES module_info(id) ON DELETE CASCADE,
+    term_id BIGINT NOT NULL REFERENCES academic_terms(id) ON DELETE RESTRICT,
+    teacher_id BIGINT REFERENCES user_info(id) ON DELETE SET NULL,
+    capacity INTEGER NOT NULL,
+    starts_on DATE NOT NULL,
+    ends_on DATE NOT NULL,
+    schedule TEXT NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT module_offerings_module_id_term_id_key UNIQUE (module_id, term_id),
+    CONSTRAINT module_offerings_capacity_check CHECK (capacity > 0),
+    CONSTRAINT module_offerings_dates_check CHECK (starts_on <= ends_on)
+);
+
+CREATE INDEX IF NOT EXISTS module_offerings_term_id_idx ON module_offerings(term_id);
+CREATE INDEX IF NOT EXISTS module_offerings_teacher_id_idx ON module_offerings(teacher_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS enrollments;
+ALTER TABLE module_offerings DROP COLUMN IF EXISTS enrollment_deadline;
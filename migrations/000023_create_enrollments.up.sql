@@ -0,0 +1,18 @@
+-- NULL means enrollment stays open until the offering starts.
+ALTER TABLE module_offerings ADD COLUMN IF NOT EXISTS enrollment_deadline TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS enrollments (
+    id BIGSERIAL PRIMARY KEY,
+    offering_id BIGINT NOT NULL REFERENCES module_offerings(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES user_info(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL,
+    requested_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    enrolled_at TIMESTAMP(0) WITH TIME ZONE,
+    dropped_at TIMESTAMP(0) WITH TIME ZONE,
+    CONSTRAINT enrollments_offering_id_user_id_key UNIQUE (offering_id, user_id),
+    CONSTRAINT enrollments_status_check CHECK (status IN ('enrolled', 'waitlisted', 'dropped'))
+);
+
+-- The waitlist is served in the order requests came in.
+CREATE INDEX IF NOT EXISTS enrollments_offering_id_status_idx ON enrollments(offering_id, status, requested_at, id);
+CREATE INDEX IF NOT EXISTS enrollments_user_id_idx ON enrollments(user_id);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS module_sessions (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    offering_id BIGINT NOT NULL REFERENCES module_offerings(id) ON DELETE CASCADE,
+    -- ISO day of the week, 1 is Monday.
+    day_of_week SMALLINT NOT NULL,
+    starts_at TIME(0) NOT NULL,
+    ends_at TIME(0) NOT NULL,
+    room VARCHAR(50) NOT NULL,
+    teacher_id BIGINT REFERENCES user_info(id) ON DELETE SET NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT module_sessions_day_of_week_check CHECK (day_of_week BETWEEN 1 AND 7),
+    CONSTRAINT module_sessions_times_check CHECK (starts_at < ends_at)
+);
+
+CREATE INDEX IF NOT EXISTS module_sessions_offering_id_idx ON module_sessions(offering_id);
+CREATE INDEX IF NOT EXISTS module_sessions_room_idx ON module_sessions(lower(room), day_of_week);
+CREATE INDEX IF NOT EXISTS module_sessions_teacher_id_idx ON module_sessions(teacher_id, day_of_week);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS exam_invigilators;
+DROP TABLE IF EXISTS exam_sessions;
+DROP TABLE IF EXISTS exam_rooms;
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS exam_rooms (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    name VARCHAR(50) NOT NULL,
+    capacity INTEGER NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT exam_rooms_capacity_check CHECK (capacity > 0)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS exam_rooms_name_key ON exam_rooms(lower(name));
+
+-- An offering sits its exam once, in one room.
+CREATE TABLE IF NOT EXISTS exam_sessions (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    offering_id BIGINT NOT NULL REFERENCES module_offerings(id) ON DELETE CASCADE,
+    room_id BIGINT NOT NULL REFERENCES exam_rooms(id) ON DELETE RESTRICT,
+    starts_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    duration_minutes INTEGER NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT exam_sessions_offering_id_key UNIQUE (offering_id),
+    CONSTRAINT exam_sessions_duration_check CHECK (duration_minutes > 0)
+);
+
+CREATE INDEX IF NOT EXISTS exam_sessions_room_id_idx ON exam_sessions(room_id, starts_at);
+CREATE INDEX IF NOT EXISTS exam_sessions_starts_at_idx ON exam_sessions(starts_at);
+
+CREATE TABLE IF NOT EXISTS exam_invigilators (
+    exam_id BIGINT NOT NULL REFERENCES exam_sessions(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES user_info(id) ON DELETE CASCADE,
+    PRIMARY KEY (exam_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS exam_invigilators_user_id_idx ON exam_invigilators(user_id);
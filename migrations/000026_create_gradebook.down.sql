@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS grade_overrides;
+DROP TABLE IF EXISTS assessment_scores;
+DROP TABLE IF EXISTS assessment_components;
+ALTER TABLE module_offerings DROP COLUMN IF EXISTS grades_lock_at;
+ALTER TABLE module_offerings DROP COLUMN IF EXISTS grade_scale_id;
+DROP TABLE IF EXISTS grade_scale_bands;
+DROP TABLE IF EXISTS grade_scales;
@@ -0,0 +1,70 @@
+CREATE TABLE IF NOT EXISTS grade_scales (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    name VARCHAR(100) NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT false,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS grade_scales_name_key ON grade_scales(lower(name));
+-- At most one scale is the default of offerings without one.
+CREATE UNIQUE INDEX IF NOT EXISTS grade_scales_is_default_key ON grade_scales(is_default) WHERE is_default;
+
+-- A final percentage gets the band with the highest min_percent not above it.
+CREATE TABLE IF NOT EXISTS grade_scale_bands (
+    scale_id BIGINT NOT NULL REFERENCES grade_scales(id) ON DELETE CASCADE,
+    min_percent NUMERIC(5,2) NOT NULL,
+    letter VARCHAR(5) NOT NULL,
+    points NUMERIC(4,2) NOT NULL,
+    PRIMARY KEY (scale_id, min_percent),
+    CONSTRAINT grade_scale_bands_min_percent_check CHECK (min_percent BETWEEN 0 AND 100)
+);
+
+ALTER TABLE module_offerings ADD COLUMN IF NOT EXISTS grade_scale_id BIGINT REFERENCES grade_scales(id) ON DELETE SET NULL;
+-- NULL means grades can be changed at any time.
+ALTER TABLE module_offerings ADD COLUMN IF NOT EXISTS grades_lock_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS assessment_components (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    module_id BIGINT NOT NULL REFERENCES module_info(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    weight NUMERIC(5,2) NOT NULL,
+    max_score NUMERIC(7,2) NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT assessment_components_weight_check CHECK (weight > 0 AND weight <= 100),
+    CONSTRAINT assessment_components_max_score_check CHECK (max_score > 0)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS assessment_components_module_id_name_key ON assessment_components(module_id, lower(name));
+
+-- Components can't be deleted while they have scores, unless their module is.
+CREATE TABLE IF NOT EXISTS assessment_scores (
+    component_id BIGINT NOT NULL REFERENCES assessment_components(id),
+    offering_id BIGINT NOT NULL REFERENCES module_offerings(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES user_info(id) ON DELETE CASCADE,
+    score NUMERIC(7,2) NOT NULL,
+    entered_by BIGINT REFERENCES user_info(id) ON DELETE SET NULL,
+    entered_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (offering_id, user_id, component_id),
+    CONSTRAINT assessment_scores_score_check CHECK (score >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS assessment_scores_user_id_idx ON assessment_scores(user_id);
+CREATE INDEX IF NOT EXISTS assessment_scores_component_id_idx ON assessment_scores(component_id);
+
+-- Every change an admin makes to grades after they locked, and why.
+CREATE TABLE IF NOT EXISTS grade_overrides (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    offering_id BIGINT NOT NULL REFERENCES module_offerings(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES user_info(id) ON DELETE CASCADE,
+    component_id BIGINT NOT NULL REFERENCES assessment_components(id) ON DELETE CASCADE,
+    old_score NUMERIC(7,2),
+    new_score NUMERIC(7,2) NOT NULL,
+    admin_id BIGINT REFERENCES user_info(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS grade_overrides_offering_id_idx ON grade_overrides(offering_id, user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS transcript_verifications;
+DROP TABLE IF EXISTS module_completions;
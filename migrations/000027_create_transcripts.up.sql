@@ -0,0 +1,29 @@
+-- A module a student completed, with the final grade and credits they got for it.
+-- The module's name is kept so that the record outlives the module and offering.
+CREATE TABLE IF NOT EXISTS module_completions (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    user_id BIGINT NOT NULL REFERENCES user_info(id) ON DELETE CASCADE,
+    offering_id BIGINT REFERENCES module_offerings(id) ON DELETE SET NULL,
+    module_id BIGINT REFERENCES module_info(id) ON DELETE SET NULL,
+    module_name VARCHAR(255) NOT NULL,
+    term_id BIGINT NOT NULL REFERENCES academic_terms(id) ON DELETE RESTRICT,
+    percent NUMERIC(5,2) NOT NULL,
+    letter VARCHAR(5) NOT NULL,
+    points NUMERIC(4,2) NOT NULL,
+    credits NUMERIC(5,2) NOT NULL,
+    recorded_by BIGINT REFERENCES user_info(id) ON DELETE SET NULL,
+    CONSTRAINT module_completions_offering_id_user_id_key UNIQUE (offering_id, user_id),
+    CONSTRAINT module_completions_credits_check CHECK (credits >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS module_completions_user_id_idx ON module_completions(user_id);
+
+-- Every distinct transcript handed out, by the code that verifies it.
+CREATE TABLE IF NOT EXISTS transcript_verifications (
+    code CHAR(16) PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES user_info(id) ON DELETE CASCADE,
+    issued_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    document JSONB NOT NULL
+);
@@ -0,0 +1,17 @@
+ALTER TABLE module_info ADD COLUMN IF NOT EXISTS module_duration INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE module_info_revisions ADD COLUMN IF NOT EXISTS module_duration INTEGER NOT NULL DEFAULT 0;
+
+UPDATE module_info SET module_duration = round(contact_hours);
+UPDATE module_info_revisions SET module_duration = round(contact_hours);
+
+ALTER TABLE module_info DROP CONSTRAINT IF EXISTS module_info_hours_check;
+
+ALTER TABLE module_info
+    DROP COLUMN IF EXISTS contact_hours,
+    DROP COLUMN IF EXISTS self_study_hours,
+    DROP COLUMN IF EXISTS ects_credits;
+
+ALTER TABLE module_info_revisions
+    DROP COLUMN IF EXISTS contact_hours,
+    DROP COLUMN IF EXISTS self_study_hours,
+    DROP COLUMN IF EXISTS ects_credits;
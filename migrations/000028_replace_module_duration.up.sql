@@ -0,0 +1,25 @@
+ALTER TABLE module_info
+    ADD COLUMN IF NOT EXISTS contact_hours NUMERIC(6,2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS self_study_hours NUMERIC(6,2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS ects_credits NUMERIC(4,1) NOT NULL DEFAULT 0;
+
+ALTER TABLE module_info_revisions
+    ADD COLUMN IF NOT EXISTS contact_hours NUMERIC(6,2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS self_study_hours NUMERIC(6,2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS ects_credits NUMERIC(4,1) NOT NULL DEFAULT 0;
+
+-- module_duration was meant to hold nanoseconds, but an INTEGER can't hold even
+-- three seconds of them, so the values clients managed to store are hours. Those
+-- too large to be the hours of a module are dropped. Self-study hours and credits
+-- were never recorded and start at 0.
+UPDATE module_info
+SET contact_hours = CASE WHEN module_duration BETWEEN 0 AND 9999 THEN module_duration ELSE 0 END;
+
+UPDATE module_info_revisions
+SET contact_hours = CASE WHEN module_duration BETWEEN 0 AND 9999 THEN module_duration ELSE 0 END;
+
+ALTER TABLE module_info DROP COLUMN IF EXISTS module_duration;
+ALTER TABLE module_info_revisions DROP COLUMN IF EXISTS module_duration;
+
+ALTER TABLE module_info
+    ADD CONSTRAINT module_info_hours_check CHECK (contact_hours >= 0 AND self_study_hours >= 0 AND ects_credits >= 0);
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS user_info_search_trigrams_idx;
+DROP INDEX IF EXISTS user_info_search_words_idx;
+DROP INDEX IF EXISTS user_info_surname_index_idx;
+DROP INDEX IF EXISTS user_info_name_index_idx;
+
+ALTER TABLE user_info
+    DROP COLUMN IF EXISTS search_trigrams,
+    DROP COLUMN IF EXISTS search_words,
+    DROP COLUMN IF EXISTS surname_index,
+    DROP COLUMN IF EXISTS name_index;
@@ -0,0 +1,15 @@
+-- search_vector and the trigram index only see ciphertext once rows are sealed, so
+-- sealed rows are searched through blind tokens instead: keyed hashes of the
+-- lowercased name and surname for the exact filters, and of the words and trigrams
+-- of name, surname and email for ?q= search. Rows sealed before this migration get
+-- their tokens from the rotatekeys command.
+ALTER TABLE user_info
+    ADD COLUMN IF NOT EXISTS name_index BYTEA,
+    ADD COLUMN IF NOT EXISTS surname_index BYTEA,
+    ADD COLUMN IF NOT EXISTS search_words TEXT[],
+    ADD COLUMN IF NOT EXISTS search_trigrams TEXT[];
+
+CREATE INDEX IF NOT EXISTS user_info_name_index_idx ON user_info (name_index);
+CREATE INDEX IF NOT EXISTS user_info_surname_index_idx ON user_info (surname_index);
+CREATE INDEX IF NOT EXISTS user_info_search_words_idx ON user_info USING GIN (search_words);
+CREATE INDEX IF NOT EXISTS user_info_search_trigrams_idx ON user_info USING GIN (search_trigrams);
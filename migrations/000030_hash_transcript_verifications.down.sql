@@ -0,0 +1,7 @@
+-- The documents themselves are gone, so the rows can't be restored.
+DELETE FROM transcript_verifications;
+
+DROP INDEX IF EXISTS transcript_verifications_user_id_idx;
+
+ALTER TABLE transcript_verifications ADD COLUMN IF NOT EXISTS document JSONB NOT NULL;
+ALTER TABLE transcript_verifications DROP COLUMN IF EXISTS document_hash;
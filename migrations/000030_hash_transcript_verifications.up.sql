@@ -0,0 +1,7 @@
+-- Issued transcripts are kept as a SHA-256 of the document only, which is enough to
+-- check a transcript someone presents. Rows issued before this have no hash, but
+-- their code is the first 8 bytes of it, which still lets them be checked.
+ALTER TABLE transcript_verifications ADD COLUMN IF NOT EXISTS document_hash BYTEA;
+ALTER TABLE transcript_verifications DROP COLUMN IF EXISTS document;
+
+CREATE INDEX IF NOT EXISTS transcript_verifications_user_id_idx ON transcript_verifications(user_id);